@@ -0,0 +1,224 @@
+package disk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Writes every file to w as a tar archive, one regular file entry per file
+// carrying its name, size and modification time. Contents are streamed
+// from the disk a block at a time.
+// Scope: exported
+func (d *Disk) ExportTar(w io.Writer) error {
+	entries, err := d.Entries()
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.Name,
+			Size:     int64(entry.Size),
+			Mode:     int64(entry.Mode),
+			ModTime:  entry.ModTime,
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err = d.exportFile(entry.Name, tw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Creates a file for every regular file entry of the tar archive read from
+// r, replacing any existing file of the same name. Directory entries are
+// skipped; names the filesystem can't store fail with InvalidFilenameError.
+// Scope: exported
+func (d *Disk) ImportTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err = d.importFile(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Writes every file to w as a zip archive, deflating each one and keeping
+// its modification time
+// Scope: exported
+func (d *Disk) ExportZip(w io.Writer) error {
+	entries, err := d.Entries()
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+		header.Modified = entry.ModTime
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err = d.exportFile(entry.Name, fw); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Creates a file for every file in the zip archive read from r, replacing
+// any existing file of the same name; directories are skipped. The archive
+// is read into memory, since zip needs random access. Fails with a
+// TooManyFilesError, importing nothing, if the new files wouldn't fit in
+// the root directory.
+// Scope: exported
+func (d *Disk) ImportZip(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	var files []*zip.File
+	free, added := 0, make(map[string]bool)
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		if rootBuff[i] == 0 {
+			free++
+		}
+	}
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		files = append(files, zf)
+		if d.findRootEntry(rootBuff, zf.Name) < 0 {
+			added[d.normName(zf.Name)] = true
+		}
+	}
+	if len(added) > free {
+		return TooManyFilesError{len(added), free}
+	}
+	for _, zf := range files {
+		fr, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = d.importFile(zf.Name, fr)
+		fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies the file with given filename out to a new host file at hostPath,
+// streaming it a block at a time. An existing host file isn't overwritten;
+// if the copy fails, the partly written host file is removed again.
+// Scope: exported
+func (d *Disk) ExtractTo(filename, hostPath string) error {
+	file, err := d.Open(filename)
+	if err != nil {
+		return err
+	}
+	host, err := os.OpenFile(hostPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	_, err = file.WriteTo(host)
+	file.Close()
+	// a failed close can mean the data never reached the host disk
+	if cerr := host.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(hostPath)
+		return err
+	}
+	return nil
+}
+
+// Copies the host file at hostPath into a new file with given filename,
+// which must not exist yet. If reading the host file or writing the disk
+// fails, e.g. with a FullDiskError, the partly imported file is removed
+// again.
+// Scope: exported
+func (d *Disk) ImportFrom(hostPath, filename string) error {
+	host, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer host.Close()
+	file, err := d.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := d.newWriter(file)
+	_, err = io.Copy(w, host)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		d.Remove(filename)
+		return err
+	}
+	return nil
+}
+
+// Streams the contents of the file with given filename to w
+// Scope: internal
+func (d *Disk) exportFile(filename string, w io.Writer) error {
+	file, err := d.Open(filename)
+	if err != nil {
+		return err
+	}
+	err = file.streamBlocks(func(data []byte) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Replaces the contents of the file with given filename with everything
+// read from r, creating it if necessary
+// Scope: internal
+func (d *Disk) importFile(filename string, r io.Reader) error {
+	w, err := d.OpenWriter(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,181 @@
+package disk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDisk_ExportTar(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFiles := map[string][]byte{
+		"a.txt":     []byte("alpha"),
+		"b.txt":     bytes.Repeat([]byte("beta "), BlockSize),
+		"empty.txt": {},
+	}
+	d, _ := New(tDiskFilename, tBlockCt)
+	for name, data := range tFiles {
+		d.WriteFile(name, data)
+	}
+	// Test
+	var archive bytes.Buffer
+	if err := d.ExportTar(&archive); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	count := 0
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		data, _ := ioutil.ReadAll(tr)
+		if !bytes.Equal(data, tFiles[header.Name]) {
+			t.Errorf("Expected %s to hold %v bytes, Got %v", header.Name, len(tFiles[header.Name]), len(data))
+		}
+		count++
+	}
+	if count != len(tFiles) {
+		t.Errorf("Expected %v tar entries, Got %v", len(tFiles), count)
+	}
+	d.Close()
+	os.Remove(tDiskFilename)
+	// the archive unpacks into an equivalent disk
+	d, _ = New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", []byte("replaced on import"))
+	if err := d.ImportTar(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Error(err)
+	}
+	for name, data := range tFiles {
+		if got, err := d.ReadFile(name); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Expected imported %s to hold %v bytes, Got %v, %v", name, len(data), len(got), err)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ExportZip(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFiles := map[string][]byte{
+		"a.txt": []byte("alpha"),
+		"b.txt": bytes.Repeat([]byte("beta "), BlockSize),
+	}
+	d, _ := New(tDiskFilename, tBlockCt)
+	for name, data := range tFiles {
+		d.WriteFile(name, data)
+	}
+	// Test
+	var archive bytes.Buffer
+	if err := d.ExportZip(&archive); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(tFiles) {
+		t.Errorf("Expected %v zip entries, Got %v", len(tFiles), len(zr.File))
+	}
+	d.Close()
+	os.Remove(tDiskFilename)
+	d, _ = New(tDiskFilename, tBlockCt)
+	if err = d.ImportZip(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Error(err)
+	}
+	for name, data := range tFiles {
+		if got, err := d.ReadFile(name); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Expected imported %s to hold %v bytes, Got %v, %v", name, len(data), len(got), err)
+		}
+	}
+	// more new files than free entries imports nothing
+	var crowded bytes.Buffer
+	zw := zip.NewWriter(&crowded)
+	for i := 0; i <= BlockSize/RootEntrySize; i++ {
+		zw.Create(fmt.Sprintf("f%v", i))
+	}
+	zw.Close()
+	if _, ok := d.ImportZip(&crowded).(TooManyFilesError); !ok {
+		t.Errorf("Expected TooManyFilesError")
+	}
+	if entries, _ := d.Entries(); len(entries) != len(tFiles) {
+		t.Errorf("Expected %v files after refused import, Got %v", len(tFiles), len(entries))
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ExtractTo(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tHostFilename := "extracted.txt"
+	tData := bytes.Repeat([]byte("extract "), BlockSize/2)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", tData)
+	// Test
+	if err := d.ExtractTo("test.txt", tHostFilename); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(tHostFilename); !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes extracted, Got %v", len(tData), len(got))
+	}
+	if d.checkIsOpen("test.txt") {
+		t.Errorf("Expected the file released after extracting")
+	}
+	// an existing host file is left alone
+	if err := d.ExtractTo("test.txt", tHostFilename); !os.IsExist(err) {
+		t.Errorf("Expected an error for an existing host file, Got %v", err)
+	}
+	if _, ok := d.ExtractTo("missing.txt", "missing.txt").(FileNotFoundError); !ok {
+		t.Errorf("Expected FileNotFoundError for a missing file")
+	}
+	if _, err := os.Stat("missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected no host file created for a missing file")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tHostFilename)
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ImportFrom(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tHostFilename := "import.txt"
+	tData := bytes.Repeat([]byte("import "), BlockSize)
+	ioutil.WriteFile(tHostFilename, tData, 0644)
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if err := d.ImportFrom(tHostFilename, "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.ReadFile("test.txt"); !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes imported, Got %v", len(tData), len(got))
+	}
+	if _, ok := d.ImportFrom(tHostFilename, "test.txt").(FileAlreadyExistsError); !ok {
+		t.Errorf("Expected FileAlreadyExistsError importing over a file")
+	}
+	if err := d.ImportFrom("missing.txt", "other.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error for a missing host file, Got %v", err)
+	}
+	// a host file too large for the disk is removed again
+	free, _ := d.FreeBlocks()
+	ioutil.WriteFile(tHostFilename, make([]byte, (free+1)*BlockSize), 0644)
+	if _, ok := d.ImportFrom(tHostFilename, "big.txt").(FullDiskError); !ok {
+		t.Errorf("Expected FullDiskError importing a file larger than the disk")
+	}
+	if _, err := d.ReadFile("big.txt"); err == nil {
+		t.Errorf("Expected the partial import removed")
+	}
+	if after, _ := d.FreeBlocks(); after != free {
+		t.Errorf("Expected %v free blocks after cleanup, Got %v", free, after)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tHostFilename)
+	os.Remove(tDiskFilename)
+}
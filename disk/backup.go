@@ -0,0 +1,148 @@
+package disk
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const BackupVersion = 4
+
+// Reports how many blocks past the journal hold a backup of the
+// superblock. Disks older than BackupVersion have none.
+// Scope: internal
+func (d *Disk) backupBlockCt() int {
+	if d.version < BackupVersion {
+		return 0
+	}
+	return 1
+}
+
+// Reports the absolute index of the superblock copy in use: the backup in
+// the last block while the primary is damaged, the primary otherwise
+// Scope: internal
+func (d *Disk) superblockInd() int {
+	if d.fromBackup {
+		return d.blockCt - 1
+	}
+	return 0
+}
+
+// Writes a superblock image to the primary location and then, on disks
+// that keep one, to the backup in the last block. The primary goes first,
+// so a crash in between leaves a valid primary that is newer than the
+// backup. During a transaction only the primary is written, and held; the
+// backup follows when the transaction commits it.
+// Scope: internal
+func (d *Disk) writeSuperblock(superblock []byte) error {
+	if _, err := d.fd.WriteAt(superblock, 0); err != nil {
+		return err
+	}
+	d.fromBackup = false
+	if d.tx != nil {
+		return nil
+	}
+	// the backup is placed by the image's own layout, which may have just
+	// been edited; a layout that doesn't add up gets no backup written
+	var layout Disk
+	layout.decodeSuperblock(superblock)
+	end := layout.sizeTableInd() + layout.sizeTableBlockCt()
+	if layout.backupBlockCt() == 0 || layout.blockCt != end+1 {
+		return nil
+	}
+	_, err := d.fd.WriteAt(superblock, int64((layout.blockCt-1)*BlockSize))
+	return err
+}
+
+// Loads the backup superblock in place of the primary when the primary's
+// checksum doesn't match, e.g. after a torn write or a stray overwrite.
+// The primary's block count can't be trusted then, so the backup is looked
+// for in the last block of the device. A backup that fails its own
+// checksum, signature or block count is ignored, leaving the primary to be
+// judged as before.
+// Returns: (whether the backup was loaded, any error encountered)
+// Scope: internal
+func (d *Disk) fallBackToBackup() (bool, error) {
+	primary := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(primary, 0); err != nil {
+		return false, err
+	}
+	if superblockCrcValid(primary) {
+		return false, nil
+	}
+	fStat, err := d.fd.Stat()
+	if err != nil {
+		return false, err
+	}
+	last := fStat.Size()/BlockSize - 1
+	if last < 1 {
+		return false, nil
+	}
+	backup := make([]byte, BlockSize)
+	if _, err = d.fd.ReadAt(backup, last*BlockSize); err != nil {
+		return false, err
+	}
+	if !superblockCrcValid(backup) {
+		return false, nil
+	}
+	var candidate Disk
+	candidate.decodeSuperblock(backup)
+	if candidate.sig != SbSig || candidate.backupBlockCt() == 0 || int64(candidate.blockCt) != last+1 {
+		return false, nil
+	}
+	d.decodeSuperblock(backup)
+	d.fromBackup = true
+	return true, nil
+}
+
+// Checks a superblock image against its checksum, read in the byte order
+// the image records
+// Scope: internal
+func superblockCrcValid(superblock []byte) bool {
+	var order binary.ByteOrder = binary.LittleEndian
+	if superblock[SbByteOrderOffset] == ByteOrderBigEndian {
+		order = binary.BigEndian
+	}
+	stored := order.Uint32(superblock[SbCrcOffset:(SbCrcOffset + SbCrcSize)])
+	return stored == crc32.ChecksumIEEE(superblock[:SbCrcOffset])
+}
+
+// Reports whether the disk was mounted from its backup superblock because
+// the primary failed its checksum. Until the primary is rewritten, by
+// RestorePrimarySuperblock or any update of the superblock, the disk is
+// run from the backup.
+// Scope: exported
+func (d *Disk) UsingBackupSuperblock() bool {
+	return d.fromBackup
+}
+
+// Rewrites the primary superblock from the backup in the disk's last
+// block, repairing a primary that Mount found damaged. The backup is
+// written after the primary on every update, so if a crash came between
+// the two it lacks the newest change; restoring is meant for a damaged
+// primary, not a second copy to prefer. Disks older than BackupVersion
+// have no backup to restore from.
+// Scope: exported
+func (d *Disk) RestorePrimarySuperblock() error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.backupBlockCt() == 0 {
+		return CustomError{"Disk has no backup superblock"}
+	}
+	backup := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(backup, int64((d.blockCt-1)*BlockSize)); err != nil {
+		return err
+	}
+	if !superblockCrcValid(backup) {
+		stored := d.byteOrder().Uint32(backup[SbCrcOffset:(SbCrcOffset + SbCrcSize)])
+		return SuperblockChecksumError{stored, crc32.ChecksumIEEE(backup[:SbCrcOffset])}
+	}
+	if _, err := d.fd.WriteAt(backup, 0); err != nil {
+		return err
+	}
+	if err := d.sync(); err != nil {
+		return err
+	}
+	d.fromBackup = false
+	return d.readSuperblock()
+}
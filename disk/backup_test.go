@@ -0,0 +1,110 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDisk_BackupSuperblock(t *testing.T) {
+	// Setup
+	tFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tFilename, tBlockCt)
+	d.WriteFile("kept.txt", []byte("still here"))
+	d.SetMetadata("owner", "alice")
+	backupOffset := int64((d.blockCt - 1) * BlockSize)
+	// Test
+	t.Run("copies", func(t *testing.T) {
+		primary, backup := make([]byte, BlockSize), make([]byte, BlockSize)
+		d.fd.ReadAt(primary, 0)
+		d.fd.ReadAt(backup, backupOffset)
+		if !bytes.Equal(primary, backup) {
+			t.Errorf("Expected the backup to match the primary after SetMetadata")
+		}
+	})
+	// a stray overwrite of the primary, leaving its checksum stale
+	d.fd.WriteAt(bytes.Repeat([]byte{0xAB}, 64), 0)
+	d.Close()
+	t.Run("mount", func(t *testing.T) {
+		d, err := Mount(tFilename)
+		if err != nil {
+			t.Fatalf("Expected to mount from the backup, Got %v", err)
+		}
+		defer d.Close()
+		if !d.UsingBackupSuperblock() {
+			t.Errorf("Expected UsingBackupSuperblock true, Got false")
+		}
+		if !d.Health().BackupSuper {
+			t.Errorf("Expected Health to report the backup superblock")
+		}
+		if data, err := d.ReadFile("kept.txt"); err != nil || string(data) != "still here" {
+			t.Errorf("Expected %q, Got %q, %v", "still here", data, err)
+		}
+		if owner, _, _ := d.GetMetadata("owner"); owner != "alice" {
+			t.Errorf("Expected metadata %q, Got %q", "alice", owner)
+		}
+		if err = d.RestorePrimarySuperblock(); err != nil {
+			t.Fatal(err)
+		}
+		if d.UsingBackupSuperblock() {
+			t.Errorf("Expected the primary in use after RestorePrimarySuperblock")
+		}
+		if err = d.validateSuperblock(); err != nil {
+			t.Errorf("Expected a valid primary, Got %v", err)
+		}
+	})
+	t.Run("update", func(t *testing.T) {
+		// setting metadata while running from the backup rewrites the primary
+		d, _ := Mount(tFilename)
+		d.fd.WriteAt([]byte{0xFF}, SbDataBlockCtOffset)
+		d.Close()
+		d, _ = Mount(tFilename)
+		if !d.UsingBackupSuperblock() {
+			t.Fatalf("Expected UsingBackupSuperblock true, Got false")
+		}
+		if err := d.SetMetadata("owner", "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if d.UsingBackupSuperblock() {
+			t.Errorf("Expected the primary in use after SetMetadata")
+		}
+		d.Close()
+		d, _ = Mount(tFilename)
+		if err := d.validateSuperblock(); err != nil {
+			t.Errorf("Expected a valid primary, Got %v", err)
+		}
+		if owner, _, _ := d.GetMetadata("owner"); owner != "bob" {
+			t.Errorf("Expected metadata %q, Got %q", "bob", owner)
+		}
+		d.Close()
+	})
+	t.Run("damagedBackup", func(t *testing.T) {
+		// with both copies damaged the primary is judged as before
+		fd, _ := os.OpenFile(tFilename, os.O_RDWR, 0)
+		fd.WriteAt([]byte("NEWFA\x00FS"), 0)
+		fd.WriteAt([]byte{0xFF}, backupOffset+SbDataBlockCtOffset)
+		fd.Close()
+		if _, err := Mount(tFilename); err == nil {
+			t.Errorf("Expected an error with both superblocks damaged, Got nil")
+		} else if _, ok := err.(InvalidSignatureError); !ok {
+			t.Errorf("Expected InvalidSignatureError, Got %v", err)
+		}
+	})
+	// Teardown
+	os.Remove(tFilename)
+	t.Run("oldVersion", func(t *testing.T) {
+		// Setup
+		d, _ := New(tFilename, tBlockCt)
+		d.SetSuperblockField(SbVersionOffset, []byte{BackupVersion - 1})
+		// Test
+		if d.backupBlockCt() != 0 {
+			t.Errorf("Expected no backup block for version %v", BackupVersion-1)
+		}
+		if err := d.RestorePrimarySuperblock(); err == nil {
+			t.Errorf("Expected an error restoring without a backup, Got nil")
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tFilename)
+	})
+}
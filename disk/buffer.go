@@ -0,0 +1,148 @@
+package disk
+
+// Data an open handle holds in memory: the contents of a compressed file,
+// or the unstored remainder of a writer from OpenWriter
+type bufferHolder interface {
+	bufferedBytes() int
+	dirtyBuffer() bool
+	evictBuffer() error
+}
+
+// Handles holding buffered data, bounded in total by a limit
+type bufferPool struct {
+	limit   int            // cap on bytes buffered across handles, 0 for none
+	holders []bufferHolder // handles holding buffered data, least recently used first
+}
+
+// Caps the bytes buffered across all open handles, however many there are.
+// Once the total passes limit, the least recently used buffers are dropped
+// until it fits, clean ones first: a compressed file's contents are stored
+// if changed and decompressed again on next use, and a writer's remainder
+// is stored early. The buffer in use by the call that passed the limit is
+// kept, so one handle may still hold more than limit on its own. A buffer
+// that fails to store stays in memory, and the failure surfaces when its
+// handle is closed. A limit of 0 removes the cap.
+// Returns: any error storing buffers dropped to meet the new limit
+// Scope: exported
+func (d *Disk) SetBufferLimit(limit int) error {
+	if limit < 0 {
+		return CustomError{"Buffer limit must not be negative"}
+	}
+	d.pool.limit = limit
+	return d.pool.trim(nil)
+}
+
+// Reports the cap set by SetBufferLimit, 0 if there is none
+// Scope: exported
+func (d *Disk) BufferLimit() int {
+	return d.pool.limit
+}
+
+// Reports the bytes held in memory by open handles, across compressed
+// files and writers from OpenWriter
+// Scope: exported
+func (d *Disk) BufferedBytes() int {
+	total := 0
+	for _, h := range d.pool.holders {
+		total += h.bufferedBytes()
+	}
+	return total
+}
+
+// Marks a holder's buffer as just used, then drops older buffers if the
+// pool is over its limit. Errors dropping them are left for the holders'
+// Close to report, since the caller's own operation has succeeded.
+// Scope: internal
+func (p *bufferPool) touch(h bufferHolder) {
+	p.release(h)
+	p.holders = append(p.holders, h)
+	p.trim(h)
+}
+
+// Stops tracking a holder, once its buffer is gone
+// Scope: internal
+func (p *bufferPool) release(h bufferHolder) {
+	for i, held := range p.holders {
+		if held == h {
+			p.holders = append(p.holders[:i], p.holders[i+1:]...)
+			return
+		}
+	}
+}
+
+// Drops the least recently used buffers, clean ones first, until the pool
+// fits its limit, never dropping keep
+// Returns: the first error storing a dirty buffer, which stops the trim
+// Scope: internal
+func (p *bufferPool) trim(keep bufferHolder) error {
+	if p.limit == 0 {
+		return nil
+	}
+	total := 0
+	for _, h := range p.holders {
+		total += h.bufferedBytes()
+	}
+	for total > p.limit {
+		victim := -1
+		for i, h := range p.holders {
+			if h == keep {
+				continue
+			}
+			if !h.dirtyBuffer() {
+				victim = i
+				break
+			}
+			if victim < 0 {
+				victim = i
+			}
+		}
+		if victim < 0 {
+			return nil
+		}
+		h := p.holders[victim]
+		size := h.bufferedBytes()
+		if err := h.evictBuffer(); err != nil {
+			return err
+		}
+		p.holders = append(p.holders[:victim], p.holders[victim+1:]...)
+		total -= size
+	}
+	return nil
+}
+
+func (f *File) bufferedBytes() int {
+	return len(f.plain)
+}
+
+func (f *File) dirtyBuffer() bool {
+	return f.dirty
+}
+
+// Stores a compressed file's changed contents and drops them from memory,
+// to be decompressed again on next use
+// Scope: internal
+func (f *File) evictBuffer() error {
+	if err := f.flushCompressed(); err != nil {
+		return err
+	}
+	f.plain = nil
+	return nil
+}
+
+func (w *fileWriter) bufferedBytes() int {
+	return len(w.buff)
+}
+
+func (w *fileWriter) dirtyBuffer() bool {
+	return len(w.buff) > 0
+}
+
+// Stores a writer's whole remainder ahead of filling a block
+// Scope: internal
+func (w *fileWriter) evictBuffer() error {
+	if err := w.flush(len(w.buff)); err != nil {
+		return err
+	}
+	w.buff = nil
+	return nil
+}
@@ -0,0 +1,85 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDisk_SetBufferLimit(t *testing.T) {
+	// Setup
+	tFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tFilename, tBlockCt)
+	tData := bytes.Repeat([]byte("compressible text "), 100)
+	// Test
+	if err := d.SetBufferLimit(-1); err == nil {
+		t.Errorf("Expected an error for a negative limit, Got nil")
+	}
+	a, _ := d.CreateCompressed("a.txt")
+	b, _ := d.CreateCompressed("b.txt")
+	a.Write(tData)
+	b.Write(tData)
+	w, _ := d.OpenWriter("c.txt")
+	w.Write([]byte("tail"))
+	if got, exp := d.BufferedBytes(), 2*len(tData)+4; got != exp {
+		t.Errorf("Expected %v buffered bytes, Got %v", exp, got)
+	}
+	// all three are dirty, so the oldest is stored to make room
+	if err := d.SetBufferLimit(len(tData) + 4); err != nil {
+		t.Fatal(err)
+	}
+	if d.BufferLimit() != len(tData)+4 {
+		t.Errorf("Expected limit %v, Got %v", len(tData)+4, d.BufferLimit())
+	}
+	if a.plain != nil || b.plain == nil {
+		t.Errorf("Expected only the oldest buffer dropped")
+	}
+	if got, exp := d.BufferedBytes(), len(tData)+4; got != exp {
+		t.Errorf("Expected %v buffered bytes, Got %v", exp, got)
+	}
+	// reading a brings it back, and b, older now, goes before the writer
+	got := make([]byte, len(tData))
+	if _, err := a.ReadAt(got, 0); err != nil || !bytes.Equal(got, tData) {
+		t.Errorf("Expected evicted contents to reload, Got %v", err)
+	}
+	if b.plain != nil {
+		t.Errorf("Expected the least recently used buffer dropped")
+	}
+	if info, _ := d.Stat("b.txt"); info.Size() != int64(len(tData)) {
+		t.Errorf("Expected the dropped buffer stored, Got size %v", info.Size())
+	}
+	// a clean buffer goes before an older dirty one
+	a.Close()
+	d.SetBufferLimit(0)
+	clean, _ := d.Open("a.txt")
+	clean.ReadAt(got, 0)
+	b.Write([]byte("more"))
+	d.SetBufferLimit(len(tData) + 8)
+	if clean.plain != nil || b.plain == nil {
+		t.Errorf("Expected the clean buffer dropped first")
+	}
+	// the writer's remainder is stored early when it is what's left to drop
+	d.SetBufferLimit(1)
+	if got := d.BufferedBytes(); got != 0 {
+		t.Errorf("Expected 0 buffered bytes, Got %v", got)
+	}
+	if info, _ := d.Stat("c.txt"); info.Size() != 4 {
+		t.Errorf("Expected the writer's remainder stored, Got size %v", info.Size())
+	}
+	w.Write([]byte("!"))
+	w.Close()
+	if data, _ := d.ReadFile("c.txt"); string(data) != "tail!" {
+		t.Errorf("Expected %q, Got %q", "tail!", data)
+	}
+	b.Close()
+	if data, _ := d.ReadFile("b.txt"); !bytes.Equal(data, append(tData, "more"...)) {
+		t.Errorf("Expected b.txt kept whole")
+	}
+	d.CloseAll()
+	if got := d.BufferedBytes(); got != 0 {
+		t.Errorf("Expected 0 buffered bytes after CloseAll, Got %v", got)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tFilename)
+}
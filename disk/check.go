@@ -0,0 +1,333 @@
+package disk
+
+// Key under which DiskUsageByFile reports allocated blocks that belong to
+// no file. Filenames can't contain slashes, so it never names a file.
+const UsageOrphanedKey = "/orphaned"
+
+// Finds files whose FAT chains share data blocks, where writing one file
+// overwrites the other. Each pair is reported once, ordered as the files
+// appear in the root directory. Chains that are corrupt in other ways are
+// checked as far as they can be followed.
+// Returns: (pairs of cross-linked filenames, any error encountered)
+// Scope: exported
+func (d *Disk) CrossLinks() ([][2]string, error) {
+	entries, err := d.entries(true)
+	if err != nil {
+		return nil, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return nil, err
+	}
+	// first file found claiming each block
+	owners := make(map[int]string)
+	reported := make(map[[2]string]bool)
+	var pairs [][2]string
+	for _, entry := range entries {
+		// a looping chain still yields the blocks it reached
+		blocks, _ := d.chainBlocks(fatBuff, entry.StartBlock)
+		for _, block := range blocks {
+			owner, claimed := owners[block]
+			if !claimed {
+				owners[block] = entry.Name
+				continue
+			}
+			pair := [2]string{owner, entry.Name}
+			if owner != entry.Name && !reported[pair] {
+				reported[pair] = true
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// Repairs root directory size fields left inconsistent with their chains,
+// e.g. by a crash between extending a chain and storing the new size. Where
+// the size table holds a valid record for the entry, the size it records
+// is exact. Otherwise a chain of n blocks holds at least (n-1)*BlockSize
+// and at most n*BlockSize bytes, so sizes outside that range are clamped
+// to it; without a record the fill of the last block can't be told, so
+// recovered sizes may include trailing zeros. Compressed files, whose size
+// is the uncompressed length, and open files are left alone. Chains that
+// can't be followed are reported and their sizes left unchanged.
+// Returns: any errors encountered, combined in a MultiError
+// Scope: exported
+func (d *Disk) RepairSizes() error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	changed := false
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		entry := rootBuff[i : i+RootEntrySize]
+		if entry[0] == 0 || entry[RootEntryAttrOffset]&AttrCompressed != 0 {
+			continue
+		}
+		decoded := d.decodeEntry(entry)
+		if d.checkIsOpen(decoded.Name) {
+			errs = append(errs, FileAlreadyInUseError{decoded.Name})
+			continue
+		}
+		blocks, err := d.chainBlocks(fatBuff, decoded.StartBlock)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// an empty file still owns its start block, so low is never negative
+		size, low, high := decoded.Size, (len(blocks)-1)*BlockSize, len(blocks)*BlockSize
+		if size > high {
+			size = high
+		} else if size < low {
+			size = low
+		}
+		if d.sizeTableBlockCt() > 0 {
+			recorded, found, err := d.readSizeRecord(i/RootEntrySize, blocks)
+			if err != nil {
+				return err
+			}
+			if found {
+				size = recorded
+			}
+		}
+		if size != decoded.Size {
+			d.byteOrder().PutUint32(entry[RootEntryFilenameSize:RootEntryFilenameSize+RootEntrySizeFieldSize], uint32(size))
+			changed = true
+		}
+	}
+	if changed {
+		if err = d.writeMeta(metaWrite{d.rootDirInd, rootBuff}); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return MultiError{errs}
+	}
+	return nil
+}
+
+// Attributes every allocated data block to the file whose chain holds it.
+// Blocks shared by several chains count toward the first file only, and
+// allocated blocks reached by no chain are counted under UsageOrphanedKey.
+// Returns: (blocks used by each file, any error encountered)
+// Scope: exported
+func (d *Disk) DiskUsageByFile() (map[string]int, error) {
+	entries, err := d.entries(true)
+	if err != nil {
+		return nil, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]int)
+	owned := make(map[int]bool)
+	for _, entry := range entries {
+		// every file is listed, even one left owning no blocks
+		usage[entry.Name] += 0
+		// a looping chain still yields the blocks it reached
+		blocks, _ := d.chainBlocks(fatBuff, entry.StartBlock)
+		for _, block := range blocks {
+			if !owned[block] {
+				owned[block] = true
+				usage[entry.Name]++
+			}
+		}
+	}
+	for block := 0; block < d.dataBlockCt; block++ {
+		used := d.byteOrder().Uint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize]) != FatEntryUnused
+		if used && !owned[block] {
+			usage[UsageOrphanedKey]++
+		}
+	}
+	return usage, nil
+}
+
+// Compares the contents of the file with given filename against expected,
+// streaming it a block at a time. On a mismatch, the returned
+// ContentMismatchError gives the first offset at which they differ, which
+// for differing lengths is where the shorter one ends.
+// Returns: (whether the contents match, any mismatch or error encountered)
+// Scope: exported
+func (d *Disk) VerifyFile(filename string, expected []byte) (bool, error) {
+	file, err := d.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	pos := 0
+	err = file.streamBlocks(func(data []byte) error {
+		rest := expected[pos:]
+		for i := range data {
+			if i >= len(rest) || data[i] != rest[i] {
+				return ContentMismatchError{filename, pos + i}
+			}
+		}
+		pos += len(data)
+		return nil
+	})
+	if err == nil && pos < len(expected) {
+		err = ContentMismatchError{filename, pos}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err == nil, err
+}
+
+// Kinds of problem reported by Check
+type ProblemKind int
+
+const (
+	ProblemBadChain  ProblemKind = iota // chain loops or leaves the data region
+	ProblemCrossLink                    // chains of two files share blocks
+	ProblemOrphan                       // allocated blocks reached by no file
+	ProblemStaleSlot                    // size table slot out of step with its root entry
+)
+
+// Problem found by Check, carrying what Repair needs to fix it
+type Problem struct {
+	Kind     ProblemKind // what is wrong
+	Filename string      // file at fault, empty for orphans and empty entries
+	Other    string      // for cross-links, the later file sharing the blocks
+	// bad chain: last valid block, or -1 if the start block is invalid;
+	// cross-link: first block the two chains share;
+	// orphan: first block of the orphaned chain;
+	// stale slot: index of the root entry whose slot it is
+	Block int
+}
+
+// Scans the FAT for chains that loop or run outside the data region, for
+// files whose chains share blocks, and for allocated blocks belonging to
+// no file. The size table is scanned too, for size records and IV slots
+// that don't belong to their root entry, e.g. left behind when an entry
+// moved or was rolled back without them. Problems are listed bad chains
+// first, then cross-links in root directory order, then orphaned chains in
+// block order, then stale slots in root directory order. Nothing is
+// changed; see Repair.
+// Returns: (problems found, any error encountered)
+// Scope: exported
+func (d *Disk) Check() ([]Problem, error) {
+	entries, err := d.entries(true)
+	if err != nil {
+		return nil, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return nil, err
+	}
+	var bad, crossed, orphaned []Problem
+	owners := make(map[int]string)
+	reported := make(map[[2]string]bool)
+	for _, entry := range entries {
+		blocks, ok := d.walkChain(fatBuff, entry.StartBlock)
+		if !ok {
+			last := -1
+			if len(blocks) > 0 {
+				last = blocks[len(blocks)-1]
+			}
+			bad = append(bad, Problem{ProblemBadChain, entry.Name, "", last})
+		}
+		for _, block := range blocks {
+			owner, claimed := owners[block]
+			if !claimed {
+				owners[block] = entry.Name
+				continue
+			}
+			pair := [2]string{owner, entry.Name}
+			if owner != entry.Name && !reported[pair] {
+				reported[pair] = true
+				crossed = append(crossed, Problem{ProblemCrossLink, owner, entry.Name, block})
+			}
+		}
+	}
+	orphan := func(block int) bool {
+		_, owned := owners[block]
+		return !owned && d.byteOrder().Uint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize]) != FatEntryUnused
+	}
+	// an orphaned chain is headed by the one block none of the others
+	// links to, unless it loops and has no head at all
+	linked := make(map[int]bool)
+	for block := 0; block < d.dataBlockCt; block++ {
+		if orphan(block) {
+			linked[int(d.byteOrder().Uint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize]))] = true
+		}
+	}
+	visited := make(map[int]bool)
+	visit := func(head int) {
+		for block := head; block < d.dataBlockCt && orphan(block) && !visited[block]; {
+			visited[block] = true
+			block = int(d.byteOrder().Uint16(fatBuff[block*FatEntrySize : (block+1)*FatEntrySize]))
+		}
+		orphaned = append(orphaned, Problem{ProblemOrphan, "", "", head})
+	}
+	for block := 0; block < d.dataBlockCt; block++ {
+		if orphan(block) && !linked[block] {
+			visit(block)
+		}
+	}
+	for block := 0; block < d.dataBlockCt; block++ {
+		if orphan(block) && !visited[block] {
+			visit(block)
+		}
+	}
+	stale, err := d.checkSlots(fatBuff)
+	if err != nil {
+		return nil, err
+	}
+	problems := append(bad, crossed...)
+	problems = append(problems, orphaned...)
+	problems = append(problems, stale...)
+	d.checked, d.checkPassed = true, len(problems) == 0
+	return problems, nil
+}
+
+// Lists the root entries whose size record or IV slot is stale
+// Returns: (a ProblemStaleSlot for each, any error encountered)
+// Scope: internal
+func (d *Disk) checkSlots(fatBuff []byte) ([]Problem, error) {
+	table, err := d.readSizeTable()
+	if err != nil || table == nil {
+		return nil, err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return nil, err
+	}
+	var stale []Problem
+	for i := 0; i < len(rootBuff)/RootEntrySize; i++ {
+		if record, iv := d.staleSlots(table, fatBuff, rootBuff, i); record || iv {
+			name := d.decodeEntry(rootBuff[i*RootEntrySize : (i+1)*RootEntrySize]).Name
+			stale = append(stale, Problem{ProblemStaleSlot, name, "", i})
+		}
+	}
+	return stale, nil
+}
+
+// Follows a chain until it ends, leaves the data region or comes back to
+// a block it has already reached
+// Returns: (blocks reached, whether the chain ended properly)
+// Scope: internal
+func (d *Disk) walkChain(fatBuff []byte, start int) ([]int, bool) {
+	var blocks []int
+	seen := make(map[int]bool)
+	for block := start; ; {
+		if !d.validBlock(block) || seen[block] {
+			return blocks, false
+		}
+		seen[block] = true
+		blocks = append(blocks, block)
+		next := d.byteOrder().Uint16(fatBuff[block*FatEntrySize : (block+1)*FatEntrySize])
+		if next == FatEoc {
+			return blocks, true
+		}
+		block = int(next)
+	}
+}
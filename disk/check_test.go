@@ -0,0 +1,263 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDisk_CrossLinks(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", make([]byte, 2*BlockSize))
+	d.WriteFile("b.txt", make([]byte, 2*BlockSize))
+	d.WriteFile("c.txt", make([]byte, BlockSize))
+	// Test
+	pairs, err := d.CrossLinks()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pairs) != 0 {
+		t.Errorf("Expected no cross-links on a clean disk, Got %v", pairs)
+	}
+	// point the tail of a.txt into the middle of b.txt's chain
+	fatBuff, _ := d.readFat()
+	rootBuff, _ := d.readRootDir()
+	aBlocks, _ := d.chainBlocks(fatBuff, d.entryStartBlock(rootBuff[d.findRootEntry(rootBuff, "a.txt"):]))
+	bBlocks, _ := d.chainBlocks(fatBuff, d.entryStartBlock(rootBuff[d.findRootEntry(rootBuff, "b.txt"):]))
+	last := aBlocks[len(aBlocks)-1]
+	d.byteOrder().PutUint16(fatBuff[last*FatEntrySize:], uint16(bBlocks[1]))
+	d.WriteBlock(1, fatBuff[:BlockSize])
+	pairs, err = d.CrossLinks()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pairs) != 1 || pairs[0] != [2]string{"a.txt", "b.txt"} {
+		t.Errorf("Expected [[a.txt b.txt]], Got %v", pairs)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_RepairSizes(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("short.txt", make([]byte, 3*BlockSize+100))
+	d.WriteFile("long.txt", make([]byte, 10))
+	d.WriteFile("ok.txt", make([]byte, BlockSize+1))
+	d.WriteFile("norecord.txt", make([]byte, 2*BlockSize))
+	// stale sizes, as if the crash hit before they were stored
+	rootBuff, _ := d.readRootDir()
+	setSize := func(name string, size int) {
+		i := d.findRootEntry(rootBuff, name) + RootEntryFilenameSize
+		d.byteOrder().PutUint32(rootBuff[i:i+RootEntrySizeFieldSize], uint32(size))
+	}
+	setSize("short.txt", 5)
+	setSize("long.txt", 5*BlockSize)
+	setSize("norecord.txt", 5)
+	d.WriteBlock(d.rootDirInd, rootBuff)
+	// without a size record only the chain length is left to go on
+	index := d.findRootEntry(rootBuff, "norecord.txt") / RootEntrySize
+	d.fd.WriteAt(make([]byte, SizeRecordSize), int64(d.sizeTableInd()*BlockSize+index*SizeRecordSize))
+	// Test
+	if err := d.RepairSizes(); err != nil {
+		t.Error(err)
+	}
+	expected := map[string]int{
+		"short.txt":    3*BlockSize + 100,
+		"long.txt":     10,
+		"ok.txt":       BlockSize + 1,
+		"norecord.txt": BlockSize,
+	}
+	entries, _ := d.Entries()
+	for _, entry := range entries {
+		if entry.Size != expected[entry.Name] {
+			t.Errorf("Expected %s size %v, Got %v", entry.Name, expected[entry.Name], entry.Size)
+		}
+	}
+	file, _ := d.Open("ok.txt")
+	if _, ok := d.RepairSizes().(MultiError); !ok {
+		t.Errorf("Expected MultiError reporting the open file")
+	}
+	file.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_DiskUsageByFile(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", make([]byte, 2*BlockSize))
+	d.WriteFile("b.txt", nil)
+	// leak two blocks, as an interrupted allocation would
+	fatBuff, _ := d.readFat()
+	d.byteOrder().PutUint16(fatBuff[40*FatEntrySize:], FatEoc)
+	d.byteOrder().PutUint16(fatBuff[41*FatEntrySize:], FatEoc)
+	d.WriteBlock(1, fatBuff[:BlockSize])
+	// Test
+	usage, err := d.DiskUsageByFile()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := map[string]int{"a.txt": 2, "b.txt": 1, UsageOrphanedKey: 2}
+	if !reflect.DeepEqual(usage, expected) {
+		t.Errorf("Expected %v, Got %v", expected, usage)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_VerifyFile(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("verify "), BlockSize)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	// Test
+	if ok, err := d.VerifyFile(tFilename, tData); !ok || err != nil {
+		t.Errorf("Expected match, Got %v, %v", ok, err)
+	}
+	corrupt := append([]byte(nil), tData...)
+	corrupt[BlockSize+3] ^= 0xFF
+	for _, tc := range []struct {
+		expected []byte
+		offset   int
+	}{
+		{corrupt, BlockSize + 3},
+		{tData[:100], 100},
+		{append(append([]byte(nil), tData...), 'x'), len(tData)},
+	} {
+		ok, err := d.VerifyFile(tFilename, tc.expected)
+		mismatch, isMismatch := err.(ContentMismatchError)
+		if ok || !isMismatch || mismatch.Offset() != tc.offset {
+			t.Errorf("Expected mismatch at offset %v, Got %v, %v", tc.offset, ok, err)
+		}
+	}
+	if d.checkIsOpen(tFilename) {
+		t.Errorf("Expected file closed after verifying")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Check(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", make([]byte, 2*BlockSize))
+	d.WriteFile("b.txt", make([]byte, 3*BlockSize))
+	d.WriteFile("c.txt", make([]byte, 2*BlockSize))
+	// Test
+	problems, err := d.Check()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems on a clean disk, Got %v", problems)
+	}
+	fatBuff, _ := d.readFat()
+	rootBuff, _ := d.readRootDir()
+	chain := func(name string) []int {
+		blocks, _ := d.chainBlocks(fatBuff, d.entryStartBlock(rootBuff[d.findRootEntry(rootBuff, name):]))
+		return blocks
+	}
+	aBlocks, bBlocks, cBlocks := chain("a.txt"), chain("b.txt"), chain("c.txt")
+	link := func(block, next int) {
+		d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:], uint16(next))
+	}
+	link(aBlocks[1], bBlocks[1])
+	link(cBlocks[1], cBlocks[0])
+	link(50, 51)
+	link(51, FatEoc)
+	d.WriteBlock(1, fatBuff[:BlockSize])
+	problems, err = d.Check()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []Problem{
+		{ProblemBadChain, "c.txt", "", cBlocks[1]},
+		{ProblemCrossLink, "a.txt", "b.txt", bBlocks[1]},
+		{ProblemOrphan, "", "", 50},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected %v, Got %v", expected, problems)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_CheckSlots(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tKey := []byte("0123456789abcdef")
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", make([]byte, BlockSize+7))
+	for _, name := range []string{"b.txt", "c.txt"} {
+		f, _ := d.CreateEncrypted(name, tKey)
+		f.Write([]byte("secret"))
+		f.Close()
+	}
+	d.WriteFile("d.txt", []byte("plain"))
+	d.WriteFile("e.txt", []byte("removed"))
+	d.Remove("e.txt")
+	// Test
+	if problems, _ := d.Check(); len(problems) != 0 {
+		t.Errorf("Expected no problems after a Remove, Got %v", problems)
+	}
+	table, _ := d.readSizeTable()
+	rootBuff, _ := d.readRootDir()
+	// a's record taken for another chain, b's IV lost, c's IV given to the
+	// plain d, and a record left in e's emptied slot
+	slot := func(name string) int {
+		return d.findRootEntry(rootBuff, name) / RootEntrySize
+	}
+	ivAt := func(index int) int {
+		return IvRecordOffset + index*IvRecordSize
+	}
+	a, b, c, plain := slot("a.txt"), slot("b.txt"), slot("c.txt"), slot("d.txt")
+	empty := plain + 1
+	copy(table[a*SizeRecordSize:], d.encodeSizeRecord(d.entryStartBlock(rootBuff[a*RootEntrySize:])+1, 7))
+	copy(table[ivAt(b):], make([]byte, IvRecordSize))
+	copy(table[ivAt(plain):ivAt(plain+1)], table[ivAt(c):])
+	copy(table[empty*SizeRecordSize:], d.encodeSizeRecord(0, 7))
+	d.fd.WriteAt(table, int64(d.sizeTableInd()*BlockSize))
+	problems, err := d.Check()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []Problem{
+		{ProblemStaleSlot, "a.txt", "", a},
+		{ProblemStaleSlot, "b.txt", "", b},
+		{ProblemStaleSlot, "d.txt", "", plain},
+		{ProblemStaleSlot, "", "", empty},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected %v, Got %v", expected, problems)
+	}
+	// clearing can't bring back b's IV, so only it is still reported
+	if err = d.Repair(problems, RepairOptions{ClearStaleSlots: true}); err != nil {
+		t.Error(err)
+	}
+	problems, _ = d.Check()
+	if expected = expected[1:2]; !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected %v, Got %v", expected, problems)
+	}
+	if f, err := d.OpenEncrypted("c.txt", tKey); err != nil {
+		t.Errorf("Expected c.txt's own IV kept, Got %v", err)
+	} else {
+		f.Close()
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,128 @@
+package disk
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Kinds of difference reported by Compare
+type DifferenceKind int
+
+const (
+	DiffOnlyInA  DifferenceKind = iota // file exists on the first disk only
+	DiffOnlyInB                        // file exists on the second disk only
+	DiffContents                       // file exists on both with different contents
+)
+
+// Difference found by Compare
+type Difference struct {
+	Kind     DifferenceKind // how the file differs
+	Filename string         // name of the file
+	// contents: first offset at which the files differ, which for
+	// differing lengths is where the shorter one ends; 0 otherwise
+	Offset int
+}
+
+// Compares the files of two disks, listing files found on only one of them
+// and files whose contents differ, in filename order. Contents are
+// compared a block at a time, stopping at the first differing offset;
+// attributes and modification times aren't compared. Names are matched as
+// stored, so a disk folding case only matches lower case names on the
+// other. No file compared may be open on either disk.
+// Returns: (differences found, any error encountered)
+// Scope: exported
+func Compare(a, b *Disk) ([]Difference, error) {
+	entriesA, err := a.Entries()
+	if err != nil {
+		return nil, err
+	}
+	entriesB, err := b.Entries()
+	if err != nil {
+		return nil, err
+	}
+	inB := make(map[string]bool, len(entriesB))
+	for _, entry := range entriesB {
+		inB[entry.Name] = true
+	}
+	var diffs []Difference
+	inA := make(map[string]bool, len(entriesA))
+	for _, entry := range entriesA {
+		inA[entry.Name] = true
+		if !inB[entry.Name] {
+			diffs = append(diffs, Difference{DiffOnlyInA, entry.Name, 0})
+			continue
+		}
+		// a disk always matches itself
+		if a == b {
+			continue
+		}
+		offset, same, err := compareFile(a, b, entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			diffs = append(diffs, Difference{DiffContents, entry.Name, offset})
+		}
+	}
+	for _, entry := range entriesB {
+		if !inA[entry.Name] {
+			diffs = append(diffs, Difference{DiffOnlyInB, entry.Name, 0})
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Filename < diffs[j].Filename
+	})
+	return diffs, nil
+}
+
+// Streams the file with given filename from both disks side by side
+// Returns: (first differing offset, whether the contents match, any error
+// encountered)
+// Scope: internal
+func compareFile(a, b *Disk, filename string) (int, bool, error) {
+	fileA, err := a.Open(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer fileA.Close()
+	fileB, err := b.Open(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer fileB.Close()
+	fatA, err := a.readFat()
+	if err != nil {
+		return 0, false, err
+	}
+	fatB, err := b.readFat()
+	if err != nil {
+		return 0, false, err
+	}
+	shorter := fileA.size
+	if fileB.size < shorter {
+		shorter = fileB.size
+	}
+	buffA, buffB := make([]byte, BlockSize), make([]byte, BlockSize)
+	for pos := 0; pos < shorter; {
+		n, err := fileA.readInBlock(fatA, pos, buffA)
+		if err != nil {
+			return 0, false, err
+		}
+		if _, err = fileB.readInBlock(fatB, pos, buffB); err != nil {
+			return 0, false, err
+		}
+		// the longer file's block runs on past the shorter one's end
+		if pos+n > shorter {
+			n = shorter - pos
+		}
+		if !bytes.Equal(buffA[:n], buffB[:n]) {
+			for i := 0; ; i++ {
+				if buffA[i] != buffB[i] {
+					return pos + i, false, nil
+				}
+			}
+		}
+		pos += n
+	}
+	return shorter, fileA.size == fileB.size, nil
+}
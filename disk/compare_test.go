@@ -0,0 +1,62 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	// Setup
+	tBlockCt := 64
+	tData := bytes.Repeat([]byte("compare "), BlockSize/4)
+	a, _ := New("a.disk", tBlockCt)
+	b, _ := New("b.disk", tBlockCt)
+	changed := append([]byte(nil), tData...)
+	changed[5000] ^= 0xFF
+	for _, d := range []*Disk{&a, &b} {
+		d.WriteFile("same.txt", tData)
+	}
+	a.WriteFile("diff.txt", tData)
+	b.WriteFile("diff.txt", changed)
+	a.WriteFile("short.txt", tData[:100])
+	b.WriteFile("short.txt", tData)
+	a.WriteFile("onlya.txt", nil)
+	b.WriteFile("onlyb.txt", nil)
+	// Test
+	diffs, err := Compare(&a, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Difference{
+		{DiffContents, "diff.txt", 5000},
+		{DiffOnlyInA, "onlya.txt", 0},
+		{DiffOnlyInB, "onlyb.txt", 0},
+		{DiffContents, "short.txt", 100},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("Expected %v, Got %v", expected, diffs)
+	}
+	if diffs, _ = Compare(&a, &a); len(diffs) != 0 {
+		t.Errorf("Expected a disk to match itself, Got %v", diffs)
+	}
+	// an archive round trip reproduces the disk exactly
+	var archive bytes.Buffer
+	a.ExportTar(&archive)
+	c, _ := New("c.disk", tBlockCt)
+	c.ImportTar(&archive)
+	if diffs, _ = Compare(&a, &c); len(diffs) != 0 {
+		t.Errorf("Expected no differences after a tar round trip, Got %v", diffs)
+	}
+	f, _ := b.Open("same.txt")
+	if _, err = Compare(&a, &b); err == nil {
+		t.Errorf("Expected FileAlreadyInUseError with a compared file open, Got nil")
+	}
+	f.Close()
+	// Teardown
+	for name, d := range map[string]*Disk{"a.disk": &a, "b.disk": &b, "c.disk": &c} {
+		d.Close()
+		os.Remove(name)
+	}
+}
@@ -0,0 +1,159 @@
+package disk
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+)
+
+// Creates a new, empty file whose contents are stored flate-compressed.
+// Reads and writes see the uncompressed data, and the recorded size is the
+// uncompressed size. Writes must append at the end of the file; to rewrite
+// it, Truncate first. Changes are held in memory and compressed onto the
+// disk when the file is closed, so an error storing them surfaces from Close.
+// Returns: (File structure reference, any error that occurred)
+// Scope: exported
+func (d *Disk) CreateCompressed(filename string) (File, error) {
+	return d.create(filename, AttrCompressed)
+}
+
+// Decompresses the stored contents into memory, if not already loaded
+// Scope: internal
+func (f *File) loadCompressed() error {
+	if f.plain != nil {
+		f.disk.pool.touch(f)
+		return nil
+	}
+	if f.size == 0 {
+		f.plain = []byte{}
+		return nil
+	}
+	raw, err := f.loadRaw()
+	if err != nil {
+		return err
+	}
+	plain, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return CorruptDataError{f.name}
+	}
+	if len(plain) != f.size {
+		return CorruptDataError{f.name}
+	}
+	f.plain = plain
+	f.disk.pool.touch(f)
+	return nil
+}
+
+// Appends data to the in-memory contents of a compressed file
+// Returns: (number of bytes written, any error encountered)
+// Scope: internal
+func (f *File) appendCompressed(data []byte, offset int) (int, error) {
+	if offset != f.size {
+		return 0, CompressedWriteError{f.name}
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if err := f.checkQuota(f.size + len(data)); err != nil {
+		return 0, err
+	}
+	if err := f.loadCompressed(); err != nil {
+		return 0, err
+	}
+	f.plain = append(f.plain, data...)
+	f.size += len(data)
+	f.markDirty()
+	return len(data), nil
+}
+
+// Resizes the in-memory contents of a compressed file
+// Scope: internal
+func (f *File) truncateCompressed(size int) error {
+	if size > f.size {
+		if err := f.checkQuota(size); err != nil {
+			return err
+		}
+	}
+	if err := f.loadCompressed(); err != nil {
+		return err
+	}
+	if size < len(f.plain) {
+		f.plain = f.plain[:size]
+	} else {
+		f.plain = append(f.plain, make([]byte, size-len(f.plain))...)
+	}
+	f.size = size
+	f.markDirty()
+	return nil
+}
+
+// Flags the in-memory contents as changed, registering the handle so
+// Disk.CloseAll can store them
+// Scope: internal
+func (f *File) markDirty() {
+	f.dirty = true
+	f.disk.closers[f.name] = f.Close
+	f.disk.pool.touch(f)
+}
+
+// Reads from the in-memory contents of a compressed file
+// Returns: (number of bytes read, any error encountered)
+// Scope: internal
+func (f *File) readCompressed(buff []byte, offset int) (int, error) {
+	if err := f.loadCompressed(); err != nil {
+		return 0, err
+	}
+	n := copy(buff, f.plain[offset:f.size])
+	if n < len(buff) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Passes the uncompressed contents to fn in BlockSize pieces
+// Scope: internal
+func (f *File) streamCompressed(fn func(data []byte) error) error {
+	if err := f.loadCompressed(); err != nil {
+		return err
+	}
+	// fn may use other buffers, dropping this one from the handle
+	plain := f.plain
+	for pos := 0; pos < f.size; pos += BlockSize {
+		end := pos + BlockSize
+		if end > f.size {
+			end = f.size
+		}
+		if err := fn(plain[pos:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compresses buffered changes onto the disk and records the new size
+// Scope: internal
+func (f *File) flushCompressed() error {
+	if !f.dirty {
+		return nil
+	}
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(f.plain); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = f.storeRaw(compressed.Bytes()); err != nil {
+		return err
+	}
+	if err = f.storeSize(f.size); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
@@ -0,0 +1,74 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"testing"
+)
+
+func TestDisk_CreateCompressed(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("compressible text "), BlockSize)
+	// Test
+	t.Run("appendOnly", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		f, _ := d.CreateCompressed(tFilename)
+		f.Write(tData[:100])
+		// Test
+		_, err := f.WriteAt([]byte("x"), 0)
+		if _, ok := err.(CompressedWriteError); !ok {
+			t.Error("Expected CompressedWriteError for write before the end")
+		}
+		// rewriting is allowed after truncating
+		if err = f.Truncate(0); err != nil {
+			t.Error(err)
+		}
+		if _, err = f.WriteAt([]byte("fresh"), 0); err != nil {
+			t.Error(err)
+		}
+		f.Close()
+		got, _ := d.ReadFile(tFilename)
+		if string(got) != "fresh" {
+			t.Errorf("Expected contents %q, Got %q", "fresh", got)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, err := d.CreateCompressed(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err = f.Write(tData); err != nil {
+		t.Error(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Error(err)
+	}
+	// stored compressed, but sized and read uncompressed
+	if n, _ := d.BlockCountOf(tFilename); n*BlockSize >= len(tData)/4 {
+		t.Errorf("Expected compressed storage, Got %v blocks for %v bytes", n, len(tData))
+	}
+	d.Close()
+	d, _ = Mount(tDiskFilename)
+	got, err := d.ReadFile(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes back intact, Got %v bytes", len(tData), len(got))
+	}
+	h := sha256.New()
+	d.HashFile(tFilename, h)
+	if exp := sha256.Sum256(tData); !bytes.Equal(h.Sum(nil), exp[:]) {
+		t.Error("Expected hash of uncompressed contents")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
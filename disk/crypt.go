@@ -0,0 +1,198 @@
+package disk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const (
+	IvRecordOffset    = 0x400 // past the size records in the size table block
+	IvRecordIvOffset  = 0x00
+	IvRecordIvSize    = aes.BlockSize
+	IvRecordCrcOffset = 0x10
+	IvRecordCrcSize   = 4
+	IvRecordSize      = 20
+)
+
+// Creates a new, empty file whose contents are stored encrypted, and opens
+// it to read and write with key. Data is encrypted with AES in counter
+// mode, AES-128, -192 or -256 for a 16, 24 or 32 byte key, under a random
+// IV drawn for the file. The counter follows the byte offset within the
+// file, so a WriteAt or ReadAt anywhere costs no more than for any other
+// file, and the recorded size stays the plaintext length. The root entry
+// has no room for the IV, so it is kept in the size table block, in a slot
+// per root entry past the size records. Every entry has its slot, so there
+// is no limit on encrypted files beyond the root directory's own, and the
+// superblock metadata is left for SetMetadata. Rename keeps the entry and
+// with it the IV; Remove clears the slot. Snapshots and transactions hold
+// the size table with the root directory, so a Restore or a discarded
+// Transaction that brings a removed encrypted file back brings its IV back
+// too. Disks older than SizeTableVersion have no table and can't hold
+// encrypted files.
+// Only the contents are protected. Names, sizes, times and the layout of
+// the chain stay readable. Nothing detects tampering or a wrong key; a
+// wrong key decrypts to garbage rather than failing. Rewriting a range
+// reuses its keystream, so anyone holding two versions of the image can
+// XOR them to learn how the plaintext changed.
+// Encrypted files open only with OpenEncrypted. Open, and the helpers
+// built on it such as ReadFile, WriteFile, VerifyFile, Compare and the
+// archive functions, fail on them with an EncryptedFileError. ReadRaw
+// returns the ciphertext. Discard leaves the host storage in place, since
+// a released range would read back as zeros rather than encrypted zeros.
+// Returns: (File structure reference, any error that occurred)
+// Scope: exported
+func (d *Disk) CreateEncrypted(filename string, key []byte) (File, error) {
+	block, err := newFileCipher(key)
+	if err != nil {
+		return File{}, err
+	}
+	if d.sizeTableBlockCt() == 0 {
+		return File{}, CustomError{"Disk version has no room for file IVs"}
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err = rand.Read(iv); err != nil {
+		return File{}, err
+	}
+	file, err := d.create(filename, AttrEncrypted)
+	if err != nil {
+		return File{}, err
+	}
+	// a crash before the IV is stored leaves an empty file that can't be
+	// opened, only removed
+	if err = d.storeIv(file.entry, iv); err != nil {
+		file.Close()
+		d.Remove(file.name)
+		return File{}, err
+	}
+	file.cipher, file.iv = block, iv
+	return file, nil
+}
+
+// Opens the encrypted file with given filename like Open, decrypting reads
+// and encrypting writes with key, see CreateEncrypted. Files stored in the
+// clear don't open this way.
+// Returns: (File structure reference, any error that occurred)
+// Scope: exported
+func (d *Disk) OpenEncrypted(filename string, key []byte) (File, error) {
+	block, err := newFileCipher(key)
+	if err != nil {
+		return File{}, err
+	}
+	return d.openFile(filename, block)
+}
+
+// Builds the AES cipher for a file key
+// Returns: (block cipher, any error encountered)
+// Scope: internal
+func newFileCipher(key []byte) (cipher.Block, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, CustomError{"Key must be 16, 24 or 32 bytes"}
+	}
+	return block, nil
+}
+
+// Attaches the cipher to a file being opened, loading its IV. A nil
+// cipher opens files stored in the clear only.
+// Scope: internal
+func (f *File) useCipher(block cipher.Block) error {
+	encrypted := f.attr&AttrEncrypted != 0
+	if block == nil {
+		if encrypted {
+			return EncryptedFileError{f.name}
+		}
+		return nil
+	}
+	if !encrypted {
+		return CustomError{"File is not encrypted"}
+	}
+	iv, found, err := f.disk.loadIv(f.entry)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return CorruptDataError{f.name}
+	}
+	f.cipher, f.iv = block, iv
+	return nil
+}
+
+// Computes the byte offset of the IV slot for the root entry at index
+// Scope: internal
+func (d *Disk) ivRecordOffset(index int) int64 {
+	return int64(d.sizeTableInd()*BlockSize + IvRecordOffset + index*IvRecordSize)
+}
+
+// Writes the IV for the root entry at index, or clears its slot if iv is
+// nil
+// Scope: internal
+func (d *Disk) storeIv(index int, iv []byte) error {
+	if d.sizeTableBlockCt() == 0 {
+		return nil
+	}
+	record := make([]byte, IvRecordSize)
+	if iv != nil {
+		copy(record[IvRecordIvOffset:IvRecordIvOffset+IvRecordIvSize], iv)
+		d.byteOrder().PutUint32(record[IvRecordCrcOffset:IvRecordCrcOffset+IvRecordCrcSize], crc32.ChecksumIEEE(iv))
+	}
+	_, err := d.fd.WriteAt(record, d.ivRecordOffset(index))
+	return err
+}
+
+// Reads the IV for the root entry at index. The checksum covers the IV
+// alone, so it holds when the file's chain moves.
+// Returns: (IV, whether a valid one was found, any error)
+// Scope: internal
+func (d *Disk) loadIv(index int) ([]byte, bool, error) {
+	if d.sizeTableBlockCt() == 0 {
+		return nil, false, nil
+	}
+	record := make([]byte, IvRecordSize)
+	if _, err := d.fd.ReadAt(record, d.ivRecordOffset(index)); err != nil {
+		return nil, false, err
+	}
+	iv, found := d.decodeIv(record)
+	return iv, found, nil
+}
+
+// Checks an IV slot's checksum
+// Returns: (IV, whether the slot holds a valid one)
+// Scope: internal
+func (d *Disk) decodeIv(record []byte) ([]byte, bool) {
+	iv := record[IvRecordIvOffset : IvRecordIvOffset+IvRecordIvSize]
+	stored := d.byteOrder().Uint32(record[IvRecordCrcOffset : IvRecordCrcOffset+IvRecordCrcSize])
+	// a cleared slot is all zeros, which fails the checksum
+	if stored != crc32.ChecksumIEEE(iv) {
+		return nil, false
+	}
+	return iv, true
+}
+
+// XORs data, found at byte offset pos of the file, with the file's
+// keystream, encrypting or decrypting it in place. Files stored in the
+// clear are left as they are.
+// Scope: internal
+func (f *File) crypt(data []byte, pos int) {
+	if f.cipher == nil || len(data) == 0 {
+		return
+	}
+	// the counter for pos is the IV plus the AES blocks ahead of it, the
+	// carry running across all 128 bits as cipher.NewCTR increments it
+	hi, lo := binary.BigEndian.Uint64(f.iv[:8]), binary.BigEndian.Uint64(f.iv[8:])
+	next := lo + uint64(pos/aes.BlockSize)
+	if next < lo {
+		hi++
+	}
+	counter := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(counter[:8], hi)
+	binary.BigEndian.PutUint64(counter[8:], next)
+	stream := cipher.NewCTR(f.cipher, counter)
+	if skip := pos % aes.BlockSize; skip > 0 {
+		discard := make([]byte, skip)
+		stream.XORKeyStream(discard, discard)
+	}
+	stream.XORKeyStream(data, data)
+}
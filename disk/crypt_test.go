@@ -0,0 +1,208 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDisk_CreateEncrypted(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tKey := "secret.txt", []byte("0123456789abcdef")
+	d, _ := New(tDiskFilename, tBlockCt)
+	// plaintext spanning blocks, written at unaligned offsets out of order
+	tData := bytes.Repeat([]byte("attack at dawn! "), BlockSize/8)
+	f, err := d.CreateEncrypted(tFilename, tKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, split := range [][2]int{{BlockSize + 7, len(tData)}, {0, 5}, {5, BlockSize + 7}} {
+		if _, err = f.WriteAt(tData[split[0]:split[1]], split[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	// Test
+	t.Run("keystream", func(t *testing.T) {
+		f, err := d.OpenEncrypted(tFilename, tKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if f.size != len(tData) {
+			t.Errorf("Expected size %v, Got %v", len(tData), f.size)
+		}
+		// the stored bytes are one CTR pass over the whole plaintext
+		raw := make([]byte, len(tData))
+		f.ReadRaw(raw, 0)
+		block, _ := aes.NewCipher(tKey)
+		expected := make([]byte, len(tData))
+		cipher.NewCTR(block, f.iv).XORKeyStream(expected, tData)
+		if !bytes.Equal(raw, expected) {
+			t.Errorf("Expected stored bytes to be the CTR encryption of the data")
+		}
+		got := make([]byte, len(tData))
+		if _, err = f.ReadAt(got, 0); err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(got, tData) {
+			t.Errorf("Expected %q, Got %q", tData[:32], got[:32])
+		}
+		// reads starting inside an AES block
+		part := make([]byte, 20)
+		f.ReadAt(part, BlockSize-3)
+		if !bytes.Equal(part, tData[BlockSize-3:BlockSize+17]) {
+			t.Errorf("Expected %q, Got %q", tData[BlockSize-3:BlockSize+17], part)
+		}
+		var out bytes.Buffer
+		if _, err = f.WriteTo(&out); err != nil || !bytes.Equal(out.Bytes(), tData) {
+			t.Errorf("Expected WriteTo to decrypt, Got %v", err)
+		}
+	})
+	t.Run("open", func(t *testing.T) {
+		if _, err := d.Open(tFilename); err == nil {
+			t.Errorf("Expected an error opening without a key, Got nil")
+		} else if _, ok := err.(EncryptedFileError); !ok {
+			t.Errorf("Expected EncryptedFileError, Got %v", err)
+		}
+		if _, err := d.ReadFile(tFilename); err == nil {
+			t.Errorf("Expected an error reading without a key, Got nil")
+		}
+		d.WriteFile("plain.txt", tData)
+		if _, err := d.OpenEncrypted("plain.txt", tKey); err == nil {
+			t.Errorf("Expected an error opening a plain file encrypted, Got nil")
+		}
+		if _, err := d.OpenEncrypted(tFilename, []byte("short")); err == nil {
+			t.Errorf("Expected an error for a bad key size, Got nil")
+		}
+		// a wrong key reads garbage rather than failing
+		f, err := d.OpenEncrypted(tFilename, []byte("fedcba9876543210"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 16)
+		f.ReadAt(got, 0)
+		if bytes.Equal(got, tData[:16]) {
+			t.Errorf("Expected a wrong key not to decrypt")
+		}
+		f.Close()
+	})
+	t.Run("restore", func(t *testing.T) {
+		readBack := func() {
+			f, err := d.OpenEncrypted(tFilename, tKey)
+			if err != nil {
+				t.Fatalf("Expected the IV back with the file, Got %v", err)
+			}
+			got := make([]byte, len(tData))
+			f.ReadAt(got, 0)
+			f.Close()
+			if !bytes.Equal(got, tData) {
+				t.Errorf("Expected contents read back")
+			}
+		}
+		id, _ := d.Snapshot()
+		d.Remove(tFilename)
+		if err := d.Restore(id); err != nil {
+			t.Fatal(err)
+		}
+		d.DropSnapshot(id)
+		readBack()
+		tErr := CustomError{"stop"}
+		if err := d.Transaction(func(tx *Tx) error {
+			if err := tx.Remove(tFilename); err != nil {
+				return err
+			}
+			return tErr
+		}); err != tErr {
+			t.Errorf("Expected fn's error, Got %v", err)
+		}
+		readBack()
+	})
+	t.Run("rename and remove", func(t *testing.T) {
+		if err := d.Rename(tFilename, "moved.txt"); err != nil {
+			t.Fatal(err)
+		}
+		f, err := d.OpenEncrypted("moved.txt", tKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(tData))
+		f.ReadAt(got, 0)
+		if !bytes.Equal(got, tData) {
+			t.Errorf("Expected contents kept across Rename")
+		}
+		// growing zero fills with encrypted zeros
+		f.Truncate(len(tData) + 10)
+		tail := make([]byte, 10)
+		f.ReadAt(tail, len(tData))
+		if !bytes.Equal(tail, make([]byte, 10)) {
+			t.Errorf("Expected zeros, Got %v", tail)
+		}
+		entry := f.entry
+		f.Close()
+		d.Remove("moved.txt")
+		if _, found, _ := d.loadIv(entry); found {
+			t.Errorf("Expected the IV dropped with the file")
+		}
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+	t.Run("every entry", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, 200)
+		// Test
+		for i := 0; i < BlockSize/RootEntrySize; i++ {
+			f, err := d.CreateEncrypted(fmt.Sprintf("s%v.txt", i), tKey)
+			if err != nil {
+				t.Fatalf("Expected file %v created, Got %v", i, err)
+			}
+			f.Write([]byte("attack"))
+			f.Close()
+		}
+		if pairs, _ := d.readMetadata(); len(pairs) != 0 {
+			t.Errorf("Expected no metadata used by IVs, Got %v", pairs)
+		}
+		if err := d.SetMetadata("owner", "alice"); err != nil {
+			t.Errorf("Expected metadata left free, Got %v", err)
+		}
+		// rolling the size records back keeps the IVs beside them
+		id, err := d.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Restore(id); err != nil {
+			t.Fatal(err)
+		}
+		f, err := d.OpenEncrypted("s127.txt", tKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 6)
+		f.ReadAt(got, 0)
+		if string(got) != "attack" {
+			t.Errorf("Expected %q after Restore, Got %q", "attack", got)
+		}
+		f.Close()
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("oldVersion", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		d.version = SizeTableVersion - 1
+		// Test
+		if _, err := d.CreateEncrypted(tFilename, tKey); err == nil {
+			t.Errorf("Expected an error without a size table, Got nil")
+		}
+		// Teardown
+		d.version = FsVersion
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+}
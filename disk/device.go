@@ -0,0 +1,260 @@
+package disk
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Storage backing a disk image. An *os.File is a BlockDevice; other
+// implementations can substitute different storage or wrap a device to
+// change how its I/O is performed.
+type BlockDevice interface {
+	io.ReaderAt
+	io.WriterAt
+	Sync() error
+	Close() error
+	Stat() (os.FileInfo, error)
+}
+
+// Loads a disk from an already opened device and returns the associated
+// structure. The disk takes ownership of the device, closing it on failure
+// or when the disk is closed.
+// Scope: exported
+func MountDevice(dev BlockDevice) (Disk, error) {
+	return MountWithOptions("", MountOptions{Device: dev})
+}
+
+// Read-only device over an arbitrary io.ReaderAt of known size
+type readerDevice struct {
+	r    *io.SectionReader // reads bounded to the image size
+	src  io.ReaderAt       // reader as given, closed with the device
+	size int64             // size of the image in bytes
+}
+
+// Loads a read-only disk from size bytes of an io.ReaderAt, such as an
+// image embedded in another file or one fetched over HTTP with range
+// requests, without needing it as a file on disk. The disk behaves as one
+// from MountReadOnly: every modification fails with a
+// ReadOnlyFilesystemError and any update left in the journal isn't
+// replayed. If r is also an io.Closer it is closed when mounting fails or
+// the disk is closed.
+// Returns: (Disk structure, any error that occurred)
+// Scope: exported
+func MountReader(r io.ReaderAt, size int64) (Disk, error) {
+	if size < 0 {
+		return Disk{}, CustomError{"Image size must not be negative"}
+	}
+	dev := &readerDevice{io.NewSectionReader(r, 0, size), r, size}
+	return MountWithOptions("", MountOptions{ReadOnly: true, Device: dev})
+}
+
+func (r *readerDevice) ReadAt(buff []byte, offset int64) (int, error) {
+	return r.r.ReadAt(buff, offset)
+}
+
+func (r *readerDevice) WriteAt([]byte, int64) (int, error) {
+	return 0, ReadOnlyFilesystemError{}
+}
+
+func (r *readerDevice) Sync() error {
+	return nil
+}
+
+func (r *readerDevice) Close() error {
+	if closer, ok := r.src.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (r *readerDevice) Stat() (os.FileInfo, error) {
+	return readerInfo{r.size}, nil
+}
+
+// File information for a readerDevice, which has only a size
+type readerInfo struct {
+	size int64 // size of the image in bytes
+}
+
+func (ri readerInfo) Name() string       { return "" }
+func (ri readerInfo) Size() int64        { return ri.size }
+func (ri readerInfo) Mode() os.FileMode  { return 0444 }
+func (ri readerInfo) ModTime() time.Time { return time.Time{} }
+func (ri readerInfo) IsDir() bool        { return false }
+func (ri readerInfo) Sys() interface{}   { return nil }
+
+// Device bounding the time taken by each operation on another device
+type timeoutDevice struct {
+	dev     BlockDevice   // wrapped device
+	timeout time.Duration // limit on each operation
+}
+
+// Wraps a device so that any read, write or sync taking longer than the
+// timeout fails with a TimeoutError instead of blocking the caller. The
+// stalled operation is abandoned rather than cancelled: a write may still
+// reach the device later, so the disk should be considered suspect after
+// a timeout. Closing and Stat are passed through unbounded.
+// Scope: exported
+func NewTimeoutDevice(dev BlockDevice, timeout time.Duration) BlockDevice {
+	return &timeoutDevice{dev: dev, timeout: timeout}
+}
+
+func (t *timeoutDevice) ReadAt(buff []byte, offset int64) (int, error) {
+	// a read finishing after the deadline must not land in the caller's buffer
+	private := make([]byte, len(buff))
+	var n int
+	err := t.run("read", func() error {
+		var err error
+		n, err = t.dev.ReadAt(private, offset)
+		return err
+	})
+	if _, timedOut := err.(TimeoutError); timedOut {
+		return 0, err
+	}
+	copy(buff, private[:n])
+	return n, err
+}
+
+func (t *timeoutDevice) WriteAt(data []byte, offset int64) (int, error) {
+	// the caller may reuse data once this returns, even on a timeout
+	private := append([]byte(nil), data...)
+	var n int
+	err := t.run("write", func() error {
+		var err error
+		n, err = t.dev.WriteAt(private, offset)
+		return err
+	})
+	if _, timedOut := err.(TimeoutError); timedOut {
+		return 0, err
+	}
+	return n, err
+}
+
+func (t *timeoutDevice) Sync() error {
+	return t.run("sync", t.dev.Sync)
+}
+
+func (t *timeoutDevice) Close() error {
+	return t.dev.Close()
+}
+
+func (t *timeoutDevice) Stat() (os.FileInfo, error) {
+	return t.dev.Stat()
+}
+
+// Runs op, giving up once the timeout elapses
+// Scope: internal
+func (t *timeoutDevice) run(name string, op func() error) error {
+	// buffered so an abandoned operation can still finish and exit
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return TimeoutError{name, t.timeout}
+	}
+}
+
+// Controls how a device from NewRetryDevice retries failed operations
+type RetryOptions struct {
+	Attempts   int              // tries per operation, including the first; below 1 means 1
+	Backoff    time.Duration    // wait before the first retry, doubling for each one after
+	MaxBackoff time.Duration    // upper bound on the wait, 0 for none
+	Retryable  func(error) bool // reports whether a failure is worth retrying, see NewRetryDevice
+}
+
+// Device retrying failed reads, writes and syncs of a wrapped device
+type retryDevice struct {
+	dev  BlockDevice  // wrapped device
+	opts RetryOptions // retry policy
+}
+
+// Wraps a device so that reads, writes and syncs failing with a retryable
+// error are tried again, up to opts.Attempts times in all, with the wait
+// between tries growing from opts.Backoff. The failure of the last try is
+// returned as is. Without opts.Retryable, an error counts as retryable if
+// it has a Timeout or Temporary method reporting true, as a TimeoutError
+// does, so wrapping a device from NewTimeoutDevice retries operations
+// that time out; every other error, io.EOF included, is returned at once.
+// Writes go to fixed offsets, so a retried write only repeats itself,
+// though a write abandoned by a timeout may still land after its retry.
+// Closing and Stat are passed through untried.
+// Scope: exported
+func NewRetryDevice(dev BlockDevice, opts RetryOptions) BlockDevice {
+	if opts.Attempts < 1 {
+		opts.Attempts = 1
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = transientError
+	}
+	return &retryDevice{dev, opts}
+}
+
+func (r *retryDevice) ReadAt(buff []byte, offset int64) (int, error) {
+	var n int
+	err := r.run(func() error {
+		var err error
+		n, err = r.dev.ReadAt(buff, offset)
+		return err
+	})
+	return n, err
+}
+
+func (r *retryDevice) WriteAt(data []byte, offset int64) (int, error) {
+	var n int
+	err := r.run(func() error {
+		var err error
+		n, err = r.dev.WriteAt(data, offset)
+		return err
+	})
+	return n, err
+}
+
+func (r *retryDevice) Sync() error {
+	return r.run(r.dev.Sync)
+}
+
+func (r *retryDevice) Close() error {
+	return r.dev.Close()
+}
+
+func (r *retryDevice) Stat() (os.FileInfo, error) {
+	return r.dev.Stat()
+}
+
+// Runs op until it succeeds, fails with an error that isn't retryable, or
+// runs out of attempts
+// Scope: internal
+func (r *retryDevice) run(op func() error) error {
+	wait := r.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= r.opts.Attempts || !r.opts.Retryable(err) {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if r.opts.MaxBackoff > 0 && wait > r.opts.MaxBackoff {
+			wait = r.opts.MaxBackoff
+		}
+	}
+}
+
+// Reports whether the error says of itself that it's a timeout or
+// temporary, the default test for what NewRetryDevice retries
+// Scope: internal
+func transientError(err error) bool {
+	if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
+		return true
+	}
+	if e, ok := err.(interface{ Temporary() bool }); ok && e.Temporary() {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package disk
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Device delaying every read, to simulate a stalled backing store
+type slowDevice struct {
+	BlockDevice
+	delay time.Duration
+}
+
+func (s slowDevice) ReadAt(buff []byte, offset int64) (int, error) {
+	time.Sleep(s.delay)
+	return s.BlockDevice.ReadAt(buff, offset)
+}
+
+func TestDisk_MountDevice(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tData := "test.txt", []byte("through the mapping")
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	d.Close()
+	// Test
+	t.Run("OpenMapped", func(t *testing.T) {
+		dev, err := OpenMapped(tDiskFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := MountDevice(dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.ReadFile(tFilename)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(got, tData) {
+			t.Errorf("Expected %q, Got %q", tData, got)
+		}
+		if err = d.WriteFile("mapped.txt", tData); err != nil {
+			t.Error(err)
+		}
+		if err = d.Close(); err != nil {
+			t.Error(err)
+		}
+		// writes through the mapping reach the file
+		d, _ = Mount(tDiskFilename)
+		if got, _ = d.ReadFile("mapped.txt"); !bytes.Equal(got, tData) {
+			t.Errorf("Expected %q after remount, Got %q", tData, got)
+		}
+		d.Close()
+	})
+	fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+	d, err := MountDevice(fd)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile(tFilename); !bytes.Equal(got, tData) {
+		t.Errorf("Expected %q, Got %q", tData, got)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+// Device failing the next fails reads and writes with err, to simulate a
+// flaky backing store
+type flakyDevice struct {
+	BlockDevice
+	fails *int  // failures still to come
+	calls *int  // reads and writes attempted
+	err   error // error failed operations return
+}
+
+func (f flakyDevice) ReadAt(buff []byte, offset int64) (int, error) {
+	*f.calls++
+	if *f.fails > 0 {
+		*f.fails--
+		return 0, f.err
+	}
+	return f.BlockDevice.ReadAt(buff, offset)
+}
+
+func (f flakyDevice) WriteAt(data []byte, offset int64) (int, error) {
+	*f.calls++
+	if *f.fails > 0 {
+		*f.fails--
+		return 0, f.err
+	}
+	return f.BlockDevice.WriteAt(data, offset)
+}
+
+func TestDisk_NewRetryDevice(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tData := "test.txt", []byte("retried")
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	d.Close()
+	fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+	fails, calls := 0, 0
+	transient := TimeoutError{"read", time.Millisecond}
+	opts := RetryOptions{Attempts: 3, Backoff: time.Millisecond}
+	dev := NewRetryDevice(flakyDevice{fd, &fails, &calls, transient}, opts)
+	buff := make([]byte, BlockSize)
+	// Test
+	fails = 2
+	if _, err := dev.ReadAt(buff, 0); err != nil {
+		t.Errorf("Expected the read to succeed on the third attempt, Got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, Got %v", calls)
+	}
+	fails, calls = 3, 0
+	if _, err := dev.WriteAt(buff, 0); err != transient {
+		t.Errorf("Expected the last failure once attempts run out, Got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, Got %v", calls)
+	}
+	// errors that don't say they're transient are returned at once
+	fatal := CustomError{"bad sector"}
+	fails, calls = 1, 0
+	dev = NewRetryDevice(flakyDevice{fd, &fails, &calls, fatal}, opts)
+	if _, err := dev.ReadAt(buff, 0); err != fatal || calls != 1 {
+		t.Errorf("Expected the fatal error after 1 attempt, Got %v after %v", err, calls)
+	}
+	fails, calls = 1, 0
+	custom := opts
+	custom.Retryable = func(err error) bool { return err == fatal }
+	dev = NewRetryDevice(flakyDevice{fd, &fails, &calls, fatal}, custom)
+	if _, err := dev.ReadAt(buff, 0); err != nil || calls != 2 {
+		t.Errorf("Expected a custom test to retry, Got %v after %v attempts", err, calls)
+	}
+	// retries compose with timeouts, and the disk mounts through both
+	fails, calls = 2, 0
+	dev = NewRetryDevice(NewTimeoutDevice(flakyDevice{fd, &fails, &calls, transient}, time.Second), opts)
+	d, err := MountDevice(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.ReadFile(tFilename); !bytes.Equal(got, tData) {
+		t.Errorf("Expected %q, Got %q", tData, got)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_NewTimeoutDevice(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tData := "test.txt", []byte("bounded")
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	d.Close()
+	// Test
+	fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+	d, err := MountDevice(NewTimeoutDevice(fd, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.ReadFile(tFilename); !bytes.Equal(got, tData) {
+		t.Errorf("Expected %q, Got %q", tData, got)
+	}
+	d.Close()
+	fd, _ = os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+	dev := NewTimeoutDevice(slowDevice{fd, 200 * time.Millisecond}, 10*time.Millisecond)
+	buff := make([]byte, BlockSize)
+	if _, err = dev.ReadAt(buff, 0); err == nil {
+		t.Fatalf("Expected TimeoutError, Got nil")
+	}
+	timeoutErr, ok := err.(TimeoutError)
+	if !ok || !timeoutErr.Timeout() {
+		t.Errorf("Expected TimeoutError, Got %v", err)
+	}
+	if _, err = MountDevice(dev); err == nil {
+		t.Errorf("Expected mount on stalled device to fail, Got nil")
+	}
+	// let the abandoned reads finish before removing the file
+	time.Sleep(400 * time.Millisecond)
+	if !bytes.Equal(buff, make([]byte, BlockSize)) {
+		t.Errorf("Expected late read to leave the buffer untouched")
+	}
+	// Teardown
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_MountReader(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tData := "test.txt", []byte("from a reader")
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	d.Close()
+	image, _ := ioutil.ReadFile(tDiskFilename)
+	// image embedded after a header in a larger buffer
+	tPrefix := []byte("header")
+	embedded := append(append([]byte(nil), tPrefix...), image...)
+	// Test
+	r := io.NewSectionReader(bytes.NewReader(embedded), int64(len(tPrefix)), int64(len(image)))
+	d, err := MountReader(r, int64(len(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.ReadFile(tFilename); err != nil || !bytes.Equal(got, tData) {
+		t.Errorf("Expected %q, Got %q (%v)", tData, got, err)
+	}
+	if _, ok := d.WriteFile("new.txt", tData).(ReadOnlyFilesystemError); !ok {
+		t.Errorf("Expected ReadOnlyFilesystemError writing")
+	}
+	if problems, err := d.Check(); err != nil || len(problems) != 0 {
+		t.Errorf("Expected no problems, Got %v (%v)", problems, err)
+	}
+	if err = d.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err = MountReader(bytes.NewReader(image), BlockSize-1); err == nil {
+		t.Errorf("Expected an error mounting a truncated image, Got nil")
+	}
+	if _, err = MountReader(bytes.NewReader(image), -1); err == nil {
+		t.Errorf("Expected an error for a negative size, Got nil")
+	}
+	// Teardown
+	os.Remove(tDiskFilename)
+}
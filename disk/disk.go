@@ -1,10 +1,19 @@
 package disk
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"math"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 const (
@@ -21,8 +30,29 @@ const (
 	SbDataBlockCtSize       = 2
 	SbFatBlockCtOffset      = 0x10
 	SbFatBlockCtSize        = 1
-	SbPaddSize              = 4079
-	SbPaddOffset            = 0x11
+	SbJournalIndOffset      = 0x11
+	SbJournalIndSize        = 2
+	SbJournalBlockCtOffset  = 0x13
+	SbJournalBlockCtSize    = 2
+	SbByteOrderOffset       = 0x15
+	SbByteOrderSize         = 1
+	SbVersionOffset         = 0x16
+	SbVersionSize           = 1
+	SbNamePolicyOffset      = 0x17
+	SbNamePolicySize        = 1
+	SbPaddSize              = 4068
+	SbPaddOffset            = 0x18
+	SbCrcOffset             = 0xFFC
+	SbCrcSize               = 4
+	ByteOrderLittleEndian   = 0
+	ByteOrderBigEndian      = 1
+	FsVersion               = 4
+	NamePolicyCaseSensitive = 0
+	NamePolicyFoldCase      = 1
+	AttrCompressed          = 0x01
+	AttrPending             = 0x02
+	AttrMode                = 0x04
+	AttrEncrypted           = 0x08
 	FatEoc                  = 0xFFFF
 	FatEntrySize            = 2
 	FatEntryUnused          = 0
@@ -30,28 +60,144 @@ const (
 	RootEntryFilenameSize   = 16
 	RootEntrySizeFieldSize  = 4
 	RootEntryStartBlockSize = 2
+	RootEntryQuotaOffset    = 0x16
+	RootEntryQuotaSize      = 4
+	RootEntryAttrOffset     = 0x1A
+	RootEntryAttrSize       = 1
+	RootEntryModTimeOffset  = 0x1B
+	RootEntryModTimeSize    = 4
+	RootEntryModeOffset     = 0x1F
+	RootEntryModeSize       = 1
 )
 
 type Disk struct {
-	fd           *os.File // file descriptor for disk file
-	sig          string   // filesystem signature
-	blockCt      int      // total disk blocks
-	rootDirInd   int      // block index of the root directory
-	dataStartInd int      // disk block index of first data block
-	dataBlockCt  int      // number of data blocks on disk
-	fatBlockCt   int      // number of blocks used to store FAT
-	open		 map[string]bool // map of all open files
+	fd             BlockDevice             // storage holding the disk image
+	sig            string                  // filesystem signature
+	blockCt        int                     // total disk blocks
+	rootDirInd     int                     // block index of the root directory
+	dataStartInd   int                     // disk block index of first data block
+	dataBlockCt    int                     // number of data blocks on disk
+	fatBlockCt     int                     // number of blocks used to store FAT
+	journalInd     int                     // block index of the journal header, 0 if unjournaled
+	journalBlockCt int                     // number of blocks reserved for the journal
+	bigEndian      bool                    // multi-byte on-disk fields are big-endian
+	version        int                     // on-disk format version, 0 for unversioned images
+	foldCase       bool                    // filenames are stored and compared in lower case
+	open           map[string]bool         // map of all open files
+	closers        map[string]func() error // close funcs of open handles holding buffered data
+	locks          *lockTable              // advisory locks held on filenames
+	pool           *bufferPool             // buffers held by open handles, see SetBufferLimit
+	appendMu       *sync.Mutex             // serializes appends, see OpenAppend
+	closed         bool                    // set once the disk file has been closed
+	readOnly       bool                    // mounted without write access
+	noOpenCheck    bool                    // Open allows several handles on one file
+	noSync         bool                    // skip syncs until the disk is synced or closed
+	sparse         bool                    // New leaves unwritten blocks as holes
+	tx             *txDevice               // holds metadata writes while a transaction runs
+	fromBackup     bool                    // mounted from the backup superblock, the primary being damaged
+	snapshots      []snapshot              // kept snapshots, oldest first
+	snapshotCt     int                     // number of snapshots ever taken
+	allocCursor    int                     // data block new chains are looked for from
+	freeCt         int                     // cached count of free data blocks
+	freeValid      bool                    // whether freeCt reflects the FAT
+	freeMap        []uint64                // bit per data block, set while it can be allocated
+	mapValid       bool                    // whether freeMap reflects the FAT
+	frag           float64                 // score of the latest Fragmentation call
+	fragValid      bool                    // whether frag reflects the FAT and root directory
+	checked        bool                    // Check has run since mounting
+	checkPassed    bool                    // the latest Check found no problems
+}
+
+// Configures optional behavior of a disk created with New
+type DiskOption func(*Disk)
+
+// Reserves a write-ahead journal at the end of the disk. FAT and root
+// directory updates are logged there before being applied, so that Mount
+// can complete or discard an update interrupted by a crash.
+// Scope: exported
+func WithJournal() DiskOption {
+	return func(d *Disk) {
+		// header block plus room for an image of every metadata block
+		numFatBlks := int(math.Ceil((FatEntrySize * float64(d.dataBlockCt)) / BlockSize))
+		d.journalBlockCt = 2 + numFatBlks
+	}
+}
+
+// Creates the image sparse: only the superblock, FAT and root directory
+// are written, and the file is then extended to its full size, so the
+// host zero fills data and journal blocks as they are first written
+// instead of New writing zeros over the whole disk. Large disks are
+// created much faster and take host space only as they fill. Reading a
+// block never written returns zeros as usual. Devices that can't be
+// extended are written in full. The setting isn't recorded on disk.
+// Scope: exported
+func WithSparseImage() DiskOption {
+	return func(d *Disk) {
+		d.sparse = true
+	}
+}
+
+// Selects the byte order of multi-byte on-disk fields, recorded in the
+// superblock so Mount decodes the image the same way. Only
+// binary.LittleEndian (the default) and binary.BigEndian can be recorded;
+// any other order is treated as little-endian.
+// Scope: exported
+func WithByteOrder(order binary.ByteOrder) DiskOption {
+	return func(d *Disk) {
+		d.bigEndian = order == binary.BigEndian
+	}
+}
+
+// Makes filenames case-insensitive: names are folded to lower case when
+// stored and when looked up. The policy is recorded in the superblock, so
+// it holds for every later mount of the disk.
+// Scope: exported
+func WithCaseInsensitiveNames() DiskOption {
+	return func(d *Disk) {
+		d.foldCase = true
+	}
+}
+
+// Disables the check that makes Open fail with FileAlreadyInUseError for
+// a file that is already open, so callers coordinating access themselves
+// can hold several handles on one file. Unsafe for concurrent writers:
+// handles don't see each other's size changes, and closing any one of
+// them marks the file closed, letting it be removed under the others.
+// The setting isn't recorded on disk.
+// Scope: exported
+func WithoutOpenCheck() DiskOption {
+	return func(d *Disk) {
+		d.noOpenCheck = true
+	}
+}
+
+// Skips the device syncs that order each journaled metadata update,
+// leaving writes to be flushed by Sync or Close. This speeds up bulk loads,
+// but gives up crash safety until then: the journal can no longer keep an
+// update whole across a power loss or system crash, and the image may be
+// left inconsistent. Only use it when the disk can be rebuilt if that
+// happens. The setting isn't recorded on disk.
+// Scope: exported
+func WithoutSync() DiskOption {
+	return func(d *Disk) {
+		d.noSync = true
+	}
 }
 
 // Makes a new disk and initializes its filesystem
 // Scope: exported
-func New(filename string, dataBlocks int) (Disk, error) {
+func New(filename string, dataBlocks int, opts ...DiskOption) (Disk, error) {
 	d, err := createDisk(filename, dataBlocks)
 	if err != nil {
 		return d, err
 	}
+	for _, opt := range opts {
+		opt(&d)
+	}
 
 	if err = d.initFS(); err != nil {
+		d.fd.Close()
+		os.Remove(filename)
 		return Disk{}, err
 	}
 
@@ -61,51 +207,297 @@ func New(filename string, dataBlocks int) (Disk, error) {
 // Loads a disk file and returns the associated structure
 // Scope: exported
 func Mount(filename string) (Disk, error) {
-	if len(filename) == 0 {
-		return Disk{}, InvalidFilenameError{filename}
+	return MountWithOptions(filename, MountOptions{})
+}
+
+// Loads a disk file like Mount, but first verifies the superblock checksum,
+// signature and layout against the disk file, rejecting inconsistent images
+// Scope: exported
+func MountValidated(filename string) (Disk, error) {
+	return MountWithOptions(filename, MountOptions{Validate: true})
+}
+
+// Loads a disk file for reading only. Every operation that would modify
+// the disk fails with a ReadOnlyFilesystemError before touching the file.
+// An update left in the journal by a crash can't be replayed, so the disk
+// reads as it was before that update.
+// Scope: exported
+func MountReadOnly(filename string) (Disk, error) {
+	return MountWithOptions(filename, MountOptions{ReadOnly: true})
+}
+
+// Loads a disk file like Mount, holding an OS-level advisory lock on it
+// until the disk is closed, so that other processes mounting it the same
+// way can't modify it underneath this one. Read-write mounts take the lock
+// exclusively; read-only mounts share it with other read-only mounts. If
+// the lock is already held the mount fails with a DiskBusyError. Processes
+// that use plain Mount ignore the lock, and on platforms without advisory
+// locks no lock is taken.
+// Scope: exported
+func MountLocked(filename string, readOnly bool) (Disk, error) {
+	return MountWithOptions(filename, MountOptions{ReadOnly: readOnly, Lock: true})
+}
+
+// Settings for MountWithOptions. The zero value mounts as Mount does:
+// read-write, unvalidated, unlocked, with the disk file opened by name and
+// the usual open checks and syncs. None of the settings are recorded on
+// disk.
+type MountOptions struct {
+	// Refuse every modification with a ReadOnlyFilesystemError and leave
+	// any update in the journal unreplayed, as MountReadOnly does
+	ReadOnly bool
+	// Check the superblock checksum, signature and layout against the
+	// device before mounting, as MountValidated does
+	Validate bool
+	// Hold an advisory lock on the disk file until the disk is closed, as
+	// MountLocked does: shared for read-only mounts, exclusive otherwise
+	Lock bool
+	// Mount this device instead of opening the disk file, as MountDevice
+	// does; the filename is then unused. Locking needs the device to be an
+	// *os.File.
+	Device BlockDevice
+	// Let Open hand out several handles on one file, as WithoutOpenCheck
+	NoOpenCheck bool
+	// Skip syncs until the disk is synced or closed, as WithoutSync
+	NoSync bool
+}
+
+// Loads a disk file, or the device given in opts, with every mount
+// behavior chosen in one place. The disk takes ownership of the device,
+// closing it on failure or when the disk is closed.
+// Returns: (Disk structure, any error that occurred)
+// Scope: exported
+func MountWithOptions(filename string, opts MountOptions) (Disk, error) {
+	dev := opts.Device
+	if dev == nil {
+		if len(filename) == 0 {
+			return Disk{}, InvalidFilenameError{filename, "empty"}
+		}
+		flag := os.O_RDWR
+		if opts.ReadOnly {
+			flag = os.O_RDONLY
+		}
+		fd, err := os.OpenFile(filename, flag, 0)
+		if err != nil {
+			return Disk{}, err
+		}
+		dev = fd
+	}
+	if opts.Lock {
+		fd, ok := dev.(*os.File)
+		if !ok {
+			dev.Close()
+			return Disk{}, CustomError{"Locking needs the device to be an *os.File"}
+		}
+		if err := flockFile(fd, !opts.ReadOnly); err != nil {
+			fd.Close()
+			return Disk{}, err
+		}
 	}
-	// Open disk file
-	fd, err := os.Open(filename)
+	d, err := mountDevice(dev, opts.Validate, opts.ReadOnly)
 	if err != nil {
-		fd.Close()
 		return Disk{}, err
 	}
-	// Create struct and read data from file
-	d := Disk{fd: fd}
-	err = d.readSuperblock()
+	d.noOpenCheck, d.noSync = opts.NoOpenCheck, opts.NoSync
+	return d, nil
+}
+
+// Reads the superblock from an opened device, optionally validating it.
+// The device is closed if mounting fails.
+// Scope: internal
+func mountDevice(dev BlockDevice, validate, readOnly bool) (Disk, error) {
+	// Create struct and read data from device
+	d := Disk{
+		fd:       dev,
+		open:     make(map[string]bool),
+		closers:  make(map[string]func() error),
+		locks:    newLockTable(),
+		pool:     &bufferPool{},
+		appendMu: new(sync.Mutex),
+		readOnly: readOnly,
+	}
+	err := d.readSuperblock()
 	if err != nil {
-		fd.Close()
+		dev.Close()
+		return Disk{}, err
+	}
+	// a primary failing its checksum gives way to a sound backup
+	if _, err = d.fallBackToBackup(); err != nil {
+		dev.Close()
 		return Disk{}, err
 	}
+	if d.sig != SbSig {
+		dev.Close()
+		return Disk{}, InvalidSignatureError{d.sig}
+	}
+	// newer images may use fields this code doesn't know to maintain
+	if d.version > FsVersion {
+		dev.Close()
+		return Disk{}, UnsupportedVersionError{d.version, FsVersion}
+	}
+	if validate {
+		if err = d.validateSuperblock(); err != nil {
+			dev.Close()
+			return Disk{}, err
+		}
+	}
+	// finish or discard any metadata update interrupted by a crash
+	if d.journalInd != 0 && !d.readOnly {
+		if err = d.replayJournal(); err != nil {
+			dev.Close()
+			return Disk{}, err
+		}
+	}
 	return d, nil
 }
 
+// Creates a new, empty file with given filename and opens it. The start
+// block is zeroed, so nothing left by a removed file can surface in it.
+// Returns: (File structure reference, any error that occurred)
 func (d *Disk) Create(filename string) (File, error) {
+	return d.create(filename, 0)
+}
+
+// Creates a new, empty file whose root entry carries the given attributes
+// Scope: internal
+func (d *Disk) create(filename string, attr byte) (File, error) {
+	return d.createNear(filename, attr, 0)
+}
+
+// Creates a new, empty file with given filename like Create, placing its
+// start block at the first free data block from near onwards, e.g. just
+// past the chain of a file it will be read together with. The hint is
+// best effort: when no block from near on is free, or near is outside the
+// data region, the first free block is used as usual. Later growth of the
+// file is allocated as for any other file.
+// Returns: (File structure reference, any error that occurred)
+// Scope: exported
+func (d *Disk) CreateNear(filename string, near int) (File, error) {
+	return d.createNear(filename, 0, near)
+}
+
+// Creates a new, empty file with the given attributes, its start block
+// placed as by CreateNear
+// Scope: internal
+func (d *Disk) createNear(filename string, attr byte, near int) (File, error) {
+	if err := d.checkWritable(); err != nil {
+		return File{}, err
+	}
+	if err := d.ValidateName(filename); err != nil {
+		return File{}, err
+	}
+	filename = d.normName(filename)
+	// an open handle takes precedence over the directory, as for Open
+	if d.checkIsOpen(filename) && !d.noOpenCheck {
+		return File{}, FileAlreadyInUseError{filename}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return File{}, err
+	}
 	// find free data block entry in fat
-	blockInd, err := d.initFatChain()
+	blockInd, fatWrite, err := d.initFatChainNear(near)
 	if err != nil {
 		return File{}, err
 	}
+	// the block may still hold a removed file's data; it's cleared before
+	// the entry claims it
+	if _, err = d.fd.WriteAt(make([]byte, BlockSize), int64((d.dataStartInd+blockInd)*BlockSize)); err != nil {
+		return File{}, err
+	}
 	// add root directory entry for file
-	rootInd, err := d.initRootEntry(filename, blockInd)
+	rootInd, err := d.initRootEntry(rootBuff, filename, blockInd)
+	if err != nil {
+		return File{}, err
+	}
+	rootBuff[rootInd*RootEntrySize+RootEntryAttrOffset] = attr
+	// both updates land together, so a failure can't leak the block
+	err = d.writeMeta(fatWrite, metaWrite{d.rootDirInd, rootBuff})
 	if err != nil {
 		return File{}, err
 	}
+	d.adjustFree(-1)
 	// set file open flag true
 	d.open[filename] = true
 	return File{
 		name:   filename,
 		disk:   d,
-		desc:   rootInd,
+		desc:   blockInd,
+		entry:  rootInd,
+		attr:   attr,
 		offset: 0,
 		size:   0,
 	}, nil
 }
 
-// Opens the file with given filename, if not already open.
+// Creates a temporary file named by the prefix followed by the lowest
+// number not already taken in the root directory. Closing the file, or the
+// disk's CloseAll, removes it again unless File.Keep is called first.
+// Returns: (File structure reference, any error that occurred)
+// Scope: exported
+func (d *Disk) CreateTemp(prefix string) (File, error) {
+	if d.closed {
+		return File{}, DiskClosedError{}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return File{}, err
+	}
+	name := prefix + "0"
+	for n := 1; d.findRootEntry(rootBuff, name) >= 0; n++ {
+		name = prefix + strconv.Itoa(n)
+	}
+	// Create rejects names too long to store rather than truncating them
+	file, err := d.Create(name)
+	if err != nil {
+		return File{}, err
+	}
+	file.temp = true
+	d.closers[file.name] = func() error {
+		delete(d.open, file.name)
+		return d.Remove(file.name)
+	}
+	return file, nil
+}
+
+// Creates a new, empty file like Create, except that a filename already
+// taken gets a numeric suffix ahead of its extension, as in "file (1).txt",
+// with the lowest number giving a free name. Where the name and suffix
+// together would be too long for a root entry, the name before the
+// extension is cut to make room; an extension too long to keep any of it
+// is treated as part of the name.
+// Returns: (File structure reference, filename the file was created
+// under, any error that occurred)
+// Scope: exported
+func (d *Disk) CreateUnique(filename string) (File, string, error) {
+	name, err := d.uniqueName(filename)
+	if err != nil {
+		return File{}, "", err
+	}
+	file, err := d.Create(name)
+	if err != nil {
+		return File{}, "", err
+	}
+	return file, name, nil
+}
+
+// Opens the file with given filename, if not already open. Encrypted
+// files fail with an EncryptedFileError; they open with OpenEncrypted.
 // Returns: (File structure reference, any error that occurred)
 func (d *Disk) Open(filename string) (File, error) {
-	if d.checkIsOpen(filename) {
+	return d.openFile(filename, nil)
+}
+
+// Opens the file with given filename, decrypting its contents with the
+// given cipher, which is nil for files stored in the clear
+// Returns: (File structure reference, any error that occurred)
+// Scope: internal
+func (d *Disk) openFile(filename string, block cipher.Block) (File, error) {
+	if d.closed {
+		return File{}, DiskClosedError{}
+	}
+	filename = d.normName(filename)
+	if d.checkIsOpen(filename) && !d.noOpenCheck {
 		return File{}, FileAlreadyInUseError{filename}
 	}
 	file := File{
@@ -120,16 +512,218 @@ func (d *Disk) Open(filename string) (File, error) {
 	if err != nil {
 		return File{}, err
 	}
+	if err = file.useCipher(block); err != nil {
+		return File{}, err
+	}
 	// if no errors encountered, set open flag true
 	d.open[filename] = true
 	return file, nil
 }
 
+// Opens the file with given filename, creating it if it doesn't exist
+// Returns: (File structure reference, whether the file was created, any
+// error that occurred)
+// Scope: exported
+func (d *Disk) OpenOrCreate(filename string) (File, bool, error) {
+	file, err := d.Open(filename)
+	if _, ok := err.(FileNotFoundError); ok {
+		file, err = d.Create(filename)
+		return file, err == nil, err
+	}
+	return file, false, err
+}
+
+// Opens the file with given filename positioned at its end, so that
+// subsequent writes continue from the existing contents. Every Write on
+// the handle goes to the end of the file as stored, even where other
+// handles, e.g. under WithoutOpenCheck, have grown it in the meantime, and
+// appends from several goroutines are serialized so each lands whole.
+// Other operations are no safer for concurrent use than before.
+// Returns: (File structure reference, any error that occurred)
+func (d *Disk) OpenAppend(filename string) (File, error) {
+	file, err := d.Open(filename)
+	if err != nil {
+		return File{}, err
+	}
+	file.offset = file.size
+	file.append = true
+	return file, nil
+}
+
+// Closes the disk file. Any File handles still referring to the disk
+// return a DiskClosedError from subsequent operations.
+// Scope: exported
+func (d *Disk) Close() error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	d.closed = true
+	// syncs skipped along the way are made up for once, at the end
+	if d.noSync {
+		if err := d.fd.Sync(); err != nil {
+			d.fd.Close()
+			return err
+		}
+	}
+	return d.fd.Close()
+}
+
+// Flushes every write made so far to the device's stable storage
+// Scope: exported
+func (d *Disk) Sync() error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	return d.fd.Sync()
+}
+
+// Syncs the device to order writes, unless syncing was turned off with
+// WithoutSync
+// Scope: internal
+func (d *Disk) sync() error {
+	if d.noSync {
+		return nil
+	}
+	return d.fd.Sync()
+}
+
+// Closes every open file, storing any data their handles still buffer.
+// Handles are released even if storing fails; the failures are reported
+// together. Safe to call with no files open.
+// Returns: any errors encountered, combined in a MultiError
+// Scope: exported
+func (d *Disk) CloseAll() error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	var errs []error
+	for name := range d.open {
+		if closer, ok := d.closers[name]; ok {
+			if err := closer(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(d.closers, name)
+		delete(d.open, name)
+	}
+	// handles with nothing to store hold clean buffers, gone with them
+	d.pool.holders = nil
+	if len(errs) > 0 {
+		return MultiError{errs}
+	}
+	return nil
+}
+
+// Copies the whole disk image to a new disk file and mounts the copy,
+// giving an independent disk. The source is synced first so the copy
+// reflects every completed update; data buffered in open compressed files
+// isn't stored until they are closed, so it isn't copied.
+// Returns: (mounted copy of the disk, any error encountered)
+// Scope: exported
+func (d *Disk) CloneTo(filename string) (Disk, error) {
+	if d.closed {
+		return Disk{}, DiskClosedError{}
+	}
+	if len(filename) == 0 {
+		return Disk{}, InvalidFilenameError{filename, "empty"}
+	}
+	if err := d.fd.Sync(); err != nil {
+		return Disk{}, err
+	}
+	dst, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return Disk{}, err
+	}
+	image := io.NewSectionReader(d.fd, 0, int64(d.blockCt*BlockSize))
+	if _, err = io.Copy(dst, image); err == nil {
+		err = dst.Sync()
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(filename)
+		return Disk{}, err
+	}
+	return mountDevice(dst, false, false)
+}
+
+// Writes data to the file with given filename, creating it if necessary
+// and otherwise replacing its contents, then closes it. If the write
+// fails, e.g. with a FullDiskError, a file it created is removed again.
+// Scope: exported
+func (d *Disk) WriteFile(filename string, data []byte) error {
+	file, created, err := d.openTruncated(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		if created {
+			d.Remove(file.name)
+		}
+		return err
+	}
+	return file.Close()
+}
+
+// Opens the file with given filename emptied, creating it if necessary
+// Returns: (File structure reference, whether the file was created, any
+// error that occurred)
+// Scope: internal
+func (d *Disk) openTruncated(filename string) (File, bool, error) {
+	if err := d.checkWritable(); err != nil {
+		return File{}, false, err
+	}
+	file, err := d.Open(filename)
+	if _, ok := err.(FileNotFoundError); ok {
+		file, err = d.Create(filename)
+		return file, err == nil, err
+	}
+	if err != nil {
+		return File{}, false, err
+	}
+	if err = file.Truncate(0); err != nil {
+		file.Close()
+		return File{}, false, err
+	}
+	return file, false, nil
+}
+
+// Reads the whole contents of the file with given filename, then closes it
+// Returns: (file contents, any error encountered)
+// Scope: exported
+func (d *Disk) ReadFile(filename string) ([]byte, error) {
+	file, err := d.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, file.size)
+	if _, err = file.ReadAt(data, 0); err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	return data, file.Close()
+}
+
+// Streams the contents of the file with given filename through the hash,
+// opening and closing the file around it
+// Scope: exported
+func (d *Disk) HashFile(filename string, h hash.Hash) error {
+	file, err := d.Open(filename)
+	if err != nil {
+		return err
+	}
+	if err = file.Hash(h); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 // Instantiates a new disk and creates the associated file
 // Scope: internal
 func createDisk(filename string, dataBlocks int) (Disk, error) {
 	if len(filename) == 0 {
-		return Disk{}, InvalidFilenameError{filename}
+		return Disk{}, InvalidFilenameError{filename, "empty"}
 	}
 
 	file, err := os.Create(filename)
@@ -142,6 +736,10 @@ func createDisk(filename string, dataBlocks int) (Disk, error) {
 		fd: file,
 		dataBlockCt: dataBlocks,
 		open: make(map[string]bool),
+		closers: make(map[string]func() error),
+		locks: newLockTable(),
+		pool: &bufferPool{},
+		appendMu: new(sync.Mutex),
 	}, nil
 }
 
@@ -149,16 +747,49 @@ func createDisk(filename string, dataBlocks int) (Disk, error) {
 // Scope: internal
 func (d *Disk) initFS() error {
 	numFATBlks := int(math.Ceil((FatEntrySize * float64(d.dataBlockCt)) / BlockSize))
-	numTotalBlks := 2 + numFATBlks + d.dataBlockCt
-	// initialize full disk
-	_, err := d.fd.Write(make([]byte, numTotalBlks*BlockSize))
-	if err != nil {
+	// the size table and the backup superblock follow the journal
+	numTotalBlks := 2 + numFATBlks + d.dataBlockCt + d.journalBlockCt + 2
+	if err := checkGeometry(numFATBlks, numTotalBlks, d.dataBlockCt); err != nil {
+		return err
+	}
+	// initialize full disk, or for a sparse image just the metadata ahead
+	// of the data region, leaving the host to zero fill the rest
+	truncater, canTruncate := d.fd.(interface{ Truncate(int64) error })
+	if d.sparse && canTruncate {
+		if _, err := d.fd.WriteAt(make([]byte, (2+numFATBlks)*BlockSize), 0); err != nil {
+			return err
+		}
+		if err := truncater.Truncate(int64(numTotalBlks * BlockSize)); err != nil {
+			return err
+		}
+	} else if _, err := d.fd.WriteAt(make([]byte, numTotalBlks*BlockSize), 0); err != nil {
 		return err
 	}
 	// create superblock
 	if err := d.initSuperblock(); err != nil {
 		return err
 	}
+	// every data block of a fresh FAT is free
+	d.freeCt, d.freeValid = d.dataBlockCt, true
+	return nil
+}
+
+// Verifies that a layout fits the superblock fields and FAT entries that
+// record it, so no count is silently truncated when written
+// Scope: internal
+func checkGeometry(fatBlocks, totalBlocks, dataBlocks int) error {
+	if fatBlocks >= 1<<(8*SbFatBlockCtSize) {
+		return DiskGeometryError{"FAT block count", fatBlocks, 1<<(8*SbFatBlockCtSize) - 1}
+	}
+	if totalBlocks >= 1<<(8*SbBlockCtSize) {
+		return DiskGeometryError{"block count", totalBlocks, 1<<(8*SbBlockCtSize) - 1}
+	}
+	// FAT entries hold data block indices, with FatEoc reserved, so the
+	// highest index must stay below it. The block count field is the
+	// tighter limit on its own, but this holds whatever its size.
+	if dataBlocks > FatEoc {
+		return DiskGeometryError{"data block count", dataBlocks, FatEoc}
+	}
 	return nil
 }
 
@@ -167,8 +798,10 @@ func (d *Disk) initFS() error {
 func (d *Disk) initSuperblock() error {
 	// (2 bytes per FAT Entry) * (Num FAT Entries) / (Num bytes per block)
 	numFatBlks := int(math.Ceil((FatEntrySize * float64(d.dataBlockCt)) / BlockSize))
-	// 1 block for superblock + 1 block for root directory + FAT + data
-	numBlks := 2 + numFatBlks + d.dataBlockCt
+	// 1 block for superblock + 1 block for root directory + FAT + data +
+	// journal + 1 block for the size table + 1 block for the backup
+	// superblock
+	numBlks := 2 + numFatBlks + d.dataBlockCt + d.journalBlockCt + 2
 	// initialize superblock byte slice and extract subslices for each section
 	superblock := make([]byte, BlockSize)
 	sig := superblock[:SbSigSize]
@@ -177,35 +810,66 @@ func (d *Disk) initSuperblock() error {
 	dataStartInd := superblock[SbDataStartIndOffset:(SbDataStartIndOffset + SbDataStartIndSize)]
 	dataBlockCt := superblock[SbDataBlockCtOffset:(SbDataBlockCtOffset + SbDataBlockCtSize)]
 	fatBlockCt := superblock[SbFatBlockCtOffset:(SbFatBlockCtOffset + SbFatBlockCtSize)]
+	journalInd := superblock[SbJournalIndOffset:(SbJournalIndOffset + SbJournalIndSize)]
+	journalBlockCt := superblock[SbJournalBlockCtOffset:(SbJournalBlockCtOffset + SbJournalBlockCtSize)]
+	byteOrder := superblock[SbByteOrderOffset:(SbByteOrderOffset + SbByteOrderSize)]
+	version := superblock[SbVersionOffset:(SbVersionOffset + SbVersionSize)]
+	namePolicy := superblock[SbNamePolicyOffset:(SbNamePolicyOffset + SbNamePolicySize)]
 	// calculate values and store in disk structure
 	d.sig = SbSig
+	d.version = FsVersion
 	d.blockCt = numBlks
 	d.rootDirInd = 1 + numFatBlks
 	d.dataStartInd = 2 + numFatBlks
 	d.fatBlockCt = numFatBlks
+	if d.journalBlockCt > 0 {
+		d.journalInd = 2 + numFatBlks + d.dataBlockCt
+	}
 	// write data to each subslice
 	copy(sig, d.sig)
-	binary.LittleEndian.PutUint16(blockCt, uint16(d.blockCt))
-	binary.LittleEndian.PutUint16(rootDirInd, uint16(d.rootDirInd))
-	binary.LittleEndian.PutUint16(dataStartInd, uint16(d.dataStartInd))
-	binary.LittleEndian.PutUint16(dataBlockCt, uint16(d.dataBlockCt))
+	d.byteOrder().PutUint16(blockCt, uint16(d.blockCt))
+	d.byteOrder().PutUint16(rootDirInd, uint16(d.rootDirInd))
+	d.byteOrder().PutUint16(dataStartInd, uint16(d.dataStartInd))
+	d.byteOrder().PutUint16(dataBlockCt, uint16(d.dataBlockCt))
 	fatBlockCt[0] = byte(d.fatBlockCt)
-	// write byte slice to beginning of disk file
-	var offset int64 = 0
-	_, err := d.fd.WriteAt(superblock, offset)
-	if err != nil {
-		return err
+	d.byteOrder().PutUint16(journalInd, uint16(d.journalInd))
+	d.byteOrder().PutUint16(journalBlockCt, uint16(d.journalBlockCt))
+	if d.bigEndian {
+		byteOrder[0] = ByteOrderBigEndian
 	}
-	return nil
+	version[0] = byte(d.version)
+	if d.foldCase {
+		namePolicy[0] = NamePolicyFoldCase
+	}
+	// checksum everything preceding the checksum field
+	crc := superblock[SbCrcOffset:(SbCrcOffset + SbCrcSize)]
+	d.byteOrder().PutUint32(crc, crc32.ChecksumIEEE(superblock[:SbCrcOffset]))
+	// write byte slice to beginning of disk file, and its backup to the end
+	return d.writeSuperblock(superblock)
 }
 
+// Reads the superblock copy in use, the primary unless the disk was
+// mounted from the backup, and loads its fields
+// Scope: internal
 func (d *Disk) readSuperblock() error {
-	var offset int64 = 0
+	offset := int64(d.superblockInd() * BlockSize)
 	superblock := make([]byte, BlockSize)
-	_, err := d.fd.ReadAt(superblock, offset)
-	if err != nil {
+	n, err := d.fd.ReadAt(superblock, offset)
+	if err != nil && err != io.EOF {
 		return err
 	}
+	// a short read leaves the rest of the buffer zero, which would decode
+	// as a plausible superblock
+	if n < BlockSize {
+		return TruncatedDiskError{n}
+	}
+	d.decodeSuperblock(superblock)
+	return nil
+}
+
+// Loads the disk's fields from a superblock image
+// Scope: internal
+func (d *Disk) decodeSuperblock(superblock []byte) {
 	// load fields as subslices
 	sig := superblock[:SbSigSize]
 	blockCt := superblock[SbBlockCtOffset:(SbBlockCtOffset + SbBlockCtSize)]
@@ -213,76 +877,883 @@ func (d *Disk) readSuperblock() error {
 	dataStartInd := superblock[SbDataStartIndOffset:(SbDataStartIndOffset + SbDataStartIndSize)]
 	dataBlockCt := superblock[SbDataBlockCtOffset:(SbDataBlockCtOffset + SbDataBlockCtSize)]
 	fatBlockCt := superblock[SbFatBlockCtOffset:(SbFatBlockCtOffset + SbFatBlockCtSize)]
+	journalInd := superblock[SbJournalIndOffset:(SbJournalIndOffset + SbJournalIndSize)]
+	journalBlockCt := superblock[SbJournalBlockCtOffset:(SbJournalBlockCtOffset + SbJournalBlockCtSize)]
+	byteOrder := superblock[SbByteOrderOffset:(SbByteOrderOffset + SbByteOrderSize)]
+	version := superblock[SbVersionOffset:(SbVersionOffset + SbVersionSize)]
+	namePolicy := superblock[SbNamePolicyOffset:(SbNamePolicyOffset + SbNamePolicySize)]
+	// byte order decides how every other multi-byte field is decoded
+	d.bigEndian = byteOrder[0] == ByteOrderBigEndian
 	// read data from each subslice into correspond struct member
 	builder := strings.Builder{}
+	// the signature ends at the first null, so trailing bytes can't pass
+	// for part of it
+	if end := strings.IndexByte(string(sig), 0); end >= 0 {
+		sig = sig[:end]
+	}
 	builder.Write(sig)
 	d.sig = builder.String()
-	d.blockCt = int(binary.LittleEndian.Uint16(blockCt))
-	d.rootDirInd = int(binary.LittleEndian.Uint16(rootDirInd))
-	d.dataStartInd = int(binary.LittleEndian.Uint16(dataStartInd))
-	d.dataBlockCt = int(binary.LittleEndian.Uint16(dataBlockCt))
+	d.blockCt = int(d.byteOrder().Uint16(blockCt))
+	d.rootDirInd = int(d.byteOrder().Uint16(rootDirInd))
+	d.dataStartInd = int(d.byteOrder().Uint16(dataStartInd))
+	d.dataBlockCt = int(d.byteOrder().Uint16(dataBlockCt))
 	d.fatBlockCt = int(fatBlockCt[0])
+	d.journalInd = int(d.byteOrder().Uint16(journalInd))
+	d.journalBlockCt = int(d.byteOrder().Uint16(journalBlockCt))
+	// images from before versioning carry 0, their padding byte
+	d.version = int(version[0])
+	d.foldCase = namePolicy[0] == NamePolicyFoldCase
+}
 
+// Checks the superblock checksum and signature, and that the layout it
+// describes is self-consistent and matches the size of the disk file
+// Scope: internal
+func (d *Disk) validateSuperblock() error {
+	superblock := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(superblock, int64(d.superblockInd()*BlockSize)); err != nil {
+		return err
+	}
+	stored := d.byteOrder().Uint32(superblock[SbCrcOffset:(SbCrcOffset + SbCrcSize)])
+	computed := crc32.ChecksumIEEE(superblock[:SbCrcOffset])
+	if stored != computed {
+		return SuperblockChecksumError{stored, computed}
+	}
+	if d.sig != SbSig {
+		return InvalidSignatureError{d.sig}
+	}
+	// layout must follow from the data block count
+	numFatBlks := int(math.Ceil((FatEntrySize * float64(d.dataBlockCt)) / BlockSize))
+	if d.fatBlockCt != numFatBlks {
+		return CorruptSuperblockError{"fat block count"}
+	}
+	if d.rootDirInd != 1+numFatBlks {
+		return CorruptSuperblockError{"root directory index"}
+	}
+	if d.dataStartInd != 2+numFatBlks {
+		return CorruptSuperblockError{"data start index"}
+	}
+	if d.blockCt != 2+numFatBlks+d.dataBlockCt+d.journalBlockCt+d.sizeTableBlockCt()+d.backupBlockCt() {
+		return CorruptSuperblockError{"block count"}
+	}
+	if d.journalBlockCt > 0 && d.journalInd != 2+numFatBlks+d.dataBlockCt {
+		return CorruptSuperblockError{"journal index"}
+	}
+	if policy := superblock[SbNamePolicyOffset]; policy != NamePolicyCaseSensitive && policy != NamePolicyFoldCase {
+		return CorruptSuperblockError{"name policy"}
+	}
+	// disk file must hold exactly the declared blocks
+	fStat, err := d.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if expected := int64(d.blockCt * BlockSize); fStat.Size() != expected {
+		return DiskSizeMismatchError{expected, fStat.Size()}
+	}
 	return nil
 }
 
-// Locates a free fat entry and writes End-Of-Chain value to it.
+// Reports whether block indexes a real data block. fatBlockCt rounds the
+// FAT up to whole blocks, so its last block usually has entries past the
+// final data block; they read as unused but name no block. Indexes taken
+// from the FAT or from callers are checked against this bound, never
+// against the length of the FAT.
+// Scope: internal
+func (d *Disk) validBlock(block int) bool {
+	return block >= 0 && block < d.dataBlockCt
+}
+
+// Locates a free fat entry in the FAT buffer and writes End-Of-Chain value to it.
+// The scan starts at the allocation cursor and wraps around to the start.
 // Otherwise returns a Full Disk Error
-func (d *Disk) initFatChain() (int, error) {
-	fatBuff := make([]byte, d.fatBlockCt*BlockSize)
-	offset := int64(BlockSize)
-	d.fd.ReadAt(fatBuff, offset)
-	for i := 0; i < len(fatBuff); i += FatEntrySize {
+// Returns: (index of the allocated data block, any error encountered)
+func (d *Disk) initFatChain(fatBuff []byte) (int, error) {
+	// wrapping at the last data block keeps the scan out of the FAT's
+	// padding, which holds no real blocks
+	for n := 0; n < d.dataBlockCt; n++ {
+		i := (d.allocCursor + n) % d.dataBlockCt * FatEntrySize
 		fatEntry := fatBuff[i : i+FatEntrySize]
-		fatVal := binary.LittleEndian.Uint16(fatEntry)
 		// find unused fat entry (i.e. has value 0)
-		if fatVal == FatEntryUnused {
-			binary.LittleEndian.PutUint16(fatEntry, FatEoc)
-			d.fd.WriteAt(fatBuff, offset)
-			return i, nil
+		if d.blockFree(fatBuff, i/FatEntrySize) {
+			d.byteOrder().PutUint16(fatEntry, FatEoc)
+			return i / FatEntrySize, nil
 		}
 	}
 	return 0, FullDiskError{}
 }
 
-// Writes a new root directory entry for the specified file, if space is available
-// Returns: (index of entry in directory, any error encountered)
-// Scope: Internal
-func (d *Disk) initRootEntry(filename string, startBlock int) (int, error) {
-	rootBuff := make([]byte, BlockSize)
-	offset := int64(d.rootDirInd * BlockSize)
-	d.fd.ReadAt(rootBuff, offset)
-	for i := 0; i < len(rootBuff); i += RootEntrySize {
-		rootEntry := rootBuff[i : i+RootEntrySize]
-		name := rootEntry[:RootEntryFilenameSize]
-		// check if entry is empty (i.e. name is null)
-		if name[0] == 0 {
-			// set filename
-			copy(name, filename)
-			// set first data block
-			dtBlkOffset := RootEntryFilenameSize + RootEntrySizeFieldSize
-			first := rootEntry[dtBlkOffset : dtBlkOffset+RootEntryStartBlockSize]
-			binary.LittleEndian.PutUint16(first, uint16(startBlock))
-			// write back to disk
-			d.fd.WriteAt(rootBuff, offset)
-			return i, nil
-		}
-		// check if filename already exists
-		builder := strings.Builder{}
-		builder.Write(name)
-		if strings.Compare(builder.String(), filename) == 0 {
-			return 0, FileAlreadyExistsError{filename}
+// Sets the data block from which new files look for their start block,
+// wrapping around to block 0 if none is free from there on. The cursor
+// stays where it is set; a caller wanting next-fit allocation moves it
+// past each new file, and persists it itself if it should outlive the
+// mount. It starts at block 0 on every mount, giving first-fit.
+// Scope: exported
+func (d *Disk) SetAllocCursor(block int) error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	if !d.validBlock(block) {
+		return BlockOutOfRangeError{block, d.dataBlockCt}
+	}
+	d.allocCursor = block
+	return nil
+}
+
+// Starts a new chain like initFatChain, but at the first free data block
+// from near onwards if there is one. Rather than taking the whole FAT, it
+// reads one FAT block at a time and stops at the first free entry, so a
+// large FAT is never held in memory just to find one block.
+// Returns: (index of the chain's start block, write storing the FAT block
+// that records it, any error encountered)
+// Scope: internal
+func (d *Disk) initFatChainNear(near int) (int, metaWrite, error) {
+	if near > 0 && near < d.dataBlockCt {
+		block, w, err := d.claimFree(near, d.dataBlockCt-near)
+		if _, full := err.(FullDiskError); !full {
+			return block, w, err
+		}
+	}
+	return d.claimFree(d.allocCursor, d.dataBlockCt)
+}
+
+// Looks for a free data block among count blocks from start on, wrapping
+// around at the last data block as initFatChain does, and marks it the end
+// of a new chain. The FAT is read a block at a time, only as far as the
+// first free entry.
+// Returns: (index of the data block, write storing the FAT block holding
+// its entry, any error encountered)
+// Scope: internal
+func (d *Disk) claimFree(start, count int) (int, metaWrite, error) {
+	perBlock := BlockSize / FatEntrySize
+	chunk := make([]byte, BlockSize)
+	loaded := -1
+	for n := 0; n < count; n++ {
+		block := (start + n) % d.dataBlockCt
+		if fatBlock := block / perBlock; fatBlock != loaded {
+			if _, err := d.fd.ReadAt(chunk, int64((1+fatBlock)*BlockSize)); err != nil {
+				return 0, metaWrite{}, err
+			}
+			loaded = fatBlock
+		}
+		entry := chunk[block%perBlock*FatEntrySize : (block%perBlock+1)*FatEntrySize]
+		if d.byteOrder().Uint16(entry) == FatEntryUnused && !d.heldBlock(block) {
+			d.byteOrder().PutUint16(entry, FatEoc)
+			return block, metaWrite{1 + loaded, chunk}, nil
+		}
+	}
+	return 0, metaWrite{}, FullDiskError{}
+}
+
+// Writes a new root directory entry for the specified file into the
+// directory buffer, if space is available
+// Returns: (index of entry in directory, any error encountered)
+// Scope: Internal
+func (d *Disk) initRootEntry(rootBuff []byte, filename string, startBlock int) (int, error) {
+	// the whole directory is checked for the filename before claiming a slot,
+	// since removed files leave empty entries ahead of existing ones
+	if d.findRootEntry(rootBuff, filename) >= 0 {
+		return 0, FileAlreadyExistsError{filename}
+	}
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		rootEntry := rootBuff[i : i+RootEntrySize]
+		name := rootEntry[:RootEntryFilenameSize]
+		// check if entry is empty (i.e. name is null)
+		if name[0] == 0 {
+			// set filename
+			copy(name, filename)
+			// set first data block
+			dtBlkOffset := RootEntryFilenameSize + RootEntrySizeFieldSize
+			first := rootEntry[dtBlkOffset : dtBlkOffset+RootEntryStartBlockSize]
+			d.byteOrder().PutUint16(first, uint16(startBlock))
+			d.touchEntry(rootEntry)
+			return i / RootEntrySize, nil
 		}
 	}
 	return 0, RootDirFullError{}
 }
 
+// Reads the whole FAT region from disk
+// Scope: internal
+func (d *Disk) readFat() ([]byte, error) {
+	fatBuff := make([]byte, d.fatBlockCt*BlockSize)
+	if _, err := d.fd.ReadAt(fatBuff, BlockSize); err != nil {
+		return nil, err
+	}
+	return fatBuff, nil
+}
+
+// Reads the root directory block from disk
+// Scope: internal
+func (d *Disk) readRootDir() ([]byte, error) {
+	rootBuff := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(rootBuff, int64(d.rootDirInd*BlockSize)); err != nil {
+		return nil, err
+	}
+	return rootBuff, nil
+}
+
+// Locates the root directory entry for filename within the directory buffer
+// Returns: byte offset of the entry, or -1 if there is none
+// Scope: internal
+func (d *Disk) findRootEntry(rootBuff []byte, filename string) int {
+	filename = d.normName(filename)
+	if len(filename) == 0 || len(filename) > RootEntryFilenameSize {
+		return -1
+	}
+	// compare against the name as stored, null padded, so the scan
+	// allocates nothing per entry
+	var key [RootEntryFilenameSize]byte
+	copy(key[:], filename)
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		if bytes.Equal(rootBuff[i:i+RootEntryFilenameSize], key[:]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Allocates a data block to extend a chain, writing End-Of-Chain value to
+// its FAT entry. A FAT value of 0 marks an unused entry, so block 0 can only
+// ever head a chain and is never handed out here.
+// Returns: (index of the allocated data block, any error encountered)
+// Scope: internal
+func (d *Disk) allocBlock(fatBuff []byte) (int, error) {
+	for block := 1; block < d.dataBlockCt; block++ {
+		if d.blockFree(fatBuff, block) {
+			d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize], FatEoc)
+			return block, nil
+		}
+	}
+	return 0, FullDiskError{}
+}
+
+// Grows or shrinks a chain within the FAT buffer to exactly count blocks,
+// never dropping the start block. On error the buffer may be partially
+// updated and must be discarded.
+// Returns: (blocks of the resized chain, change in free blocks, any error)
+// Scope: internal
+func (d *Disk) resizeChain(fatBuff []byte, blocks []int, count int) ([]int, int, error) {
+	if count < 1 {
+		count = 1
+	}
+	delta := 0
+	for len(blocks) < count {
+		block, err := d.allocBlock(fatBuff)
+		if err != nil {
+			return blocks, delta, err
+		}
+		last := blocks[len(blocks)-1]
+		d.byteOrder().PutUint16(fatBuff[last*FatEntrySize:(last+1)*FatEntrySize], uint16(block))
+		blocks = append(blocks, block)
+		delta--
+	}
+	if len(blocks) > count {
+		for _, block := range blocks[count:] {
+			d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize], FatEntryUnused)
+			delta++
+		}
+		blocks = blocks[:count]
+		last := blocks[count-1]
+		d.byteOrder().PutUint16(fatBuff[last*FatEntrySize:(last+1)*FatEntrySize], FatEoc)
+	}
+	return blocks, delta, nil
+}
+
+// Appends one newly allocated block to a chain, storing the FAT as it goes.
+// The new block's End-Of-Chain entry is stored before its predecessor is
+// pointed at it, so the chain on disk is walkable after every step and a
+// crash at any point leaves at most one unlinked block allocated.
+// Returns: (blocks of the extended chain, any error encountered)
+// Scope: internal
+func (d *Disk) extendChain(fatBuff []byte, blocks []int) ([]int, error) {
+	block, err := d.allocBlock(fatBuff)
+	if err != nil {
+		return blocks, err
+	}
+	if err = d.writeMeta(d.fatWrite(fatBuff, block)); err != nil {
+		return blocks, err
+	}
+	last := blocks[len(blocks)-1]
+	d.byteOrder().PutUint16(fatBuff[last*FatEntrySize:(last+1)*FatEntrySize], uint16(block))
+	if err = d.writeMeta(d.fatWrite(fatBuff, last)); err != nil {
+		return blocks, err
+	}
+	d.adjustFree(-1)
+	return append(blocks, block), nil
+}
+
+// Builds the write storing the FAT block that holds a data block's entry
+// Scope: internal
+func (d *Disk) fatWrite(fatBuff []byte, block int) metaWrite {
+	fatBlock := block * FatEntrySize / BlockSize
+	return metaWrite{1 + fatBlock, fatBuff[fatBlock*BlockSize : (fatBlock+1)*BlockSize]}
+}
+
+// Sets a size-limit quota on the file with given filename. Writes that
+// would grow the file past maxBytes fail with a QuotaExceededError, even
+// when the disk has space. A maxBytes of 0 removes the quota.
+// Scope: exported
+func (d *Disk) SetQuota(filename string, maxBytes int) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if maxBytes < 0 || maxBytes > math.MaxUint32 {
+		return CustomError{"Quota out of range"}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	i := d.findRootEntry(rootBuff, filename)
+	if i < 0 {
+		return FileNotFoundError{filename}
+	}
+	quota := rootBuff[i+RootEntryQuotaOffset : i+RootEntryQuotaOffset+RootEntryQuotaSize]
+	d.byteOrder().PutUint32(quota, uint32(maxBytes))
+	return d.writeMeta(metaWrite{d.rootDirInd, rootBuff})
+}
+
+// Decodes the start block field of a root directory entry
+// Scope: internal
+func (d *Disk) entryStartBlock(entry []byte) int {
+	dtBlkOffset := RootEntryFilenameSize + RootEntrySizeFieldSize
+	return int(d.byteOrder().Uint16(entry[dtBlkOffset : dtBlkOffset+RootEntryStartBlockSize]))
+}
+
+// Follows the FAT chain beginning at the start block
+// Returns: (data block indices in chain order, any error encountered)
+// Scope: internal
+func (d *Disk) chainBlocks(fatBuff []byte, start int) ([]int, error) {
+	var blocks []int
+	for block := start; ; {
+		// a chain can never be longer than the data region, so anything
+		// else means it refers outside the region or loops back on itself
+		if !d.validBlock(block) || len(blocks) >= d.dataBlockCt {
+			return blocks, CorruptChainError{start}
+		}
+		blocks = append(blocks, block)
+		next := d.byteOrder().Uint16(fatBuff[block*FatEntrySize : (block+1)*FatEntrySize])
+		if next == FatEoc {
+			return blocks, nil
+		}
+		block = int(next)
+	}
+}
+
+// Counts the data blocks in the FAT chain of the file with given filename,
+// which need not be open
+// Returns: (number of blocks in chain, any error encountered)
+// Scope: exported
+func (d *Disk) BlockCountOf(filename string) (int, error) {
+	if d.closed {
+		return 0, DiskClosedError{}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return 0, err
+	}
+	i := d.findRootEntry(rootBuff, filename)
+	if i < 0 {
+		return 0, FileNotFoundError{filename}
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	blocks, err := d.chainBlocks(fatBuff, d.entryStartBlock(rootBuff[i:i+RootEntrySize]))
+	return len(blocks), err
+}
+
+// Removes the file with given filename, freeing its FAT chain and root
+// directory entry. The file must not be open.
+// Scope: exported
+func (d *Disk) Remove(filename string) error {
+	_, err := d.RemoveAll([]string{filename})
+	// single removals report the underlying error directly
+	if multi, ok := err.(MultiError); ok && len(multi.errs) == 1 {
+		return multi.errs[0]
+	}
+	return err
+}
+
+// Renames the file with given filename to newName, keeping its contents,
+// attributes and modification time, along with any offset saved by
+// OpenResume. The file must not be open, since handles hold its name:
+// renaming under one would leave it unable to close. newName must pass
+// ValidateName and not name another file.
+// Scope: exported
+func (d *Disk) Rename(filename, newName string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.ValidateName(newName); err != nil {
+		return err
+	}
+	filename, newName = d.normName(filename), d.normName(newName)
+	if d.checkIsOpen(filename) {
+		return FileAlreadyInUseError{filename}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	i := d.findRootEntry(rootBuff, filename)
+	if i < 0 || rootBuff[i+RootEntryAttrOffset]&AttrPending != 0 {
+		return FileNotFoundError{filename}
+	}
+	if newName == filename {
+		return nil
+	}
+	if d.findRootEntry(rootBuff, newName) >= 0 {
+		return FileAlreadyExistsError{newName}
+	}
+	name := rootBuff[i : i+RootEntryFilenameSize]
+	copy(name, make([]byte, RootEntryFilenameSize))
+	copy(name, newName)
+	if err = d.writeMeta(metaWrite{d.rootDirInd, rootBuff}); err != nil {
+		return err
+	}
+	return d.renameMetadata(MetaOffsetPrefix+filename, MetaOffsetPrefix+newName)
+}
+
+// Renames the file with given filename like Rename, except that a newName
+// already taken is given a numeric suffix as CreateUnique does. Renaming a
+// file to its own name leaves it as it is.
+// Returns: (filename the file was renamed to, any error that occurred)
+// Scope: exported
+func (d *Disk) RenameUnique(filename, newName string) (string, error) {
+	if d.normName(newName) == d.normName(filename) {
+		return d.normName(newName), d.Rename(filename, newName)
+	}
+	name, err := d.uniqueName(newName)
+	if err != nil {
+		return "", err
+	}
+	if err = d.Rename(filename, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Removes every named file in a single pass over the FAT and root
+// directory, writing each back to disk at most once. Files that are open
+// or do not exist are skipped and their errors collected into a MultiError.
+// Returns: (number of files removed, any errors encountered)
+// Scope: exported
+func (d *Disk) RemoveAll(names []string) (int, error) {
+	if err := d.checkWritable(); err != nil {
+		return 0, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	var metaKeys []string
+	var slots []int
+	removed, freed := 0, 0
+	for _, name := range names {
+		if d.checkIsOpen(name) {
+			errs = append(errs, FileAlreadyInUseError{name})
+			continue
+		}
+		i := d.findRootEntry(rootBuff, name)
+		if i < 0 {
+			errs = append(errs, FileNotFoundError{name})
+			continue
+		}
+		entry := rootBuff[i : i+RootEntrySize]
+		start := d.entryStartBlock(entry)
+		// free whatever part of the chain is intact, even if corrupt
+		blocks, err := d.chainBlocks(fatBuff, start)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, block := range blocks {
+			d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize], FatEntryUnused)
+		}
+		slots = append(slots, i/RootEntrySize)
+		copy(entry, make([]byte, RootEntrySize))
+		metaKeys = append(metaKeys, MetaOffsetPrefix+d.normName(name))
+		removed++
+		freed += len(blocks)
+	}
+	if removed > 0 {
+		err = d.writeMeta(metaWrite{1, fatBuff}, metaWrite{d.rootDirInd, rootBuff})
+		if err != nil {
+			return 0, err
+		}
+		d.adjustFree(freed)
+		// a later file of the same name starts afresh
+		if err = d.deleteMetadata(metaKeys...); err != nil {
+			errs = append(errs, err)
+		}
+		// and a later file in the same entry doesn't pick up an old size
+		// record or IV
+		for _, slot := range slots {
+			if err = d.clearSlot(slot); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return removed, MultiError{errs}
+	}
+	return removed, nil
+}
+
+// Reports the on-disk format version recorded in the superblock. Images
+// written before versioning report 0 and use the version 1 layout.
+// Scope: exported
+func (d *Disk) Version() int {
+	return d.version
+}
+
+// Reports the total number of blocks on the disk, superblock included
+// Scope: exported
+func (d *Disk) BlockCount() int {
+	return d.blockCt
+}
+
+// Reports the absolute block index of the root directory
+// Scope: exported
+func (d *Disk) RootDirIndex() int {
+	return d.rootDirInd
+}
+
+// Reports the absolute block index of the first data block
+// Scope: exported
+func (d *Disk) DataStartIndex() int {
+	return d.dataStartInd
+}
+
+// Reports the number of data blocks, which FAT entries and chains index
+// from 0
+// Scope: exported
+func (d *Disk) DataBlockCount() int {
+	return d.dataBlockCt
+}
+
+// Reports the number of blocks holding the FAT, which starts at block 1
+// Scope: exported
+func (d *Disk) FatBlockCount() int {
+	return d.fatBlockCt
+}
+
+// Reports the filesystem signature recorded in the superblock
+// Scope: exported
+func (d *Disk) Signature() string {
+	return d.sig
+}
+
+// Reports the number of free data blocks, from the cached count when one
+// is maintained and otherwise by scanning the FAT
+// Returns: (number of free data blocks, any error encountered)
+// Scope: exported
+func (d *Disk) FreeBlocks() (int, error) {
+	if d.freeValid {
+		return d.freeCt, nil
+	}
+	return d.RecomputeFree()
+}
+
+// Rescans the FAT for free data blocks and resets the cached free count to
+// the result. This is the authoritative count, for use after recovery
+// operations or manual edits of the FAT.
+// Returns: (number of free data blocks, any error encountered)
+// Scope: exported
+func (d *Disk) RecomputeFree() (int, error) {
+	if d.closed {
+		return 0, DiskClosedError{}
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	free := 0
+	for block := 0; block < d.dataBlockCt; block++ {
+		if d.blockFree(fatBuff, block) {
+			free++
+		}
+	}
+	d.freeCt, d.freeValid = free, true
+	return free, nil
+}
+
+// Applies a change in free data blocks to the cached count, if any
+// Scope: internal
+func (d *Disk) adjustFree(delta int) {
+	// blocks released while a snapshot holds them don't become free
+	if len(d.snapshots) > 0 {
+		d.freeValid = false
+	}
+	if d.freeValid {
+		d.freeCt += delta
+	}
+}
+
+// Reads the raw block at the given absolute index, bypassing the FAT and
+// root directory. Intended for inspection and repair tooling.
+// Returns: (BlockSize bytes of block contents, any error encountered)
+// Scope: exported
+func (d *Disk) ReadBlock(index int) ([]byte, error) {
+	if d.closed {
+		return nil, DiskClosedError{}
+	}
+	if index < 0 || index >= d.blockCt {
+		return nil, BlockOutOfRangeError{index, d.blockCt}
+	}
+	block := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(block, int64(index*BlockSize)); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Overwrites the raw block at the given absolute index with exactly
+// BlockSize bytes of data, bypassing the FAT, root directory and journal.
+// Intended for repair tooling; a careless write can corrupt the filesystem.
+// Scope: exported
+func (d *Disk) WriteBlock(index int, data []byte) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if index < 0 || index >= d.blockCt {
+		return BlockOutOfRangeError{index, d.blockCt}
+	}
+	if len(data) != BlockSize {
+		return CustomError{"Block data must be exactly BlockSize bytes"}
+	}
+	if _, err := d.fd.WriteAt(data, int64(index*BlockSize)); err != nil {
+		return err
+	}
+	// the cached free count can't be trusted after a raw FAT edit
+	if index >= 1 && index <= d.fatBlockCt {
+		d.freeValid = false
+	}
+	d.noteMetaWrite(metaWrite{index, data})
+	return nil
+}
+
+// Overwrites raw bytes of the superblock at the given offset, then
+// recomputes its checksum and reloads every field from it. Intended only
+// for recovering images whose superblock is wrong: nothing checks that the
+// new values make sense, and a careless edit can make the disk unusable.
+// Multi-byte values must be encoded in the disk's byte order.
+// Scope: exported
+func (d *Disk) SetSuperblockField(offset int, value []byte) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	// the checksum is always derived, never written directly
+	if offset < 0 || offset+len(value) > SbCrcOffset {
+		return CustomError{"Superblock field out of range"}
+	}
+	// a disk run from its backup is edited from the backup, so the primary
+	// is rewritten whole rather than patched over the damage
+	superblock := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(superblock, int64(d.superblockInd()*BlockSize)); err != nil {
+		return err
+	}
+	copy(superblock[offset:], value)
+	crc := superblock[SbCrcOffset:(SbCrcOffset + SbCrcSize)]
+	d.byteOrder().PutUint32(crc, crc32.ChecksumIEEE(superblock[:SbCrcOffset]))
+	if err := d.writeSuperblock(superblock); err != nil {
+		return err
+	}
+	// the layout may have moved under the cached free count and map
+	d.freeValid, d.mapValid, d.fragValid = false, false, false
+	return d.readSuperblock()
+}
+
+// Reloads every superblock field from the device, for images whose
+// superblock was changed by another tool while mounted. No file may be
+// open, since handles rely on the old layout. A superblock that fails the
+// checks made by Mount is rejected and the previous fields kept. With
+// checkSize set, the device size is also compared against the block count
+// and a DiskSizeMismatchError returned if they differ; the new fields are
+// loaded even then.
+// Scope: exported
+func (d *Disk) Rescan(checkSize bool) error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	for name, open := range d.open {
+		if open {
+			return FileAlreadyInUseError{name}
+		}
+	}
+	prev := *d
+	d.fromBackup = false
+	if err := d.readSuperblock(); err != nil {
+		*d = prev
+		return err
+	}
+	if _, err := d.fallBackToBackup(); err != nil {
+		*d = prev
+		return err
+	}
+	if d.sig != SbSig {
+		sig := d.sig
+		*d = prev
+		return InvalidSignatureError{sig}
+	}
+	if d.version > FsVersion {
+		version := d.version
+		*d = prev
+		return UnsupportedVersionError{version, FsVersion}
+	}
+	// the layout may have moved under the cached free count and map
+	d.freeValid, d.mapValid, d.fragValid = false, false, false
+	if checkSize {
+		fStat, err := d.fd.Stat()
+		if err != nil {
+			return err
+		}
+		if expected := int64(d.blockCt * BlockSize); fStat.Size() != expected {
+			return DiskSizeMismatchError{expected, fStat.Size()}
+		}
+	}
+	return nil
+}
+
+// Overwrites the superblock's data block count, as SetSuperblockField does
+// Scope: exported
+func (d *Disk) SetDataBlockCount(count int) error {
+	value := make([]byte, SbDataBlockCtSize)
+	d.byteOrder().PutUint16(value, uint16(count))
+	return d.SetSuperblockField(SbDataBlockCtOffset, value)
+}
+
+// Overwrites the superblock's root directory index, as SetSuperblockField
+// does
+// Scope: exported
+func (d *Disk) SetRootDirInd(index int) error {
+	value := make([]byte, SbRootDirIndSize)
+	d.byteOrder().PutUint16(value, uint16(index))
+	return d.SetSuperblockField(SbRootDirIndOffset, value)
+}
+
+// Returns the byte order of multi-byte on-disk fields
+// Scope: internal
+func (d *Disk) byteOrder() binary.ByteOrder {
+	if d.bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Checks the disk accepts modifications, before anything is written
+// Scope: internal
+func (d *Disk) checkWritable() error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	if d.readOnly {
+		return ReadOnlyFilesystemError{}
+	}
+	return nil
+}
+
 func (d *Disk) checkIsOpen(filename string) bool {
 	// check filename is in map and open flag is set to true
-	v, ok := d.open[filename]
+	v, ok := d.open[d.normName(filename)]
 	return ok && v
 }
 
+// Applies the disk's name policy, giving the form a filename is stored
+// and compared in
+// Scope: internal
+func (d *Disk) normName(filename string) string {
+	if d.foldCase {
+		return strings.ToLower(filename)
+	}
+	return filename
+}
+
+// Checks that name can be given to a new file, applying the rules Create
+// does: the name must be non-empty, free of slashes and control
+// characters, and, once folded if the disk ignores case, fit in a root
+// directory entry
+// Returns: InvalidFilenameError giving the rule broken, or nil
+// Scope: exported
+func (d *Disk) ValidateName(name string) error {
+	if reason := nameError(name); reason != "" {
+		return InvalidFilenameError{name, reason}
+	}
+	// folding can change the length of non-ASCII names
+	if len(d.normName(name)) > RootEntryFilenameSize {
+		return InvalidFilenameError{name, fmt.Sprintf("longer than %v bytes", RootEntryFilenameSize)}
+	}
+	return nil
+}
+
+// Picks a name for a new file from filename, suffixed as CreateUnique
+// describes if it is taken, either by a root entry or an open handle
+// Returns: (free name, normalized as stored, any error encountered)
+// Scope: internal
+func (d *Disk) uniqueName(filename string) (string, error) {
+	if err := d.ValidateName(filename); err != nil {
+		return "", err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return "", err
+	}
+	name := d.normName(filename)
+	taken := func(name string) bool {
+		return d.findRootEntry(rootBuff, name) >= 0 || d.checkIsOpen(name)
+	}
+	if !taken(name) {
+		return name, nil
+	}
+	base, ext := name, ""
+	if dot := strings.LastIndex(name, "."); dot > 0 {
+		base, ext = name[:dot], name[dot:]
+	}
+	// a full root directory takes at most this long a suffix to get past
+	longest := len(fmt.Sprintf(" (%d)", BlockSize/RootEntrySize))
+	if len(ext)+longest >= RootEntryFilenameSize {
+		base, ext = name, ""
+	}
+	for n := 1; ; n++ {
+		suffix := fmt.Sprintf(" (%d)", n) + ext
+		cut := RootEntryFilenameSize - len(suffix)
+		if cut >= len(base) {
+			cut = len(base)
+		} else {
+			// names are cut between characters, never inside one
+			for cut > 0 && !utf8.RuneStart(base[cut]) {
+				cut--
+			}
+		}
+		if candidate := base[:cut] + suffix; !taken(candidate) {
+			return candidate, nil
+		}
+	}
+}
+
+// Describes the first character rule filename breaks
+// Returns: reason the name is invalid, or "" if it isn't
+// Scope: internal
+func nameError(filename string) string {
+	if len(filename) == 0 {
+		return "empty"
+	}
+	for _, r := range filename {
+		if r == '/' {
+			return "contains a slash"
+		}
+		if r < 0x20 || r == 0x7F {
+			return fmt.Sprintf("contains control character %q", r)
+		}
+	}
+	return ""
+}
+
 func (d *Disk) loadRootEntry(file *File) error {
 	if file == nil {
 		return CustomError{"File structure nil"}
@@ -291,25 +1762,32 @@ func (d *Disk) loadRootEntry(file *File) error {
 		return CustomError{"Filename empty"}
 	}
 	// extract root directory
-	rootBuff := make([]byte, BlockSize)
-	rootOffset := int64(d.rootDirInd*BlockSize)
-	d.fd.ReadAt(rootBuff, rootOffset)
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
 	// find root entry for filename and load values into struct
-	for i := 0; i < len(rootBuff); i += RootEntrySize {
-		entry := rootBuff[i : i+RootEntrySize]
-		nameBuilder := strings.Builder{}
-		nameBuilder.Write(entry[:RootEntryFilenameSize])
-		// remove excess null characters
-		name := strings.Trim(nameBuilder.String(), "\x00")
-		// determine if current entry file name matches query
-		if 0 == strings.Compare(name, file.name) {
-			dtBlkOffset := RootEntryFilenameSize+RootEntrySizeFieldSize
-			size := entry[RootEntryFilenameSize : dtBlkOffset]
-			file.size = int(binary.LittleEndian.Uint32(size))
-			dtBlk := entry[dtBlkOffset : dtBlkOffset+RootEntryStartBlockSize]
-			file.desc = int(binary.LittleEndian.Uint16(dtBlk))
-			return nil
-		}
-	}
-	return FileNotFoundError{file.name}
+	i := d.findRootEntry(rootBuff, file.name)
+	if i < 0 {
+		return FileNotFoundError{file.name}
+	}
+	entry := rootBuff[i : i+RootEntrySize]
+	// reserved names stay hidden until committed
+	if entry[RootEntryAttrOffset]&AttrPending != 0 {
+		return FileNotFoundError{file.name}
+	}
+	dtBlkOffset := RootEntryFilenameSize+RootEntrySizeFieldSize
+	size := entry[RootEntryFilenameSize : dtBlkOffset]
+	file.size = int(d.byteOrder().Uint32(size))
+	dtBlk := entry[dtBlkOffset : dtBlkOffset+RootEntryStartBlockSize]
+	file.desc = int(d.byteOrder().Uint16(dtBlk))
+	// a start block outside the data region would send reads and writes
+	// to arbitrary offsets of the device
+	if !d.validBlock(file.desc) {
+		return CorruptEntryError{file.name, file.desc}
+	}
+	file.entry = i / RootEntrySize
+	file.attr = entry[RootEntryAttrOffset]
+	file.perm = entry[RootEntryModeOffset]
+	return nil
 }
\ No newline at end of file
@@ -1,11 +1,18 @@
 package disk
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math"
+	"math/rand"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -81,7 +88,8 @@ func TestDisk_New(t *testing.T) {
 			t.Error(err)
 		}
 		fatBlks := int(math.Ceil((FatEntrySize * float64(d.dataBlockCt)) / BlockSize))
-		totBlks := 2 + fatBlks + tBlockCt
+		// plus the size table and the backup superblock in the last block
+		totBlks := 2 + fatBlks + tBlockCt + 2
 		fLenExp := int64(totBlks * BlockSize)
 		fStat, _ := d.fd.Stat()
 		fLenGot := fStat.Size()
@@ -92,6 +100,44 @@ func TestDisk_New(t *testing.T) {
 		d.fd.Close()
 		os.Remove(tFilename)
 	})
+	t.Run("checkGeometry", func(t *testing.T) {
+		// enough data blocks to need 256 FAT blocks, past the 1-byte field
+		dataBlocks := 256 * BlockSize / FatEntrySize
+		fatBlocks := int(math.Ceil((FatEntrySize * float64(dataBlocks)) / BlockSize))
+		err := checkGeometry(fatBlocks, 2+fatBlocks+dataBlocks, dataBlocks)
+		if geomErr, ok := err.(DiskGeometryError); !ok || geomErr.field != "FAT block count" {
+			t.Errorf("Expected DiskGeometryError for FAT block count, Got %v", err)
+		}
+		if err = checkGeometry(1, 0x10000, 0xFFF0); err == nil {
+			t.Errorf("Expected DiskGeometryError for block count, Got nil")
+		}
+		if err = checkGeometry(1, 2+1+tBlockCt, tBlockCt); err != nil {
+			t.Errorf("Expected nil, Got %v", err)
+		}
+		// indices of 0xFFFF blocks stay below FatEoc, one more reaches it
+		if err = checkGeometry(1, 3, FatEoc); err != nil {
+			t.Errorf("Expected nil for %v data blocks, Got %v", FatEoc, err)
+		}
+		err = checkGeometry(1, 3, FatEoc+1)
+		if geomErr, ok := err.(DiskGeometryError); !ok || geomErr.field != "data block count" {
+			t.Errorf("Expected DiskGeometryError for data block count, Got %v", err)
+		}
+		// with the FAT and superblock, either count overflows the
+		// superblock's block count first
+		for _, dataBlocks := range []int{0xFFFE, 0xFFFF} {
+			_, err = New(tFilename, dataBlocks)
+			if geomErr, ok := err.(DiskGeometryError); !ok || geomErr.field != "block count" {
+				t.Errorf("Expected DiskGeometryError for block count with %v data blocks, Got %v", dataBlocks, err)
+			}
+		}
+		// New refuses the oversized layout before writing the image
+		if _, err = New(tFilename, dataBlocks); err == nil {
+			t.Errorf("Expected DiskGeometryError from New, Got nil")
+		}
+		if _, err = os.Stat(tFilename); !os.IsNotExist(err) {
+			t.Errorf("Expected disk file to be removed, Got %v", err)
+		}
+	})
 	// Test
 	d, err := New(tFilename, tBlockCt)
 	if err != nil {
@@ -117,7 +163,7 @@ func TestDisk_Mount(t *testing.T) {
 		d.readSuperblock()
 		sigExp := SbSig
 		fatBlockCtExp := int(math.Ceil((FatEntrySize * float64(d.dataBlockCt)) / BlockSize))
-		blockCtExp := 2 + fatBlockCtExp + tBlockCt
+		blockCtExp := 2 + fatBlockCtExp + tBlockCt + 2
 		rootDirIndExp := 1 + fatBlockCtExp
 		dataStartIndExp := 1 + rootDirIndExp
 		dataBlockCtExp := tBlockCt
@@ -142,11 +188,77 @@ func TestDisk_Mount(t *testing.T) {
 		// Teardown
 		fd.Close()
 	})
+	t.Run("truncated", func(t *testing.T) {
+		// Setup
+		ioutil.WriteFile("short.disk", make([]byte, 100), 0666)
+		// Test
+		_, err := Mount("short.disk")
+		if truncErr, ok := err.(TruncatedDiskError); !ok || truncErr.size != 100 {
+			t.Errorf("Expected TruncatedDiskError for 100 bytes, Got %v", err)
+		}
+		// Teardown
+		os.Remove("short.disk")
+	})
+	t.Run("signatureGarbage", func(t *testing.T) {
+		// Setup
+		d, _ := New("garbage.disk", tBlockCt)
+		// garbling the backup too, so Mount can't fall back on it
+		d.fd.WriteAt([]byte("NEWFA\x00FS"), 0)
+		d.fd.WriteAt([]byte("NEWFA\x00FS"), int64((d.blockCt-1)*BlockSize))
+		d.Close()
+		// Test
+		_, err := Mount("garbage.disk")
+		sigErr, ok := err.(InvalidSignatureError)
+		if !ok {
+			t.Fatalf("Expected InvalidSignatureError, Got %v", err)
+		}
+		if sigErr.sig != "NEWFA" {
+			t.Errorf("Expected signature trimmed to %q, Got %q", "NEWFA", sigErr.sig)
+		}
+		// Teardown
+		os.Remove("garbage.disk")
+	})
+	t.Run("version", func(t *testing.T) {
+		// Setup
+		d, _ := New("version.disk", tBlockCt)
+		backupOffset := int64((d.blockCt - 1) * BlockSize)
+		d.Close()
+		// Test
+		for _, tc := range []struct {
+			version byte
+			ok      bool
+		}{{0, true}, {FsVersion, true}, {FsVersion + 1, false}} {
+			fd, _ := os.OpenFile("version.disk", os.O_RDWR, 0)
+			fd.WriteAt([]byte{tc.version}, SbVersionOffset)
+			fd.WriteAt([]byte{tc.version}, backupOffset+SbVersionOffset)
+			fd.Close()
+			d, err := Mount("version.disk")
+			if !tc.ok {
+				if _, isVer := err.(UnsupportedVersionError); !isVer {
+					t.Errorf("Expected UnsupportedVersionError for version %v, Got %v", tc.version, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Expected version %v to mount, Got %v", tc.version, err)
+				continue
+			}
+			if d.Version() != int(tc.version) {
+				t.Errorf("Expected version %v, Got %v", tc.version, d.Version())
+			}
+			d.Close()
+		}
+		// Teardown
+		os.Remove("version.disk")
+	})
 	// Test
 	disk, err := Mount(tFilename)
 	if err != nil {
 		t.Error(err)
 	}
+	if disk.Version() != FsVersion {
+		t.Errorf("Expected version %v, Got %v", FsVersion, disk.Version())
+	}
 	if disk.fd == nil {
 		t.Errorf("Nil file descriptor for '%s'", tFilename)
 	}
@@ -155,6 +267,75 @@ func TestDisk_Mount(t *testing.T) {
 	os.Remove(tFilename)
 }
 
+func TestDisk_MountValidated(t *testing.T) {
+	// Setup
+	tFilename, tBlockCt := "test.disk", 64
+	// Test
+	t.Run("validateSuperblock", func(t *testing.T) {
+		// Setup
+		d, _ := New(tFilename, tBlockCt)
+		// Test
+		if err := d.validateSuperblock(); err != nil {
+			t.Error(err)
+		}
+		// corrupt a field without updating the checksum
+		d.fd.WriteAt([]byte{0xFF}, SbDataBlockCtOffset)
+		if _, ok := d.validateSuperblock().(SuperblockChecksumError); !ok {
+			t.Error("Expected SuperblockChecksumError for corrupted field")
+		}
+		// Teardown
+		d.fd.Close()
+		os.Remove(tFilename)
+	})
+	t.Run("badSignature", func(t *testing.T) {
+		// Setup
+		d, _ := New(tFilename, tBlockCt)
+		superblock := make([]byte, BlockSize)
+		d.fd.ReadAt(superblock, 0)
+		copy(superblock, "OLDFATFS")
+		crc := superblock[SbCrcOffset:(SbCrcOffset + SbCrcSize)]
+		binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(superblock[:SbCrcOffset]))
+		d.fd.WriteAt(superblock, 0)
+		d.fd.Close()
+		// Test
+		_, err := MountValidated(tFilename)
+		if _, ok := err.(InvalidSignatureError); !ok {
+			t.Errorf("Expected InvalidSignatureError, Got %v", err)
+		}
+		// Teardown
+		os.Remove(tFilename)
+	})
+	t.Run("sizeMismatch", func(t *testing.T) {
+		// Setup
+		d, _ := New(tFilename, tBlockCt)
+		d.Close()
+		os.Truncate(tFilename, int64((d.blockCt-1)*BlockSize))
+		// Test
+		_, err := MountValidated(tFilename)
+		if _, ok := err.(DiskSizeMismatchError); !ok {
+			t.Errorf("Expected DiskSizeMismatchError, Got %v", err)
+		}
+		// lenient mount still succeeds
+		disk, err := Mount(tFilename)
+		if err != nil {
+			t.Error(err)
+		}
+		// Teardown
+		disk.fd.Close()
+		os.Remove(tFilename)
+	})
+	// Test
+	d, _ := New(tFilename, tBlockCt)
+	d.fd.Close()
+	disk, err := MountValidated(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	//Teardown
+	disk.fd.Close()
+	os.Remove(tFilename)
+}
+
 func TestDisk_Create(t *testing.T) {
 	// Setup
 	tDiskFilename, tBlockCt := "test.disk", 64
@@ -163,14 +344,14 @@ func TestDisk_Create(t *testing.T) {
 	t.Run("initFatChain", func(t *testing.T) {
 		// Setup
 		d, _ := New(tDiskFilename, tBlockCt)
+		fatBuff := make([]byte, d.fatBlockCt*BlockSize)
+		d.fd.ReadAt(fatBuff, BlockSize) // fat is next block after superblock
 		// Test
-		blockInd, err := d.initFatChain()
+		blockInd, err := d.initFatChain(fatBuff)
 		if err != nil {
 			t.Error(err)
 		}
 		fatInd := FatEntrySize * blockInd
-		fatBuff := make([]byte, d.fatBlockCt*BlockSize)
-		d.fd.ReadAt(fatBuff, BlockSize) // fat is next block after superblock
 		eocGot := binary.LittleEndian.Uint16(fatBuff[fatInd : fatInd+FatEntrySize])
 		if eocGot != FatEoc {
 			t.Errorf("Expected EOC value %v, Got %v", FatEoc, eocGot)
@@ -182,19 +363,18 @@ func TestDisk_Create(t *testing.T) {
 	t.Run("initRootEntry", func(t *testing.T) {
 		// Setup
 		d, _ := New(tDiskFilename, tBlockCt)
-		blockInd, err := d.initFatChain()
+		fatBuff, _ := d.readFat()
+		blockInd, err := d.initFatChain(fatBuff)
 		if err != nil {
 			t.Error(err)
 		}
+		rootBuff, _ := d.readRootDir()
 		// Test
 		var entryInd int
-		entryInd, err = d.initRootEntry(tFilename, blockInd)
+		entryInd, err = d.initRootEntry(rootBuff, tFilename, blockInd)
 		if err != nil {
 			t.Error(err)
 		}
-		rootBuff := make([]byte, BlockSize)
-		offset := int64(d.rootDirInd * BlockSize)
-		d.fd.ReadAt(rootBuff, offset)
 		entryPos := entryInd * RootEntrySize
 		rootEntry := rootBuff[entryPos : entryPos+RootEntrySize]
 		builder := strings.Builder{}
@@ -240,6 +420,42 @@ func TestDisk_Create(t *testing.T) {
 	if file.size != 0 {
 		t.Errorf("Expected file size 0, Got %v", file.size)
 	}
+	t.Run("already open", func(t *testing.T) {
+		free, _ := d.FreeBlocks()
+		_, err := d.Create(tFilename)
+		if _, ok := err.(FileAlreadyInUseError); !ok {
+			t.Errorf("Expected FileAlreadyInUseError, Got %v", err)
+		}
+		if got, _ := d.FreeBlocks(); got != free {
+			t.Errorf("Expected %v free blocks, Got %v", free, got)
+		}
+		// once closed, the name is just taken
+		file.Close()
+		if _, err = d.Create(tFilename); err == nil {
+			t.Errorf("Expected FileAlreadyExistsError, Got nil")
+		}
+	})
+	t.Run("reused start block zeroed", func(t *testing.T) {
+		d.WriteFile("stale.txt", bytes.Repeat([]byte{0xAB}, BlockSize))
+		stale, _ := d.Open("stale.txt")
+		block := stale.desc
+		stale.Close()
+		d.Remove("stale.txt")
+		d.SetAllocCursor(block)
+		fresh, err := d.Create("fresh.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh.desc != block {
+			t.Fatalf("Expected start block %v reused, Got %v", block, fresh.desc)
+		}
+		raw := make([]byte, BlockSize)
+		fresh.ReadRaw(raw, 0)
+		if !bytes.Equal(raw, make([]byte, BlockSize)) {
+			t.Errorf("Expected the reused start block zeroed")
+		}
+		fresh.Close()
+	})
 }
 
 func TestDisk_Open(t *testing.T) {
@@ -289,6 +505,27 @@ func TestDisk_Open(t *testing.T) {
 		d.fd.Close()
 		os.Remove(tDiskFilename)
 	})
+	t.Run("corrupt start block", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		d.WriteFile(tFilename, []byte("data"))
+		rootBuff, _ := d.readRootDir()
+		i := d.findRootEntry(rootBuff, tFilename) + RootEntryFilenameSize + RootEntrySizeFieldSize
+		d.byteOrder().PutUint16(rootBuff[i:], uint16(tBlockCt))
+		d.WriteBlock(d.rootDirInd, rootBuff)
+		// Test
+		if _, err := d.Open(tFilename); err == nil {
+			t.Errorf("Expected CorruptEntryError, Got nil")
+		} else if _, ok := err.(CorruptEntryError); !ok {
+			t.Errorf("Expected CorruptEntryError, Got %v", err)
+		}
+		if d.checkIsOpen(tFilename) {
+			t.Errorf("Expected file left closed")
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
 	d, _ := New(tDiskFilename, tBlockCt)
 	file, _ := d.Create(tFilename)
 	err := file.Close()
@@ -306,3 +543,1429 @@ func TestDisk_Open(t *testing.T) {
 		t.Errorf("Expected file offset 0, Got %v", file.offset)
 	}
 }
+
+func TestDisk_MountReadOnly(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, []byte("contents"))
+	d.Close()
+	before, _ := ioutil.ReadFile(tDiskFilename)
+	d, err := MountReadOnly(tDiskFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Test
+	if data, err := d.ReadFile(tFilename); err != nil || string(data) != "contents" {
+		t.Errorf("Expected reads to succeed, Got %q, %v", data, err)
+	}
+	f, _ := d.Open(tFilename)
+	ops := map[string]func() error{
+		"Create": func() error {
+			_, err := d.Create("new.txt")
+			return err
+		},
+		"Remove": func() error {
+			return d.Remove("other.txt")
+		},
+		"WriteFile": func() error {
+			return d.WriteFile("new.txt", []byte("data"))
+		},
+		"OpenWriter": func() error {
+			_, err := d.OpenWriter("new.txt")
+			return err
+		},
+		"SetQuota": func() error {
+			return d.SetQuota(tFilename, BlockSize)
+		},
+		"Write": func() error {
+			_, err := f.Write([]byte("more"))
+			return err
+		},
+		"Truncate": func() error {
+			return f.Truncate(0)
+		},
+		"WriteBlock": func() error {
+			return d.WriteBlock(1, make([]byte, BlockSize))
+		},
+	}
+	for name, op := range ops {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := op().(ReadOnlyFilesystemError); !ok {
+				t.Errorf("Expected ReadOnlyFilesystemError")
+			}
+		})
+	}
+	f.Close()
+	d.Close()
+	after, _ := ioutil.ReadFile(tDiskFilename)
+	if !bytes.Equal(before, after) {
+		t.Errorf("Expected disk image unchanged by rejected operations")
+	}
+	// Teardown
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_MountLocked(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.Close()
+	// Test
+	// locks are per open file, so a second mount in this process conflicts
+	// just as another process's would
+	d, err := MountLocked(tDiskFilename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MountLocked(tDiskFilename, false); err == nil {
+		t.Errorf("Expected DiskBusyError for a second read-write mount, Got nil")
+	} else if _, ok := err.(DiskBusyError); !ok {
+		t.Errorf("Expected DiskBusyError, Got %v", err)
+	}
+	if _, err = MountLocked(tDiskFilename, true); err == nil {
+		t.Errorf("Expected DiskBusyError for a read-only mount, Got nil")
+	}
+	d.Close()
+	// closing releases the lock; read-only mounts share it
+	first, err := MountLocked(tDiskFilename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := MountLocked(tDiskFilename, true)
+	if err != nil {
+		t.Errorf("Expected read-only mounts to share the lock, Got %v", err)
+	}
+	if _, err = MountLocked(tDiskFilename, false); err == nil {
+		t.Errorf("Expected DiskBusyError for a read-write mount, Got nil")
+	}
+	if _, err = first.Create("test.txt"); err == nil {
+		t.Errorf("Expected read-only locked mount to reject writes")
+	}
+	// Teardown
+	first.Close()
+	second.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_SetAllocCursor(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if err := d.SetAllocCursor(tBlockCt); err == nil {
+		t.Errorf("Expected BlockOutOfRangeError past the data region, Got nil")
+	}
+	if err := d.SetAllocCursor(tBlockCt - 1); err != nil {
+		t.Error(err)
+	}
+	// the last block, then wrapping around to the first
+	a, _ := d.Create("a.txt")
+	b, _ := d.Create("b.txt")
+	if a.desc != tBlockCt-1 || b.desc != 0 {
+		t.Errorf("Expected start blocks %v and 0, Got %v and %v", tBlockCt-1, a.desc, b.desc)
+	}
+	// the cursor stays put, so the next file looks from it again
+	a.Close()
+	b.Close()
+	d.Remove("a.txt")
+	c, _ := d.Create("c.txt")
+	if c.desc != tBlockCt-1 {
+		t.Errorf("Expected start block %v, Got %v", tBlockCt-1, c.desc)
+	}
+	// Teardown
+	c.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_MountWithOptions(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+	d.WriteFile("test.txt", []byte("data"))
+	d.Close()
+	// Test
+	t.Run("defaults", func(t *testing.T) {
+		d, err := MountWithOptions(tDiskFilename, MountOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, _ := d.Open("test.txt")
+		if _, err = d.Open("test.txt"); err == nil {
+			t.Errorf("Expected FileAlreadyInUseError, Got nil")
+		}
+		f.Close()
+		d.Close()
+	})
+	t.Run("read-only and locked", func(t *testing.T) {
+		d, err := MountWithOptions(tDiskFilename, MountOptions{ReadOnly: true, Lock: true, Validate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := d.WriteFile("new.txt", nil).(ReadOnlyFilesystemError); !ok {
+			t.Errorf("Expected ReadOnlyFilesystemError")
+		}
+		d.Close()
+	})
+	t.Run("device", func(t *testing.T) {
+		fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+		syncs := 0
+		dev := syncCountingDevice{fd, &syncs}
+		d, err := MountWithOptions("", MountOptions{Device: dev, NoSync: true, NoOpenCheck: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, _ := d.Open("test.txt")
+		g, err := d.Open("test.txt")
+		if err != nil {
+			t.Errorf("Expected a second handle, Got %v", err)
+		}
+		f.Write([]byte("DATA"))
+		if syncs != 0 {
+			t.Errorf("Expected no syncs while writing, Got %v", syncs)
+		}
+		f.Close()
+		g.Close()
+		d.Close()
+		// only an *os.File can be locked
+		fd, _ = os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+		if _, err = MountWithOptions("", MountOptions{Device: syncCountingDevice{fd, &syncs}, Lock: true}); err == nil {
+			t.Errorf("Expected an error locking a custom device, Got nil")
+		}
+	})
+	// Teardown
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_CreateNear(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", nil)
+	// Test
+	f, err := d.CreateNear("b.txt", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.desc != 10 {
+		t.Errorf("Expected start block 10, Got %v", f.desc)
+	}
+	g, _ := d.CreateNear("c.txt", 10)
+	if g.desc != 11 {
+		t.Errorf("Expected start block 11 past the taken hint, Got %v", g.desc)
+	}
+	// unusable hints fall back to the first free block
+	h, _ := d.CreateNear("d.txt", tBlockCt)
+	if h.desc != 1 {
+		t.Errorf("Expected first-fit start block 1, Got %v", h.desc)
+	}
+	// Teardown
+	f.Close()
+	g.Close()
+	h.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_CreateTemp(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("tmp0", nil)
+	// Test
+	first, err := d.CreateTemp("tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := d.CreateTemp("tmp")
+	if first.name != "tmp1" || second.name != "tmp2" {
+		t.Errorf("Expected names tmp1 and tmp2, Got %s and %s", first.name, second.name)
+	}
+	first.Write(make([]byte, 2*BlockSize))
+	if err = first.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err = d.Open("tmp1"); err == nil {
+		t.Errorf("Expected temporary file removed on Close")
+	}
+	second.Keep()
+	second.Close()
+	kept, err := d.Open("tmp2")
+	if err != nil {
+		t.Errorf("Expected kept file to remain, Got %v", err)
+	}
+	kept.Close()
+	third, _ := d.CreateTemp("tmp")
+	if err = d.CloseAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err = d.Open(third.name); err == nil {
+		t.Errorf("Expected CloseAll to remove temporary file %s", third.name)
+	}
+	if free, _ := d.RecomputeFree(); free != tBlockCt-2 {
+		t.Errorf("Expected temporary chains freed, leaving %v free blocks, Got %v", tBlockCt-2, free)
+	}
+	if _, err = d.CreateTemp("averyverylongprefix"); err == nil {
+		t.Errorf("Expected InvalidFilenameError for overlong name, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_CreateUnique(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	for _, name := range []string{"file.txt", "file (1).txt", "sixteen-bytes.gz", "no-extension", ".hidden", "a.longextension"} {
+		d.WriteFile(name, nil)
+	}
+	// Test
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"free.txt", "free.txt"},
+		{"file.txt", "file (2).txt"},
+		{"sixteen-bytes.gz", "sixteen-b (1).gz"},
+		{"no-extension", "no-extension (1)"},
+		{".hidden", ".hidden (1)"},
+		{"a.longextension", "a.longextens (1)"},
+	}
+	for _, tt := range tests {
+		f, name, err := d.CreateUnique(tt.filename)
+		if err != nil {
+			t.Errorf("%v: %v", tt.filename, err)
+			continue
+		}
+		if name != tt.expected || f.name != tt.expected {
+			t.Errorf("Expected %q, Got %q", tt.expected, name)
+		}
+		f.Close()
+	}
+	// cuts fall between characters
+	d.WriteFile("éééééé.go", nil)
+	f, name, _ := d.CreateUnique("éééééé.go")
+	if name != "éééé (1).go" {
+		t.Errorf("Expected %q, Got %q", "éééé (1).go", name)
+	}
+	f.Close()
+	// names held open count as taken
+	f, _ = d.Create("open.txt")
+	if _, name, _ := d.CreateUnique("open.txt"); name != "open (1).txt" {
+		t.Errorf("Expected %q, Got %q", "open (1).txt", name)
+	}
+	f.Close()
+	if _, _, err := d.CreateUnique("a/b"); err == nil {
+		t.Errorf("Expected InvalidFilenameError, Got nil")
+	} else if _, ok := err.(InvalidFilenameError); !ok {
+		t.Errorf("Expected InvalidFilenameError, Got %v", err)
+	}
+	// Teardown
+	d.CloseAll()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_OpenOrCreate(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	f, created, err := d.OpenOrCreate(tFilename)
+	if err != nil || !created {
+		t.Errorf("Expected file created, Got %v, %v", created, err)
+	}
+	f.Write([]byte("kept"))
+	if _, created, err = d.OpenOrCreate(tFilename); err == nil || created {
+		t.Errorf("Expected FileAlreadyInUseError while open, Got %v, %v", created, err)
+	}
+	f.Close()
+	f, created, err = d.OpenOrCreate(tFilename)
+	if err != nil || created {
+		t.Errorf("Expected existing file opened, Got %v, %v", created, err)
+	}
+	if f.size != len("kept") {
+		t.Errorf("Expected existing contents of %v bytes, Got %v", len("kept"), f.size)
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_OpenAppend(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tSize := "test.txt", 100
+	d, _ := New(tDiskFilename, tBlockCt)
+	file, _ := d.Create(tFilename)
+	file.Close()
+	// store a nonzero size directly in the root entry
+	sizeBuff := make([]byte, RootEntrySizeFieldSize)
+	binary.LittleEndian.PutUint32(sizeBuff, uint32(tSize))
+	d.fd.WriteAt(sizeBuff, int64(d.rootDirInd*BlockSize+RootEntryFilenameSize))
+	// Test
+	file, err := d.OpenAppend(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if file.size != tSize {
+		t.Errorf("Expected file size %v, Got %v", tSize, file.size)
+	}
+	if file.offset != tSize {
+		t.Errorf("Expected file offset %v, Got %v", tSize, file.offset)
+	}
+	if !d.checkIsOpen(tFilename) {
+		t.Error("Expected open flag true, Got false")
+	}
+	// Teardown
+	d.fd.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_OpenAppendConcurrent(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tWriters, tAppends := "test.txt", 4, 50
+	d, _ := New(tDiskFilename, tBlockCt, WithoutOpenCheck())
+	d.WriteFile(tFilename, nil)
+	handles := make([]File, tWriters)
+	for i := range handles {
+		handles[i], _ = d.OpenAppend(tFilename)
+	}
+	// Test
+	var wg sync.WaitGroup
+	for i := range handles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < tAppends; j++ {
+				if _, err := handles[i].Write([]byte(fmt.Sprintf("w%d-%03d\n", i, j))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range handles {
+		handles[i].Close()
+	}
+	got, _ := d.ReadFile(tFilename)
+	lines := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+	if len(lines) != tWriters*tAppends {
+		t.Errorf("Expected %v markers, Got %v", tWriters*tAppends, len(lines))
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		seen[line] = true
+	}
+	for i := 0; i < tWriters; i++ {
+		for j := 0; j < tAppends; j++ {
+			if marker := fmt.Sprintf("w%d-%03d", i, j); !seen[marker] {
+				t.Errorf("Expected marker %s in the file", marker)
+			}
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Close(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, ok := d.Close().(DiskClosedError); !ok {
+		t.Error("Expected DiskClosedError on second close")
+	}
+	if _, err := d.Create(tFilename); err == nil {
+		t.Error("Expected error creating file on closed disk")
+	}
+	if _, err := d.Open(tFilename); err == nil {
+		t.Error("Expected error opening file on closed disk")
+	}
+	// Teardown
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_CloseAll(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if err := d.CloseAll(); err != nil {
+		t.Errorf("Expected nil with no open files, Got %v", err)
+	}
+	plain, _ := d.Create("plain.txt")
+	plain.Write([]byte("stored"))
+	compressed, _ := d.CreateCompressed("packed.txt")
+	compressed.Write([]byte("buffered until close"))
+	w, _ := d.OpenWriter("written.txt")
+	w.Write([]byte("held by the writer"))
+	if err := d.CloseAll(); err != nil {
+		t.Error(err)
+	}
+	expected := map[string]string{
+		"plain.txt":   "stored",
+		"packed.txt":  "buffered until close",
+		"written.txt": "held by the writer",
+	}
+	for name, data := range expected {
+		if d.checkIsOpen(name) {
+			t.Errorf("Expected %s released", name)
+		}
+		if got, err := d.ReadFile(name); err != nil || string(got) != data {
+			t.Errorf("Expected %s to hold %q, Got %q, %v", name, data, got, err)
+		}
+	}
+	if _, ok := plain.Close().(FileNotOpenError); !ok {
+		t.Errorf("Expected FileNotOpenError closing a drained handle")
+	}
+	d.Close()
+	// failed flushes are reported together, with every handle released
+	d, _ = New(tDiskFilename, 2)
+	noise := make([]byte, 4*BlockSize)
+	rand.New(rand.NewSource(1)).Read(noise)
+	compressed, _ = d.CreateCompressed("noise.txt")
+	compressed.Write(noise)
+	err := d.CloseAll()
+	if multi, ok := err.(MultiError); !ok || len(multi.Errors()) != 1 {
+		t.Errorf("Expected MultiError with 1 error, Got %v", err)
+	}
+	if d.checkIsOpen("noise.txt") {
+		t.Errorf("Expected noise.txt released after failed flush")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_CloneTo(t *testing.T) {
+	// Setup
+	tDiskFilename, tCloneFilename, tBlockCt := "test.disk", "clone.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+	d.WriteFile(tFilename, []byte("original"))
+	// Test
+	clone, err := d.CloneTo(tCloneFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := clone.ReadFile(tFilename); string(got) != "original" {
+		t.Errorf("Expected clone to read %q, Got %q", "original", got)
+	}
+	// the copies change independently
+	clone.WriteFile(tFilename, []byte("changed in clone"))
+	clone.WriteFile("extra.txt", []byte("extra"))
+	if got, _ := d.ReadFile(tFilename); string(got) != "original" {
+		t.Errorf("Expected source to keep %q, Got %q", "original", got)
+	}
+	if _, err = d.Open("extra.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError on source, Got nil")
+	}
+	clone.Close()
+	if clone, err = MountValidated(tCloneFilename); err != nil {
+		t.Errorf("Expected clone to validate, Got %v", err)
+	}
+	clone.Close()
+	if _, err = d.CloneTo(tCloneFilename); err == nil {
+		t.Errorf("Expected error cloning over an existing file, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+	os.Remove(tCloneFilename)
+}
+
+func TestDisk_Rename(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("old.txt", []byte("contents"))
+	d.WriteFile("taken.txt", nil)
+	f, _ := d.OpenResume("old.txt")
+	f.offset = 3
+	// Test
+	if _, ok := d.Rename("old.txt", "new.txt").(FileAlreadyInUseError); !ok {
+		t.Errorf("Expected FileAlreadyInUseError while the file is open")
+	}
+	f.Close()
+	if _, ok := d.Rename("old.txt", "taken.txt").(FileAlreadyExistsError); !ok {
+		t.Errorf("Expected FileAlreadyExistsError for a taken name")
+	}
+	if _, ok := d.Rename("old.txt", "a/b").(InvalidFilenameError); !ok {
+		t.Errorf("Expected InvalidFilenameError for an invalid name")
+	}
+	if _, ok := d.Rename("missing.txt", "new.txt").(FileNotFoundError); !ok {
+		t.Errorf("Expected FileNotFoundError for a missing file")
+	}
+	if err := d.Rename("old.txt", "new.txt"); err != nil {
+		t.Error(err)
+	}
+	if _, err := d.Open("old.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError for the old name, Got nil")
+	}
+	if got, _ := d.ReadFile("new.txt"); string(got) != "contents" {
+		t.Errorf("Expected contents under the new name, Got %q", got)
+	}
+	// the saved offset moves with the file
+	f, _ = d.OpenResume("new.txt")
+	if f.offset != 3 {
+		t.Errorf("Expected saved offset 3, Got %v", f.offset)
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_RenameUnique(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", []byte("a"))
+	d.WriteFile("b.txt", []byte("b"))
+	// Test
+	name, err := d.RenameUnique("a.txt", "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "b (1).txt" {
+		t.Errorf("Expected %q, Got %q", "b (1).txt", name)
+	}
+	if got, _ := d.ReadFile("b (1).txt"); string(got) != "a" {
+		t.Errorf("Expected the renamed contents, Got %q", got)
+	}
+	if got, _ := d.ReadFile("b.txt"); string(got) != "b" {
+		t.Errorf("Expected b.txt untouched, Got %q", got)
+	}
+	// a file keeps its own name
+	if name, err = d.RenameUnique("b.txt", "b.txt"); err != nil || name != "b.txt" {
+		t.Errorf("Expected b.txt kept, Got %q (%v)", name, err)
+	}
+	if _, err = d.RenameUnique("missing.txt", "b.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError, Got nil")
+	} else if _, ok := err.(FileNotFoundError); !ok {
+		t.Errorf("Expected FileNotFoundError, Got %v", err)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Remove(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tOtherFilename := "test.txt", "other.txt"
+	// Test
+	t.Run("findRootEntry", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		d.Create(tFilename)
+		d.Create(tOtherFilename)
+		rootBuff := make([]byte, BlockSize)
+		d.fd.ReadAt(rootBuff, int64(d.rootDirInd*BlockSize))
+		// Test
+		if i := d.findRootEntry(rootBuff, tOtherFilename); i != RootEntrySize {
+			t.Errorf("Expected entry offset %v, Got %v", RootEntrySize, i)
+		}
+		if i := d.findRootEntry(rootBuff, "missing.txt"); i != -1 {
+			t.Errorf("Expected entry offset -1, Got %v", i)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("chainBlocks", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		fatBuff := make([]byte, d.fatBlockCt*BlockSize)
+		binary.LittleEndian.PutUint16(fatBuff[0:], 3)
+		binary.LittleEndian.PutUint16(fatBuff[3*FatEntrySize:], 5)
+		binary.LittleEndian.PutUint16(fatBuff[5*FatEntrySize:], FatEoc)
+		// Test
+		blocks, err := d.chainBlocks(fatBuff, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(blocks, []int{0, 3, 5}) {
+			t.Errorf("Expected chain [0 3 5], Got %v", blocks)
+		}
+		// loop back to the start block
+		binary.LittleEndian.PutUint16(fatBuff[5*FatEntrySize:], 0)
+		if _, err = d.chainBlocks(fatBuff, 0); err == nil {
+			t.Error("Expected CorruptChainError for looping chain")
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	d, _ := New(tDiskFilename, tBlockCt)
+	file, _ := d.Create(tFilename)
+	d.Create(tOtherFilename)
+	if _, ok := d.Remove(tFilename).(FileAlreadyInUseError); !ok {
+		t.Error("Expected FileAlreadyInUseError removing open file")
+	}
+	file.Close()
+	if err := d.Remove(tFilename); err != nil {
+		t.Error(err)
+	}
+	if _, ok := d.Remove(tFilename).(FileNotFoundError); !ok {
+		t.Error("Expected FileNotFoundError removing missing file")
+	}
+	// freed block and entry are reused
+	fatBuff, _ := d.readFat()
+	blockInd, _ := d.initFatChain(fatBuff)
+	if blockInd != 0 {
+		t.Errorf("Expected freed block 0 to be reallocated, Got %v", blockInd)
+	}
+	rootBuff, _ := d.readRootDir()
+	if _, err := d.initRootEntry(rootBuff, tOtherFilename, blockInd); err == nil {
+		t.Error("Expected FileAlreadyExistsError for name after freed entry")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_RemoveAll(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilenames := []string{"a.txt", "b.txt", "c.txt"}
+	d, _ := New(tDiskFilename, tBlockCt)
+	for _, name := range tFilenames {
+		file, _ := d.Create(name)
+		file.Close()
+	}
+	// Test
+	n, err := d.RemoveAll([]string{"a.txt", "missing.txt", "c.txt"})
+	if n != 2 {
+		t.Errorf("Expected 2 files removed, Got %v", n)
+	}
+	multi, ok := err.(MultiError)
+	if !ok || len(multi.Errors()) != 1 {
+		t.Fatalf("Expected MultiError with 1 error, Got %v", err)
+	}
+	if _, ok := multi.Errors()[0].(FileNotFoundError); !ok {
+		t.Errorf("Expected FileNotFoundError, Got %v", multi.Errors()[0])
+	}
+	for name, want := range map[string]bool{"a.txt": false, "b.txt": true, "c.txt": false} {
+		_, err := d.Open(name)
+		if got := err == nil; got != want {
+			t.Errorf("Expected %s present %v, Got %v", name, want, got)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_WithByteOrder(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	orders := map[string]binary.ByteOrder{
+		"littleEndian": binary.LittleEndian,
+		"bigEndian":    binary.BigEndian,
+	}
+	for name, order := range orders {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			d, _ := New(tDiskFilename, tBlockCt, WithByteOrder(order))
+			file, _ := d.Create(tFilename)
+			file.Close()
+			d.Close()
+			// Test
+			raw := make([]byte, SbDataBlockCtSize)
+			fd, _ := os.Open(tDiskFilename)
+			fd.ReadAt(raw, SbDataBlockCtOffset)
+			fd.Close()
+			if got := int(order.Uint16(raw)); got != tBlockCt {
+				t.Errorf("Expected stored data block count %v, Got %v", tBlockCt, got)
+			}
+			d, err := MountValidated(tDiskFilename)
+			if err != nil {
+				t.Error(err)
+			}
+			if d.byteOrder() != order {
+				t.Errorf("Expected byte order %v, Got %v", order, d.byteOrder())
+			}
+			if d.dataBlockCt != tBlockCt {
+				t.Errorf("Expected data block count %v, Got %v", tBlockCt, d.dataBlockCt)
+			}
+			if _, err = d.Open(tFilename); err != nil {
+				t.Error(err)
+			}
+			// Teardown
+			d.Close()
+			os.Remove(tDiskFilename)
+		})
+	}
+}
+
+func TestDisk_WithoutOpenCheck(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt, WithoutOpenCheck())
+	d.WriteFile(tFilename, []byte("shared"))
+	// Test
+	first, err := d.Open(tFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.Open(tFilename)
+	if err != nil {
+		t.Errorf("Expected second Open to succeed, Got %v", err)
+	}
+	buff := make([]byte, 6)
+	if n, _ := second.Read(buff); n != 6 || string(buff) != "shared" {
+		t.Errorf("Expected second handle to read %q, Got %q", "shared", buff[:n])
+	}
+	first.Close()
+	second.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+// Device counting the syncs made on it
+type syncCountingDevice struct {
+	BlockDevice
+	syncs *int
+}
+
+func (s syncCountingDevice) Sync() error {
+	*s.syncs++
+	return s.BlockDevice.Sync()
+}
+
+func TestDisk_Rescan(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	larger, _ := New("larger.disk", 2*tBlockCt)
+	larger.Close()
+	// Test
+	// replace the image underneath the mounted disk
+	image, _ := ioutil.ReadFile("larger.disk")
+	ioutil.WriteFile(tDiskFilename, image, 0666)
+	if err := d.Rescan(true); err != nil {
+		t.Error(err)
+	}
+	if d.dataBlockCt != 2*tBlockCt {
+		t.Errorf("Expected %v data blocks after rescan, Got %v", 2*tBlockCt, d.dataBlockCt)
+	}
+	if free, _ := d.FreeBlocks(); free != 2*tBlockCt {
+		t.Errorf("Expected %v free blocks after rescan, Got %v", 2*tBlockCt, free)
+	}
+	// grown by another tool without updating the superblock
+	os.Truncate(tDiskFilename, int64(len(image)+BlockSize))
+	if err := d.Rescan(true); err == nil {
+		t.Errorf("Expected DiskSizeMismatchError, Got nil")
+	} else if _, ok := err.(DiskSizeMismatchError); !ok {
+		t.Errorf("Expected DiskSizeMismatchError, Got %v", err)
+	}
+	if err := d.Rescan(false); err != nil {
+		t.Errorf("Expected no size check, Got %v", err)
+	}
+	f, _ := d.Create("test.txt")
+	if err := d.Rescan(false); err == nil {
+		t.Errorf("Expected FileAlreadyInUseError with a file open, Got nil")
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+	os.Remove("larger.disk")
+}
+
+func TestDisk_WithoutSync(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt, WithJournal(), WithoutSync())
+	syncs := 0
+	d.fd = syncCountingDevice{d.fd, &syncs}
+	// Test
+	d.WriteFile(tFilename, []byte("bulk"))
+	if syncs != 0 {
+		t.Errorf("Expected no syncs while writing, Got %v", syncs)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	if syncs != 1 {
+		t.Errorf("Expected a single sync on Close, Got %v", syncs)
+	}
+	d, _ = Mount(tDiskFilename)
+	if data, _ := d.ReadFile(tFilename); string(data) != "bulk" {
+		t.Errorf("Expected %q after remount, Got %q", "bulk", data)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Geometry(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.Close()
+	d, _ = Mount(tDiskFilename)
+	// Test
+	for name, tc := range map[string][2]int{
+		"BlockCount":     {d.BlockCount(), 2 + 1 + tBlockCt + 2},
+		"RootDirIndex":   {d.RootDirIndex(), 2},
+		"DataStartIndex": {d.DataStartIndex(), 3},
+		"DataBlockCount": {d.DataBlockCount(), tBlockCt},
+		"FatBlockCount":  {d.FatBlockCount(), 1},
+	} {
+		if tc[0] != tc[1] {
+			t.Errorf("Expected %s %v, Got %v", name, tc[1], tc[0])
+		}
+	}
+	if d.Signature() != SbSig {
+		t.Errorf("Expected signature %s, Got %s", SbSig, d.Signature())
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_WithSparseImage(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, err := New(tDiskFilename, tBlockCt, WithSparseImage(), WithJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Test
+	fStat, _ := d.fd.Stat()
+	if expected := int64(d.blockCt * BlockSize); fStat.Size() != expected {
+		t.Errorf("Expected image size %v, Got %v", expected, fStat.Size())
+	}
+	d.WriteFile("test.txt", []byte("data"))
+	d.Close()
+	d, err = MountValidated(tDiskFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.ReadFile("test.txt"); string(got) != "data" {
+		t.Errorf("Expected data, Got %q", got)
+	}
+	// data blocks never written read as zeros
+	for _, index := range []int{d.dataStartInd + 10, d.dataStartInd + tBlockCt - 1} {
+		if block, _ := d.ReadBlock(index); !bytes.Equal(block, make([]byte, BlockSize)) {
+			t.Errorf("Expected block %v to read as zeros", index)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ValidateName(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	for name, reason := range map[string]string{
+		"test.txt":           "",
+		"sixteen-byte.txt":   "",
+		"":                   "empty",
+		"a/b":                "contains a slash",
+		"nul\x00":            `contains control character '\x00'`,
+		"seventeen-byte.txt": "longer than 16 bytes",
+	} {
+		err := d.ValidateName(name)
+		if reason == "" {
+			if err != nil {
+				t.Errorf("Expected %q valid, Got %v", name, err)
+			}
+			continue
+		}
+		if e, ok := err.(InvalidFilenameError); !ok || e.reason != reason {
+			t.Errorf("Expected %q rejected as %s, Got %v", name, reason, err)
+		}
+		if _, err = d.Create(name); err == nil {
+			t.Errorf("Expected Create to reject %q, Got nil", name)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_WithCaseInsensitiveNames(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	t.Run("nameError", func(t *testing.T) {
+		for name, valid := range map[string]bool{
+			"test.txt": true,
+			"Test.txt": true,
+			"":         false,
+			"a/b":      false,
+			"dir/":     false,
+			"tab\t":    false,
+			"del\x7f":  false,
+		} {
+			if (nameError(name) == "") != valid {
+				t.Errorf("Expected nameError(%q) to report valid %v, Got %v", name, valid, !valid)
+			}
+		}
+	})
+	d, _ := New(tDiskFilename, tBlockCt, WithCaseInsensitiveNames())
+	d.WriteFile("Test.TXT", []byte("folded"))
+	d.Close()
+	// Test
+	d, err := MountValidated(tDiskFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.ReadFile("tEST.txt")
+	if err != nil || string(got) != "folded" {
+		t.Errorf("Expected folded lookup to read %q, Got %q, %v", "folded", got, err)
+	}
+	entries, _ := d.Entries()
+	if len(entries) != 1 || entries[0].Name != "test.txt" {
+		t.Errorf("Expected single entry stored as test.txt, Got %v", entries)
+	}
+	if _, err = d.Create("TEST.txt"); err == nil {
+		t.Errorf("Expected FileAlreadyExistsError for differently cased name, Got nil")
+	}
+	file, _ := d.Open("test.txt")
+	if _, err = d.Open("TEST.TXT"); err == nil {
+		t.Errorf("Expected FileAlreadyInUseError for differently cased name, Got nil")
+	}
+	file.Close()
+	if _, err = d.Create("a/b"); err == nil {
+		t.Errorf("Expected InvalidFilenameError, Got nil")
+	}
+	d.Close()
+	// the default policy keeps names distinct
+	d, _ = New(tDiskFilename, tBlockCt)
+	d.WriteFile("Test.txt", []byte("upper"))
+	if _, err = d.Open("test.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError on case-sensitive disk, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_SetQuota(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tQuota := "test.txt", 100
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	// Test
+	if err := d.SetQuota(tFilename, tQuota); err != nil {
+		t.Error(err)
+	}
+	if _, err := f.Write(make([]byte, tQuota)); err != nil {
+		t.Error(err)
+	}
+	n, err := f.Write([]byte{1})
+	if _, ok := err.(QuotaExceededError); !ok {
+		t.Errorf("Expected QuotaExceededError, Got %v", err)
+	}
+	if n != 0 || f.size != tQuota {
+		t.Errorf("Expected nothing written past quota, Got %v bytes and size %v", n, f.size)
+	}
+	// rewriting within the quota is still allowed
+	if _, err = f.WriteAt([]byte{1}, 0); err != nil {
+		t.Error(err)
+	}
+	// removing the quota lifts the limit
+	d.SetQuota(tFilename, 0)
+	if _, err = f.Write([]byte{1}); err != nil {
+		t.Error(err)
+	}
+	if _, ok := d.SetQuota("missing.txt", tQuota).(FileNotFoundError); !ok {
+		t.Error("Expected FileNotFoundError for missing file")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_RecomputeFree(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(make([]byte, 2*BlockSize+1))
+	// Test
+	free, err := d.FreeBlocks()
+	if err != nil {
+		t.Error(err)
+	}
+	if free != tBlockCt-3 {
+		t.Errorf("Expected %v free blocks, Got %v", tBlockCt-3, free)
+	}
+	// edit the FAT behind the cache's back
+	fatBuff, _ := d.readFat()
+	d.byteOrder().PutUint16(fatBuff[(tBlockCt-1)*FatEntrySize:], FatEoc)
+	d.writeMeta(metaWrite{1, fatBuff})
+	if free, _ = d.FreeBlocks(); free != tBlockCt-3 {
+		t.Errorf("Expected stale cached count %v, Got %v", tBlockCt-3, free)
+	}
+	free, err = d.RecomputeFree()
+	if err != nil {
+		t.Error(err)
+	}
+	if free != tBlockCt-4 {
+		t.Errorf("Expected %v free blocks after rescan, Got %v", tBlockCt-4, free)
+	}
+	// removing the file returns its blocks to the cached count
+	f.Close()
+	d.Remove(tFilename)
+	if free, _ = d.FreeBlocks(); free != tBlockCt-1 {
+		t.Errorf("Expected %v free blocks after remove, Got %v", tBlockCt-1, free)
+	}
+	// a mounted disk counts from the FAT
+	d.Close()
+	d, _ = Mount(tDiskFilename)
+	if free, _ = d.FreeBlocks(); free != tBlockCt-1 {
+		t.Errorf("Expected %v free blocks after mount, Got %v", tBlockCt-1, free)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_WriteFile(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if err := d.WriteFile(tFilename, bytes.Repeat([]byte{1}, 2*BlockSize)); err != nil {
+		t.Error(err)
+	}
+	// rewriting replaces the contents rather than overlaying them
+	if err := d.WriteFile(tFilename, []byte("short")); err != nil {
+		t.Error(err)
+	}
+	if d.checkIsOpen(tFilename) {
+		t.Error("Expected file closed after WriteFile")
+	}
+	n, _ := d.BlockCountOf(tFilename)
+	if n != 1 {
+		t.Errorf("Expected 1 block after rewrite, Got %v", n)
+	}
+	f, _ := d.Create("open.txt")
+	if _, ok := d.WriteFile("open.txt", nil).(FileAlreadyInUseError); !ok {
+		t.Error("Expected FileAlreadyInUseError writing open file")
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ReadFile(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tData := "test.txt", []byte("hello, disk")
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	d.WriteFile("empty.txt", nil)
+	// Test
+	got, err := d.ReadFile(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(got, tData) {
+		t.Errorf("Expected %q, Got %q", tData, got)
+	}
+	if d.checkIsOpen(tFilename) {
+		t.Error("Expected file closed after ReadFile")
+	}
+	if got, err = d.ReadFile("empty.txt"); err != nil || len(got) != 0 {
+		t.Errorf("Expected empty contents, Got %q and %v", got, err)
+	}
+	if _, err = d.ReadFile("missing.txt"); err == nil {
+		t.Error("Expected error reading missing file")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ReadBlock(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	block, err := d.ReadBlock(0)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(block[:SbSigSize]) != SbSig {
+		t.Errorf("Expected superblock signature %s, Got %s", SbSig, block[:SbSigSize])
+	}
+	for _, index := range []int{-1, d.blockCt} {
+		_, err := d.ReadBlock(index)
+		if _, ok := err.(BlockOutOfRangeError); !ok {
+			t.Errorf("Expected BlockOutOfRangeError for index %v", index)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_WriteBlock(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	tIndex := d.dataStartInd + 3
+	tData := bytes.Repeat([]byte{0x5A}, BlockSize)
+	// Test
+	if err := d.WriteBlock(tIndex, tData); err != nil {
+		t.Error(err)
+	}
+	if block, _ := d.ReadBlock(tIndex); !bytes.Equal(block, tData) {
+		t.Error("Expected block contents to round trip")
+	}
+	if err := d.WriteBlock(tIndex, tData[:10]); err == nil {
+		t.Error("Expected error writing a partial block")
+	}
+	if _, ok := d.WriteBlock(d.blockCt, tData).(BlockOutOfRangeError); !ok {
+		t.Error("Expected BlockOutOfRangeError past the last block")
+	}
+	// a raw FAT write invalidates the cached free count
+	fatBlock := make([]byte, BlockSize)
+	d.byteOrder().PutUint16(fatBlock, FatEoc)
+	d.WriteBlock(1, fatBlock)
+	if free, _ := d.FreeBlocks(); free != tBlockCt-1 {
+		t.Errorf("Expected %v free blocks, Got %v", tBlockCt-1, free)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_SetSuperblockField(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// corrupt the data block count, keeping the checksum valid
+	if err := d.SetDataBlockCount(tBlockCt + 5); err != nil {
+		t.Error(err)
+	}
+	if d.dataBlockCt != tBlockCt+5 {
+		t.Errorf("Expected in-memory data block count %v, Got %v", tBlockCt+5, d.dataBlockCt)
+	}
+	d.Close()
+	if _, err := MountValidated(tDiskFilename); err == nil {
+		t.Fatal("Expected corrupted superblock to fail validation, Got nil")
+	}
+	// Test
+	d, _ = Mount(tDiskFilename)
+	if err := d.SetDataBlockCount(tBlockCt); err != nil {
+		t.Error(err)
+	}
+	d.Close()
+	d, err := MountValidated(tDiskFilename)
+	if err != nil {
+		t.Errorf("Expected repaired superblock to validate, Got %v", err)
+	}
+	if _, ok := d.SetSuperblockField(SbCrcOffset, []byte{0}).(CustomError); !ok {
+		t.Errorf("Expected error writing over the checksum")
+	}
+	if _, ok := d.SetSuperblockField(-1, []byte{0}).(CustomError); !ok {
+		t.Errorf("Expected error for negative offset")
+	}
+	if err = d.SetRootDirInd(d.rootDirInd); err != nil {
+		t.Error(err)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func BenchmarkDisk_Open(b *testing.B) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", BlockSize/RootEntrySize
+	d, _ := New(tDiskFilename, tBlockCt)
+	var last string
+	for i := 0; i < BlockSize/RootEntrySize; i++ {
+		last = fmt.Sprintf("file%04d.txt", i)
+		d.WriteFile(last, nil)
+	}
+	b.ResetTimer()
+	// Test
+	// the last entry is the worst case for the directory scan
+	for i := 0; i < b.N; i++ {
+		f, err := d.Open(last)
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+	// Teardown
+	b.StopTimer()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func BenchmarkDisk_WithoutSync(b *testing.B) {
+	tDiskFilename, tBlockCt := "test.disk", 256
+	tData := make([]byte, BlockSize)
+	for name, opts := range map[string][]DiskOption{
+		"synced":   {WithJournal()},
+		"unsynced": {WithJournal(), WithoutSync()},
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// Setup
+				d, _ := New(tDiskFilename, tBlockCt, opts...)
+				// Test
+				for j := 0; j < 64; j++ {
+					d.WriteFile(fmt.Sprintf("file%02d.txt", j), tData)
+				}
+				// Teardown
+				d.Close()
+				os.Remove(tDiskFilename)
+			}
+		})
+	}
+}
+
+func BenchmarkDisk_WithSparseImage(b *testing.B) {
+	tDiskFilename, tBlockCt := "test.disk", 16384
+	for name, opts := range map[string][]DiskOption{
+		"zeroed": nil,
+		"sparse": {WithSparseImage()},
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				d, err := New(tDiskFilename, tBlockCt, opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				d.Close()
+				os.Remove(tDiskFilename)
+			}
+		})
+	}
+}
+
+// Creates files on a large disk whose low blocks are taken, so finding a
+// start block means scanning part of the FAT
+func BenchmarkDisk_Create(b *testing.B) {
+	tDiskFilename, tBlockCt := "test.disk", 60000
+	d, _ := New(tDiskFilename, tBlockCt, WithSparseImage(), WithoutSync())
+	d.WriteFile("low.bin", make([]byte, 100*BlockSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := d.Create("test.txt")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		f.Close()
+		d.Remove("test.txt")
+		b.StartTimer()
+	}
+	b.StopTimer()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+// Mounts a fresh disk prepared by setup for each number of free blocks from
+// 0 to spare, filling the rest with another file, and runs op on it. op
+// must fail with a FullDiskError and leave the free count, a recount of
+// it and the filesystem as they were before.
+func assertRollback(t *testing.T, name string, spare int, setup func(d *Disk), op func(d *Disk) error) {
+	tDiskFilename, tBlockCt := "test.disk", 64
+	for free := 0; free <= spare; free++ {
+		d, _ := New(tDiskFilename, tBlockCt)
+		if setup != nil {
+			setup(&d)
+		}
+		// the filler's chain takes every block but the ones left free
+		if fill, _ := d.FreeBlocks(); fill > free {
+			d.WriteFile("filler", make([]byte, (fill-free)*BlockSize))
+		}
+		before, _ := d.FreeBlocks()
+		if before != free {
+			t.Fatalf("%s: Expected %v free blocks before, Got %v", name, free, before)
+		}
+		err := op(&d)
+		if _, ok := err.(FullDiskError); !ok {
+			t.Errorf("%s with %v free: Expected FullDiskError, Got %v", name, free, err)
+		}
+		if after, _ := d.FreeBlocks(); after != before {
+			t.Errorf("%s with %v free: Expected %v free blocks after, Got %v", name, free, before, after)
+		}
+		if recount, _ := d.RecomputeFree(); recount != before {
+			t.Errorf("%s with %v free: Expected a recount of %v free blocks, Got %v", name, free, before, recount)
+		}
+		if problems, _ := d.Check(); len(problems) != 0 {
+			t.Errorf("%s with %v free: Expected no problems, Got %v", name, free, problems)
+		}
+		d.CloseAll()
+		d.Close()
+		os.Remove(tDiskFilename)
+	}
+}
+
+func TestDisk_allocRollback(t *testing.T) {
+	// Setup
+	tData := bytes.Repeat([]byte("x"), 12*BlockSize)
+	withFiles := func(d *Disk) {
+		d.WriteFile("src.txt", tData)
+		d.WriteFile("dst.txt", []byte("head"))
+	}
+	// Test
+	assertRollback(t, "Create", 0, nil, func(d *Disk) error {
+		_, err := d.Create("new.txt")
+		return err
+	})
+	assertRollback(t, "Write", 11, withFiles, func(d *Disk) error {
+		f, _ := d.OpenAppend("dst.txt")
+		defer f.Close()
+		_, err := f.Write(tData)
+		return err
+	})
+	assertRollback(t, "WriteFile", 11, nil, func(d *Disk) error {
+		return d.WriteFile("new.txt", tData)
+	})
+	assertRollback(t, "CopyTo", 11, withFiles, func(d *Disk) error {
+		src, _ := d.Open("src.txt")
+		dst, _ := d.OpenAppend("dst.txt")
+		defer src.Close()
+		defer dst.Close()
+		_, err := src.CopyTo(&dst, len(tData))
+		if src.offset != 0 || dst.offset != 4 {
+			t.Errorf("CopyTo: Expected offsets put back, Got %v and %v", src.offset, dst.offset)
+		}
+		return err
+	})
+	assertRollback(t, "ReadFrom", 11, withFiles, func(d *Disk) error {
+		f, _ := d.OpenAppend("dst.txt")
+		defer f.Close()
+		// hides the reader's WriteTo, which io.Copy would prefer
+		_, err := io.Copy(&f, struct{ io.Reader }{bytes.NewReader(tData)})
+		if f.size != 4 || f.offset != 4 {
+			t.Errorf("ReadFrom: Expected size and offset 4, Got %v and %v", f.size, f.offset)
+		}
+		return err
+	})
+}
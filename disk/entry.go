@@ -0,0 +1,226 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Decoded root directory entry describing one file
+type DirEntry struct {
+	Name       string      // filename
+	Size       int         // size in bytes
+	StartBlock int         // index of the first data block in the file's chain
+	Attr       byte        // attribute flags
+	ModTime    time.Time   // time of last modification, to the second
+	Mode       os.FileMode // permission bits, see File.Chmod
+}
+
+// Decodes every in-use root directory entry, leaving out names reserved
+// by Reserve and not yet committed
+// Returns: (entries in directory order, any error encountered)
+// Scope: exported
+func (d *Disk) Entries() ([]DirEntry, error) {
+	return d.entries(false)
+}
+
+// Decodes the in-use root directory entries for which pred returns true,
+// testing each as it's decoded so only the matches are collected. Reserved
+// names are left out, as with Entries.
+// Returns: (matching entries in directory order, any error encountered)
+// Scope: exported
+func (d *Disk) EntriesWhere(pred func(DirEntry) bool) ([]DirEntry, error) {
+	return d.scanEntries(false, pred)
+}
+
+// Decodes every in-use root directory entry, including pending ones if
+// asked, as checks of the FAT need to see every chain
+// Returns: (entries in directory order, any error encountered)
+// Scope: internal
+func (d *Disk) entries(pending bool) ([]DirEntry, error) {
+	return d.scanEntries(pending, nil)
+}
+
+// Decodes the in-use root directory entries, including pending ones if
+// asked, keeping those pred accepts; a nil pred keeps them all
+// Returns: (entries in directory order, any error encountered)
+// Scope: internal
+func (d *Disk) scanEntries(pending bool, pred func(DirEntry) bool) ([]DirEntry, error) {
+	if d.closed {
+		return nil, DiskClosedError{}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return nil, err
+	}
+	var entries []DirEntry
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		entry := rootBuff[i : i+RootEntrySize]
+		// skip empty entries (i.e. name is null)
+		if entry[0] == 0 {
+			continue
+		}
+		if entry[RootEntryAttrOffset]&AttrPending != 0 && !pending {
+			continue
+		}
+		decoded := d.decodeEntry(entry)
+		if pred != nil && !pred(decoded) {
+			continue
+		}
+		entries = append(entries, decoded)
+	}
+	return entries, nil
+}
+
+// Field that ListSorted orders entries by
+type SortKey int
+
+const (
+	SortByName    SortKey = iota // filename
+	SortBySize                   // size in bytes
+	SortByModTime                // time of last modification
+)
+
+// Lists the entries of Entries ordered by the given field, ascending or
+// descending. Entries equal in that field are always ordered by ascending
+// name, so the order is fully determined.
+// Returns: (sorted entries, any error encountered)
+// Scope: exported
+func (d *Disk) ListSorted(by SortKey, descending bool) ([]DirEntry, error) {
+	entries, err := d.Entries()
+	if err != nil {
+		return nil, err
+	}
+	// compares the sort field only, reporting -1, 0 or 1
+	compare := func(a, b DirEntry) int {
+		switch {
+		case by == SortBySize && a.Size != b.Size:
+			if a.Size < b.Size {
+				return -1
+			}
+			return 1
+		case by == SortByModTime && !a.ModTime.Equal(b.ModTime):
+			if a.ModTime.Before(b.ModTime) {
+				return -1
+			}
+			return 1
+		case by == SortByName:
+			return strings.Compare(a.Name, b.Name)
+		}
+		return 0
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if c := compare(entries[i], entries[j]); c != 0 {
+			return (c < 0) != descending
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Returned by a Walk callback to skip the rest of the directory holding
+// the path it was called with
+var SkipDir = SkipDirError{}
+
+// Calls fn for every file on the disk, in ascending name order, giving its
+// path and entry. Directories aren't supported yet, so this visits the
+// files of the root directory, each path being the bare filename, and a
+// SkipDir from fn ends the walk without error. Any other error from fn
+// ends the walk and is returned.
+// Returns: first error from fn other than SkipDir, or any error encountered
+// Scope: exported
+func (d *Disk) Walk(fn func(path string, info DirEntry) error) error {
+	entries, err := d.ListSorted(SortByName, false)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = fn(entry.Name, entry); err == SkipDir {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decodes the fields of a raw root directory entry
+// Scope: internal
+func (d *Disk) decodeEntry(entry []byte) DirEntry {
+	nameBuilder := strings.Builder{}
+	nameBuilder.Write(entry[:RootEntryFilenameSize])
+	size := entry[RootEntryFilenameSize : RootEntryFilenameSize+RootEntrySizeFieldSize]
+	modTime := entry[RootEntryModTimeOffset : RootEntryModTimeOffset+RootEntryModTimeSize]
+	return DirEntry{
+		Name:       strings.Trim(nameBuilder.String(), "\x00"),
+		Size:       int(d.byteOrder().Uint32(size)),
+		StartBlock: d.entryStartBlock(entry),
+		Attr:       entry[RootEntryAttrOffset],
+		ModTime:    time.Unix(int64(d.byteOrder().Uint32(modTime)), 0),
+		Mode:       decodeMode(entry[RootEntryAttrOffset], entry[RootEntryModeOffset]),
+	}
+}
+
+// Stamps a raw root directory entry with the current time as its
+// modification time
+// Scope: internal
+func (d *Disk) touchEntry(entry []byte) {
+	modTime := entry[RootEntryModTimeOffset : RootEntryModTimeOffset+RootEntryModTimeSize]
+	d.byteOrder().PutUint32(modTime, uint32(time.Now().Unix()))
+}
+
+// Shifts every in-use root directory entry forward over the gaps left by
+// removed files, keeping their order. Each entry's size record and IV slot
+// in the size table move with it, in the same metadata update. Open
+// handles refer to their entry by position, so compaction is refused while
+// any file is open, and on disks mounted WithoutOpenCheck, where closing
+// one of several handles stops tracking the others.
+// Scope: exported
+func (d *Disk) DirCompact() error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.noOpenCheck {
+		return CustomError{"Compaction needs open handles tracked"}
+	}
+	for name, open := range d.open {
+		if open {
+			return FileAlreadyInUseError{name}
+		}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	table, err := d.readSizeTable()
+	if err != nil {
+		return err
+	}
+	compacted := make([]byte, len(rootBuff))
+	var moved []byte
+	if table != nil {
+		moved = clearSlots(table, len(rootBuff)/RootEntrySize)
+	}
+	next := 0
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		// skip empty entries (i.e. name is null)
+		if rootBuff[i] == 0 {
+			continue
+		}
+		copy(compacted[next:next+RootEntrySize], rootBuff[i:i+RootEntrySize])
+		if table != nil {
+			moveSlot(moved, table, i/RootEntrySize, next/RootEntrySize)
+		}
+		next += RootEntrySize
+	}
+	if bytes.Equal(compacted, rootBuff) {
+		return nil
+	}
+	writes := []metaWrite{{d.rootDirInd, compacted}}
+	if table != nil && !bytes.Equal(moved, table) {
+		writes = append(writes, metaWrite{d.sizeTableInd(), moved})
+	}
+	return d.writeMeta(writes...)
+}
@@ -0,0 +1,260 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisk_Entries(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilenames := []string{"a.txt", "b.txt", "c.txt"}
+	d, _ := New(tDiskFilename, tBlockCt)
+	before := time.Now().Add(-time.Second)
+	for i, name := range tFilenames {
+		d.WriteFile(name, make([]byte, i*BlockSize))
+	}
+	d.Remove("b.txt")
+	// Test
+	t.Run("decodeEntry", func(t *testing.T) {
+		rootBuff, _ := d.readRootDir()
+		i := d.findRootEntry(rootBuff, "c.txt")
+		entry := d.decodeEntry(rootBuff[i : i+RootEntrySize])
+		if entry.Name != "c.txt" {
+			t.Errorf("Expected name c.txt, Got %s", entry.Name)
+		}
+		if entry.Size != 2*BlockSize {
+			t.Errorf("Expected size %v, Got %v", 2*BlockSize, entry.Size)
+		}
+		if entry.ModTime.Before(before) || entry.ModTime.After(time.Now()) {
+			t.Errorf("Expected modification time around now, Got %v", entry.ModTime)
+		}
+	})
+	entries, err := d.Entries()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, Got %v", len(entries))
+	}
+	if entries[0].Name != "a.txt" || entries[1].Name != "c.txt" {
+		t.Errorf("Expected entries a.txt and c.txt, Got %s and %s", entries[0].Name, entries[1].Name)
+	}
+	f, _ := d.Open("c.txt")
+	if entries[1].StartBlock != f.desc {
+		t.Errorf("Expected start block %v, Got %v", f.desc, entries[1].StartBlock)
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_EntriesWhere(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	for i, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		d.WriteFile(name, make([]byte, i*BlockSize))
+	}
+	d.Reserve("e.txt")
+	// Test
+	entries, err := d.EntriesWhere(func(e DirEntry) bool {
+		return e.Size >= 2*BlockSize
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "c.txt" || entries[1].Name != "d.txt" {
+		t.Errorf("Expected entries c.txt and d.txt, Got %v", entries)
+	}
+	// reserved names stay hidden even when pred accepts everything
+	if entries, _ = d.EntriesWhere(func(DirEntry) bool { return true }); len(entries) != 4 {
+		t.Errorf("Expected 4 entries, Got %v", len(entries))
+	}
+	d.Close()
+	if _, err = d.EntriesWhere(func(DirEntry) bool { return true }); err == nil {
+		t.Errorf("Expected DiskClosedError, Got nil")
+	}
+	// Teardown
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ListSorted(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("c.txt", make([]byte, 10))
+	d.WriteFile("a.txt", make([]byte, 10))
+	d.WriteFile("b.txt", make([]byte, 5))
+	rootBuff, _ := d.readRootDir()
+	for name, modTime := range map[string]uint32{"c.txt": 100, "a.txt": 300, "b.txt": 200} {
+		i := d.findRootEntry(rootBuff, name) + RootEntryModTimeOffset
+		d.byteOrder().PutUint32(rootBuff[i:], modTime)
+	}
+	d.WriteBlock(d.rootDirInd, rootBuff)
+	// Test
+	for _, tc := range []struct {
+		by         SortKey
+		descending bool
+		expected   []string
+	}{
+		{SortByName, false, []string{"a.txt", "b.txt", "c.txt"}},
+		{SortByName, true, []string{"c.txt", "b.txt", "a.txt"}},
+		{SortBySize, false, []string{"b.txt", "a.txt", "c.txt"}},
+		// ties stay in ascending name order either way
+		{SortBySize, true, []string{"a.txt", "c.txt", "b.txt"}},
+		{SortByModTime, false, []string{"c.txt", "b.txt", "a.txt"}},
+	} {
+		entries, err := d.ListSorted(tc.by, tc.descending)
+		if err != nil {
+			t.Error(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		if strings.Join(names, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("Expected %v sorting by %v (descending %v), Got %v", tc.expected, tc.by, tc.descending, names)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Walk(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("c.txt", make([]byte, 10))
+	d.WriteFile("a.txt", nil)
+	d.WriteFile("b.txt", nil)
+	tErr := CustomError{"stop"}
+	// Test
+	for _, tc := range []struct {
+		stopAt   string
+		result   error
+		expected []string
+		err      error
+	}{
+		{"", nil, []string{"a.txt", "b.txt", "c.txt"}, nil},
+		{"b.txt", SkipDir, []string{"a.txt", "b.txt"}, nil},
+		{"a.txt", tErr, []string{"a.txt"}, tErr},
+	} {
+		var visited []string
+		err := d.Walk(func(path string, info DirEntry) error {
+			if path != info.Name {
+				t.Errorf("Expected path %s, Got %s", info.Name, path)
+			}
+			visited = append(visited, path)
+			if path == tc.stopAt {
+				return tc.result
+			}
+			return nil
+		})
+		if err != tc.err {
+			t.Errorf("Expected error %v, Got %v", tc.err, err)
+		}
+		if strings.Join(visited, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("Expected %v visited, Got %v", tc.expected, visited)
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_DirCompact(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilenames := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	d, _ := New(tDiskFilename, tBlockCt)
+	for _, name := range tFilenames {
+		d.WriteFile(name, []byte(name))
+	}
+	d.Remove("a.txt")
+	d.Remove("c.txt")
+	// Test
+	f, _ := d.Open("b.txt")
+	if _, ok := d.DirCompact().(FileAlreadyInUseError); !ok {
+		t.Errorf("Expected FileAlreadyInUseError while a file is open")
+	}
+	f.Close()
+	if err := d.DirCompact(); err != nil {
+		t.Error(err)
+	}
+	rootBuff, _ := d.readRootDir()
+	for i, name := range []string{"b.txt", "d.txt"} {
+		if d.findRootEntry(rootBuff, name) != i*RootEntrySize {
+			t.Errorf("Expected %s at entry %v, Got offset %v", name, i, d.findRootEntry(rootBuff, name))
+		}
+	}
+	if rootBuff[2*RootEntrySize] != 0 {
+		t.Errorf("Expected entries past the compacted ones to be empty")
+	}
+	if got, _ := d.ReadFile("d.txt"); string(got) != "d.txt" {
+		t.Errorf("Expected %q, Got %q", "d.txt", got)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+	t.Run("slots", func(t *testing.T) {
+		// Setup
+		tKey := []byte("0123456789abcdef")
+		d, _ := New(tDiskFilename, tBlockCt)
+		d.WriteFile("a.txt", []byte("a"))
+		f, _ := d.CreateEncrypted("b.txt", tKey)
+		f.Write([]byte("secret"))
+		f.Close()
+		d.WriteFile("c.txt", make([]byte, BlockSize+7))
+		d.Remove("a.txt")
+		// Test
+		if err := d.DirCompact(); err != nil {
+			t.Fatal(err)
+		}
+		f, err := d.OpenEncrypted("b.txt", tKey)
+		if err != nil {
+			t.Fatalf("Expected the IV moved with the entry, Got %v", err)
+		}
+		got := make([]byte, 6)
+		f.ReadAt(got, 0)
+		f.Close()
+		if string(got) != "secret" {
+			t.Errorf("Expected %q, Got %q", "secret", got)
+		}
+		// the size record moved too, so a stale size is still recovered exactly
+		rootBuff, _ := d.readRootDir()
+		i := d.findRootEntry(rootBuff, "c.txt")
+		d.byteOrder().PutUint32(rootBuff[i+RootEntryFilenameSize:], 2*BlockSize)
+		d.writeMeta(metaWrite{d.rootDirInd, rootBuff})
+		if err = d.RepairSizes(); err != nil {
+			t.Error(err)
+		}
+		if info, _ := d.Stat("c.txt"); info.Size() != BlockSize+7 {
+			t.Errorf("Expected size %v recovered, Got %v", BlockSize+7, info.Size())
+		}
+		// the emptied slot past the last entry holds nothing
+		table, _ := d.readSizeTable()
+		if _, found, _ := d.loadIv(2); found || !bytes.Equal(table[2*SizeRecordSize:3*SizeRecordSize], make([]byte, SizeRecordSize)) {
+			t.Errorf("Expected the slot past the compacted entries cleared")
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("WithoutOpenCheck", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt, WithoutOpenCheck())
+		// Test
+		if err := d.DirCompact(); err == nil {
+			t.Errorf("Expected an error without open handles tracked, Got nil")
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+}
@@ -1,6 +1,10 @@
 package disk
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type CustomError struct {
 	message string
@@ -12,6 +16,7 @@ type MemberUndefinedError struct {
 
 type InvalidFilenameError struct {
 	filename string
+	reason   string
 }
 
 type FileAlreadyInUseError struct {
@@ -30,6 +35,128 @@ type FileNotOpenError struct {
 	filename string
 }
 
+type InvalidSignatureError struct {
+	sig string
+}
+
+type SuperblockChecksumError struct {
+	stored   uint32
+	computed uint32
+}
+
+type CorruptSuperblockError struct {
+	field string
+}
+
+type TruncatedDiskError struct {
+	size int
+}
+
+type DiskSizeMismatchError struct {
+	expected int64
+	actual   int64
+}
+
+type QuotaExceededError struct {
+	filename string
+	quota    int
+}
+
+type BlockOutOfRangeError struct {
+	index   int
+	blockCt int
+}
+
+type CorruptDataError struct {
+	filename string
+}
+
+type CompressedWriteError struct {
+	filename string
+}
+
+type CorruptEntryError struct {
+	filename   string
+	startBlock int
+}
+
+type CorruptChainError struct {
+	start int
+}
+
+type CorruptJournalError struct {
+	field string
+}
+
+type UnsupportedVersionError struct {
+	version   int
+	supported int
+}
+
+type DiskGeometryError struct {
+	field string
+	value int
+	max   int
+}
+
+type ContentMismatchError struct {
+	filename string
+	offset   int
+}
+
+type FragmentedSpaceError struct {
+	filename string
+	needed   int
+	largest  int
+}
+
+type MetadataFullError struct {
+	size  int
+	limit int
+}
+
+type PermissionDeniedError struct {
+	filename string
+}
+
+type EncryptedFileError struct {
+	filename string
+}
+
+type SnapshotNotFoundError struct {
+	id int
+}
+
+type TooManyFilesError struct {
+	count int
+	free  int
+}
+
+type DiskBusyError struct {
+	filename string
+}
+
+type LockHeldError struct {
+	filename string
+}
+
+type LockNotHeldError struct {
+	filename string
+}
+
+type TimeoutError struct {
+	op      string
+	timeout time.Duration
+}
+
+type MultiError struct {
+	errs []error
+}
+
+type DiskClosedError struct {}
+type SkipDirError struct {}
+type ReadOnlyFilesystemError struct {}
+type JournalFullError struct {}
 type FullDiskError struct {}
 type RootDirFullError struct {}
 
@@ -42,7 +169,7 @@ func (e MemberUndefinedError) Error() string {
 }
 
 func (e InvalidFilenameError) Error() string {
-	return fmt.Sprintf("File Not Found: %s", e.filename)
+	return fmt.Sprintf("Invalid filename %q: %s", e.filename, e.reason)
 }
 
 func (e FileAlreadyInUseError) Error() string {
@@ -61,6 +188,145 @@ func (e FileNotOpenError) Error() string {
 	return fmt.Sprintf("File not open: %s", e.filename)
 }
 
+func (e InvalidSignatureError) Error() string {
+	return fmt.Sprintf("Invalid filesystem signature: %q", e.sig)
+}
+
+func (e SuperblockChecksumError) Error() string {
+	return fmt.Sprintf("Superblock checksum mismatch: stored %08x, computed %08x", e.stored, e.computed)
+}
+
+func (e CorruptSuperblockError) Error() string {
+	return fmt.Sprintf("Corrupt superblock: inconsistent %s", e.field)
+}
+
+func (e TruncatedDiskError) Error() string {
+	return fmt.Sprintf("Disk file truncated: %v bytes is too small to hold a %v byte superblock", e.size, BlockSize)
+}
+
+func (e DiskSizeMismatchError) Error() string {
+	return fmt.Sprintf("Disk size mismatch: superblock declares %v bytes, file has %v", e.expected, e.actual)
+}
+
+func (e QuotaExceededError) Error() string {
+	return fmt.Sprintf("Quota exceeded: %s is limited to %v bytes", e.filename, e.quota)
+}
+
+func (e BlockOutOfRangeError) Error() string {
+	return fmt.Sprintf("Block index %v out of range, disk has %v blocks", e.index, e.blockCt)
+}
+
+func (e CorruptDataError) Error() string {
+	return fmt.Sprintf("Corrupt file data: %s", e.filename)
+}
+
+func (e CompressedWriteError) Error() string {
+	return fmt.Sprintf("Compressed files only support appending writes: %s", e.filename)
+}
+
+func (e CorruptEntryError) Error() string {
+	return fmt.Sprintf("Corrupt root entry for %s: start block %v outside the data region", e.filename, e.startBlock)
+}
+
+func (e CorruptChainError) Error() string {
+	return fmt.Sprintf("Corrupt FAT chain starting at block %v", e.start)
+}
+
+func (e CorruptJournalError) Error() string {
+	return fmt.Sprintf("Corrupt journal: invalid %s", e.field)
+}
+
+func (e UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("Unsupported filesystem version %v, newest supported is %v", e.version, e.supported)
+}
+
+func (e DiskGeometryError) Error() string {
+	return fmt.Sprintf("Disk too large: %s %v exceeds the on-disk limit of %v", e.field, e.value, e.max)
+}
+
+func (e ContentMismatchError) Error() string {
+	return fmt.Sprintf("Contents of %s differ at offset %v", e.filename, e.offset)
+}
+
+// Returns the first offset at which the contents differ
+func (e ContentMismatchError) Offset() int {
+	return e.offset
+}
+
+func (e FragmentedSpaceError) Error() string {
+	return fmt.Sprintf("No contiguous space for %s: needs %v blocks, largest free extent is %v", e.filename, e.needed, e.largest)
+}
+
+func (e MetadataFullError) Error() string {
+	return fmt.Sprintf("Superblock metadata full: %v bytes needed, limit is %v", e.size, e.limit)
+}
+
+func (e PermissionDeniedError) Error() string {
+	return fmt.Sprintf("Permission denied: %s is not writable", e.filename)
+}
+
+func (e EncryptedFileError) Error() string {
+	return fmt.Sprintf("File is encrypted, open it with OpenEncrypted: %s", e.filename)
+}
+
+func (e SnapshotNotFoundError) Error() string {
+	return fmt.Sprintf("Snapshot not found: %v", e.id)
+}
+
+func (e TooManyFilesError) Error() string {
+	return fmt.Sprintf("Too many files: %v new files, root directory has room for %v", e.count, e.free)
+}
+
+func (e DiskBusyError) Error() string {
+	return fmt.Sprintf("Disk is locked by another mount: %s", e.filename)
+}
+
+func (e LockHeldError) Error() string {
+	return fmt.Sprintf("Lock already held: %s", e.filename)
+}
+
+func (e LockNotHeldError) Error() string {
+	return fmt.Sprintf("Lock not held: %s", e.filename)
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("Device %s timed out after %v", e.op, e.timeout)
+}
+
+// Reports that the error is a timeout
+func (e TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e MultiError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Returns the individual errors that were combined
+func (e MultiError) Errors() []error {
+	return e.errs
+}
+
+func (e DiskClosedError) Error() string {
+	return "Disk is closed"
+}
+
+func (e SkipDirError) Error() string {
+	return "Skip this directory"
+}
+
+func (e ReadOnlyFilesystemError) Error() string {
+	return "Filesystem is mounted read-only"
+}
+
+func (e JournalFullError) Error() string {
+	return "Journal full, update spans more blocks than reserved"
+}
+
 func (e FullDiskError) Error() string {
 	return "Disk is full, no data blocks available for writing"
 }
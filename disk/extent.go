@@ -0,0 +1,346 @@
+package disk
+
+import "math/bits"
+
+// Lists the runs of consecutive free data blocks from block first onwards,
+// in disk order. The runs are read off the free map, which is built from
+// fatBuff, the FAT as stored, only if it isn't already up to date.
+// Returns: (start block and length of each run)
+// Scope: internal
+func (d *Disk) freeExtents(fatBuff []byte, first int) [][2]int {
+	if !d.mapValid {
+		d.buildFreeMap(fatBuff)
+	}
+	var extents [][2]int
+	for block := first; block < d.dataBlockCt; {
+		// skip to the next free block, a whole word of used ones at a time
+		word := d.freeMap[block/64] >> uint(block%64)
+		if word == 0 {
+			block = (block/64 + 1) * 64
+			continue
+		}
+		block += bits.TrailingZeros64(word)
+		start := block
+		// bits past the last data block are never set, so runs stop there
+		for {
+			n := bits.TrailingZeros64(^(d.freeMap[block/64] >> uint(block%64)))
+			block += n
+			if n == 0 || block%64 != 0 || block >= d.dataBlockCt {
+				break
+			}
+		}
+		extents = append(extents, [2]int{start, block - start})
+	}
+	return extents
+}
+
+// Rebuilds the free map from the FAT buffer
+// Scope: internal
+func (d *Disk) buildFreeMap(fatBuff []byte) {
+	d.freeMap = make([]uint64, (d.dataBlockCt+63)/64)
+	for block := 0; block < d.dataBlockCt; block++ {
+		if d.blockFree(fatBuff, block) {
+			d.freeMap[block/64] |= 1 << uint(block%64)
+		}
+	}
+	d.mapValid = true
+}
+
+// Brings the free map up to date with a write that has reached the disk,
+// going over just the FAT entries it stored, so allocating and freeing
+// never rescans the whole FAT
+// Scope: internal
+func (d *Disk) noteMetaWrite(w metaWrite) {
+	fatStart, fatEnd := BlockSize, (1+d.fatBlockCt)*BlockSize
+	start, end := w.block*BlockSize, w.block*BlockSize+len(w.data)
+	// chains are laid out by the FAT and headed by the root directory
+	if start <= d.rootDirInd*BlockSize && end > fatStart {
+		d.fragValid = false
+	}
+	if !d.mapValid || end <= fatStart || start >= fatEnd {
+		return
+	}
+	// whether snapshots hold a block would have to be checked per entry
+	if len(d.snapshots) > 0 {
+		d.mapValid = false
+		return
+	}
+	if start < fatStart {
+		start = fatStart
+	}
+	if end > fatEnd {
+		end = fatEnd
+	}
+	for pos := start; pos < end; pos += FatEntrySize {
+		block := (pos - fatStart) / FatEntrySize
+		if block >= d.dataBlockCt {
+			break
+		}
+		entry := w.data[pos-w.block*BlockSize : pos-w.block*BlockSize+FatEntrySize]
+		if d.byteOrder().Uint16(entry) == FatEntryUnused {
+			d.freeMap[block/64] |= 1 << uint(block%64)
+		} else {
+			d.freeMap[block/64] &^= 1 << uint(block%64)
+		}
+	}
+}
+
+// Finds the longest run of consecutive free data blocks the allocator can
+// hand out, the earliest if several tie. A full disk reports a length of 0.
+// Returns: (first block of the run, its length in blocks, any error)
+// Scope: exported
+func (d *Disk) LargestFreeExtent() (int, int, error) {
+	if d.closed {
+		return 0, 0, DiskClosedError{}
+	}
+	// an up to date free map answers without reading the FAT
+	var fatBuff []byte
+	if !d.mapValid {
+		var err error
+		if fatBuff, err = d.readFat(); err != nil {
+			return 0, 0, err
+		}
+	}
+	start, length := 0, 0
+	// block 0 only ever heads a new chain, see allocBlock
+	for _, extent := range d.freeExtents(fatBuff, 1) {
+		if extent[1] > length {
+			start, length = extent[0], extent[1]
+		}
+	}
+	return start, length, nil
+}
+
+// Lists the data blocks, in chain order, that writing a new file of
+// totalBytes through Create and Write would allocate as things stand: the
+// start block from the allocation cursor on, found by the same scan Create
+// uses, then the lowest free blocks, as allocBlock hands them out. The
+// scan's FAT write is dropped and the rest runs on a copy of the FAT, so
+// nothing is changed. An empty file still takes its start block. Fails
+// with a FullDiskError if the file wouldn't fit.
+// Returns: (blocks the file would occupy, any error encountered)
+// Scope: exported
+func (d *Disk) AllocationPreview(totalBytes int) ([]int, error) {
+	if d.closed {
+		return nil, DiskClosedError{}
+	}
+	if totalBytes < 0 {
+		return nil, CustomError{"Negative size"}
+	}
+	start, _, err := d.initFatChainNear(0)
+	if err != nil {
+		return nil, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return nil, err
+	}
+	d.byteOrder().PutUint16(fatBuff[start*FatEntrySize:(start+1)*FatEntrySize], FatEoc)
+	blocks, _, err := d.resizeChain(fatBuff, []int{start}, blocksFor(totalBytes))
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// Scores how fragmented file data is, from 0 for every chain laid out in
+// consecutive blocks to 1 for no chain having any two neighbouring blocks
+// next to each other on disk. The score is the number of jumps, links from
+// a block to any block but the one right after it, divided by the number
+// of links in all chains; a chain of n blocks has n-1 links. With no links
+// at all the score is 0. Chains that loop or run out of the data region are
+// scored as far as they can be followed.
+// Returns: (fragmentation score, any error encountered)
+// Scope: exported
+func (d *Disk) Fragmentation() (float64, error) {
+	entries, err := d.entries(true)
+	if err != nil {
+		return 0, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	links, jumps := 0, 0
+	for _, entry := range entries {
+		blocks, _ := d.walkChain(fatBuff, entry.StartBlock)
+		for i := 1; i < len(blocks); i++ {
+			links++
+			if blocks[i] != blocks[i-1]+1 {
+				jumps++
+			}
+		}
+	}
+	score := 0.0
+	if links > 0 {
+		score = float64(jumps) / float64(links)
+	}
+	d.frag, d.fragValid = score, true
+	return score, nil
+}
+
+// Grows the file's FAT chain to hold size bytes without changing the file
+// size, so later writes up to size need no allocation. The new blocks are
+// taken from a single free extent where one is long enough. Otherwise the
+// call fails with a FragmentedSpaceError if requireContiguous is set, or
+// falls back to allocating wherever blocks are free. Preallocated blocks
+// are released again by any Truncate that shrinks the file.
+// Scope: exported
+func (f *File) Preallocate(size int, requireContiguous bool) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if f.attr&AttrCompressed != 0 {
+		return CompressedWriteError{f.name}
+	}
+	if err := f.checkQuota(size); err != nil {
+		return err
+	}
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return err
+	}
+	extra := blocksFor(size) - len(blocks)
+	if extra <= 0 {
+		return nil
+	}
+	largest := 0
+	for _, extent := range d.freeExtents(fatBuff, 1) {
+		if extent[1] < extra {
+			if extent[1] > largest {
+				largest = extent[1]
+			}
+			continue
+		}
+		// link the extent in, in order, after the current last block
+		for block := extent[0]; block < extent[0]+extra; block++ {
+			last := blocks[len(blocks)-1]
+			d.byteOrder().PutUint16(fatBuff[last*FatEntrySize:(last+1)*FatEntrySize], uint16(block))
+			blocks = append(blocks, block)
+		}
+		last := blocks[len(blocks)-1]
+		d.byteOrder().PutUint16(fatBuff[last*FatEntrySize:(last+1)*FatEntrySize], FatEoc)
+		return f.storePrealloc(fatBuff, blocks, extra)
+	}
+	if requireContiguous {
+		return FragmentedSpaceError{f.name, extra, largest}
+	}
+	if blocks, _, err = d.resizeChain(fatBuff, blocks, len(blocks)+extra); err != nil {
+		return err
+	}
+	return f.storePrealloc(fatBuff, blocks, extra)
+}
+
+// Stores a chain grown by count blocks
+// Scope: internal
+func (f *File) storePrealloc(fatBuff []byte, blocks []int, count int) error {
+	f.cursor = nil
+	if err := f.disk.writeMeta(metaWrite{1, fatBuff}); err != nil {
+		return err
+	}
+	f.disk.adjustFree(-count)
+	return nil
+}
+
+// Moves the data of the file with given filename into a single run of
+// consecutive free blocks and frees its old chain, keeping contents and
+// size, to defragment one file without touching the rest of the disk. The
+// whole chain is moved, preallocated blocks included. A file already laid
+// out contiguously is left alone, and one no free run is long enough for
+// fails with a FragmentedSpaceError. The file must not be open. The new
+// chain is stored and filled before the root entry is pointed at it, and
+// the old one freed only after that, so a failure or crash part way
+// leaves the file readable, at worst with the new chain leaked.
+// Scope: exported
+func (d *Disk) RewriteFile(filename string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	filename = d.normName(filename)
+	if d.checkIsOpen(filename) {
+		return FileAlreadyInUseError{filename}
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	i := d.findRootEntry(rootBuff, filename)
+	if i < 0 || rootBuff[i+RootEntryAttrOffset]&AttrPending != 0 {
+		return FileNotFoundError{filename}
+	}
+	entry := rootBuff[i : i+RootEntrySize]
+	decoded := d.decodeEntry(entry)
+	blocks, err := d.chainBlocks(fatBuff, decoded.StartBlock)
+	if err != nil {
+		return err
+	}
+	contiguous := true
+	for k := 1; k < len(blocks); k++ {
+		contiguous = contiguous && blocks[k] == blocks[k-1]+1
+	}
+	if contiguous {
+		return nil
+	}
+	start, largest := -1, 0
+	for _, extent := range d.freeExtents(fatBuff, 1) {
+		if extent[1] >= len(blocks) {
+			start = extent[0]
+			break
+		}
+		if extent[1] > largest {
+			largest = extent[1]
+		}
+	}
+	if start < 0 {
+		return FragmentedSpaceError{filename, len(blocks), largest}
+	}
+	data := make([]byte, BlockSize)
+	moved := make([]int, len(blocks))
+	for k, block := range blocks {
+		moved[k] = start + k
+		if _, err = d.fd.ReadAt(data, int64((d.dataStartInd+block)*BlockSize)); err != nil {
+			return err
+		}
+		if _, err = d.fd.WriteAt(data, int64((d.dataStartInd+moved[k])*BlockSize)); err != nil {
+			return err
+		}
+	}
+	// size records are checksummed with the start block
+	file := File{name: filename, disk: d, desc: start, entry: i / RootEntrySize, attr: decoded.Attr}
+	if err = file.storeSizeRecord(decoded.Size); err != nil {
+		return err
+	}
+	for k, block := range moved {
+		next := uint16(FatEoc)
+		if k+1 < len(moved) {
+			next = uint16(moved[k+1])
+		}
+		d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize], next)
+	}
+	if err = d.writeMeta(metaWrite{1, fatBuff}); err != nil {
+		return err
+	}
+	d.adjustFree(-len(moved))
+	dtBlkOffset := RootEntryFilenameSize + RootEntrySizeFieldSize
+	d.byteOrder().PutUint16(entry[dtBlkOffset:dtBlkOffset+RootEntryStartBlockSize], uint16(start))
+	if err = d.writeMeta(metaWrite{d.rootDirInd, rootBuff}); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize], FatEntryUnused)
+	}
+	if err = d.writeMeta(metaWrite{1, fatBuff}); err != nil {
+		return err
+	}
+	d.adjustFree(len(blocks))
+	return nil
+}
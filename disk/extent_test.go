@@ -0,0 +1,309 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDisk_LargestFreeExtent(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", nil)
+	d.WriteFile("b.txt", nil)
+	d.WriteFile("c.txt", nil)
+	d.Remove("b.txt")
+	// Test
+	start, length, err := d.LargestFreeExtent()
+	if err != nil {
+		t.Error(err)
+	}
+	if start != 3 || length != tBlockCt-3 {
+		t.Errorf("Expected extent at 3 of %v blocks, Got %v of %v", tBlockCt-3, start, length)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_AllocationPreview(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		d.WriteFile(name, nil)
+	}
+	d.Remove("b.txt")
+	d.Remove("d.txt")
+	free, _ := d.FreeBlocks()
+	// Test
+	for _, cursor := range []int{0, 2} {
+		d.SetAllocCursor(cursor)
+		name := fmt.Sprintf("new%v.txt", cursor)
+		preview, err := d.AllocationPreview(3 * BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after, _ := d.FreeBlocks(); after != free {
+			t.Errorf("Expected the preview to allocate nothing, Got %v free blocks of %v", after, free)
+		}
+		d.WriteFile(name, make([]byte, 3*BlockSize))
+		f, _ := d.Open(name)
+		fatBuff, _ := d.readFat()
+		blocks, _ := d.chainBlocks(fatBuff, f.desc)
+		f.Close()
+		if !reflect.DeepEqual(preview, blocks) {
+			t.Errorf("Expected the preview %v to match the chain written, Got %v", preview, blocks)
+		}
+		free -= len(blocks)
+	}
+	// blocks a snapshot holds are passed over by the preview as by Create
+	d.SetAllocCursor(0)
+	id, _ := d.Snapshot()
+	d.Remove("a.txt")
+	preview, _ := d.AllocationPreview(0)
+	f, _ := d.Create("held.txt")
+	if len(preview) != 1 || preview[0] != f.desc {
+		t.Errorf("Expected the preview %v to start at %v", preview, f.desc)
+	}
+	f.Close()
+	d.DropSnapshot(id)
+	if _, err := d.AllocationPreview(tBlockCt * BlockSize); err == nil {
+		t.Errorf("Expected FullDiskError for a file larger than the free space, Got nil")
+	} else if _, ok := err.(FullDiskError); !ok {
+		t.Errorf("Expected FullDiskError for a file larger than the free space, Got %v", err)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Fragmentation(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if score, err := d.Fragmentation(); err != nil || score != 0 {
+		t.Errorf("Expected 0 on an empty disk, Got %v, %v", score, err)
+	}
+	// block 0, then 1 for b.txt
+	a, _ := d.Create("a.txt")
+	a.Write(make([]byte, BlockSize))
+	d.WriteFile("b.txt", nil)
+	if score, _ := d.Fragmentation(); score != 0 {
+		t.Errorf("Expected 0 for contiguous chains, Got %v", score)
+	}
+	// a.txt continues past b.txt, making one jump in its three links
+	a.Write(make([]byte, 3*BlockSize))
+	if score, _ := d.Fragmentation(); score != 1.0/3 {
+		t.Errorf("Expected %v, Got %v", 1.0/3, score)
+	}
+	// Teardown
+	a.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_Preallocate(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("abcdefgh"), 3*BlockSize/8)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", nil)
+	d.WriteFile("b.txt", nil)
+	d.WriteFile("c.txt", nil)
+	d.Remove("b.txt")
+	f, _ := d.Open("a.txt")
+	// Test
+	// the single free block 1 is skipped for the longer extent after c.txt
+	if err := f.Preallocate(4*BlockSize, true); err != nil {
+		t.Fatal(err)
+	}
+	fatBuff, _ := d.readFat()
+	blocks, _ := d.chainBlocks(fatBuff, f.desc)
+	if len(blocks) != 4 || blocks[1] != 3 || blocks[3] != 5 {
+		t.Errorf("Expected chain [0 3 4 5], Got %v", blocks)
+	}
+	if f.size != 0 {
+		t.Errorf("Expected size unchanged at 0, Got %v", f.size)
+	}
+	// writes within the preallocation allocate nothing more
+	f.Write(tData)
+	if free, _ := d.FreeBlocks(); free != tBlockCt-5 {
+		t.Errorf("Expected %v free blocks, Got %v", tBlockCt-6, free)
+	}
+	if buff, _ := d.ReadFile("c.txt"); len(buff) != 0 {
+		t.Errorf("Expected c.txt untouched, Got %v bytes", len(buff))
+	}
+	buff := make([]byte, len(tData))
+	if n, _ := f.ReadAt(buff, 0); n != len(tData) || !bytes.Equal(buff, tData) {
+		t.Errorf("Expected preallocated file to read back its data")
+	}
+	t.Run("fragmented", func(t *testing.T) {
+		// Setup
+		g, _ := d.Open("c.txt")
+		// Test
+		// the free blocks are block 1 and the run from 6 on
+		size := (tBlockCt - 4) * BlockSize
+		if err := g.Preallocate(size, true); err == nil {
+			t.Errorf("Expected FragmentedSpaceError, Got nil")
+		} else if _, ok := err.(FragmentedSpaceError); !ok {
+			t.Errorf("Expected FragmentedSpaceError, Got %v", err)
+		}
+		if err := g.Preallocate(size, false); err != nil {
+			t.Errorf("Expected fragmented fallback to succeed, Got %v", err)
+		}
+		if free, _ := d.RecomputeFree(); free != 0 {
+			t.Errorf("Expected disk full, Got %v free blocks", free)
+		}
+		// Teardown
+		g.Close()
+	})
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_RewriteFile(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("abcdefgh"), 3*BlockSize/8)
+	d, _ := New(tDiskFilename, tBlockCt)
+	// interleave test.txt's blocks with another file's
+	f, _ := d.Create("test.txt")
+	g, _ := d.Create("other.txt")
+	for i := 0; i < 3; i++ {
+		f.Write(tData[i*BlockSize : (i+1)*BlockSize])
+		g.Write(make([]byte, BlockSize))
+	}
+	f.Close()
+	g.Close()
+	free, _ := d.FreeBlocks()
+	// Test
+	if err := d.RewriteFile("test.txt"); err != nil {
+		t.Error(err)
+	}
+	if ok, err := d.VerifyFile("test.txt", tData); !ok {
+		t.Errorf("Expected contents kept, Got %v", err)
+	}
+	f, _ = d.Open("test.txt")
+	fatBuff, _ := d.readFat()
+	blocks, _ := d.chainBlocks(fatBuff, f.desc)
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i] != blocks[i-1]+1 {
+			t.Errorf("Expected a contiguous chain, Got %v", blocks)
+			break
+		}
+	}
+	if _, ok := d.RewriteFile("test.txt").(FileAlreadyInUseError); !ok {
+		t.Errorf("Expected FileAlreadyInUseError while the file is open")
+	}
+	f.Close()
+	if got, _ := d.FreeBlocks(); got != free {
+		t.Errorf("Expected %v free blocks, Got %v", free, got)
+	}
+	// the size record names the new start, so sizes still recover from it
+	if err := d.RepairSizes(); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile("test.txt"); !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes after RepairSizes, Got %v", len(tData), len(got))
+	}
+	if problems, _ := d.Check(); len(problems) != 0 {
+		t.Errorf("Expected no problems, Got %v", problems)
+	}
+	// no run is long enough for other.txt once the rest is taken
+	d.WriteFile("filler.txt", make([]byte, (free-2)*BlockSize))
+	if _, ok := d.RewriteFile("other.txt").(FragmentedSpaceError); !ok {
+		t.Errorf("Expected FragmentedSpaceError without a long enough run")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_freeMap(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 200
+	d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+	d.LargestFreeExtent()
+	// Test
+	for i := 0; i < 20; i++ {
+		d.WriteFile(fmt.Sprintf("file%02d.txt", i), make([]byte, i*BlockSize/3))
+	}
+	for i := 0; i < 20; i += 3 {
+		d.Remove(fmt.Sprintf("file%02d.txt", i))
+	}
+	f, _ := d.Open("file04.txt")
+	f.Preallocate(30*BlockSize, true)
+	f.Truncate(BlockSize)
+	f.Close()
+	if !d.mapValid {
+		t.Fatalf("Expected the free map kept up to date")
+	}
+	fatBuff, _ := d.readFat()
+	kept := d.freeExtents(fatBuff, 0)
+	d.mapValid = false
+	if rebuilt := d.freeExtents(fatBuff, 0); !reflect.DeepEqual(kept, rebuilt) {
+		t.Errorf("Expected extents %v, Got %v", rebuilt, kept)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func BenchmarkFile_Preallocate(b *testing.B) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 16384
+	d, _ := New(tDiskFilename, tBlockCt, WithoutSync())
+	// leave a gap after every other file, so the search has runs to pass
+	for i := 0; i < 100; i++ {
+		d.WriteFile(fmt.Sprintf("file%03d.txt", i), make([]byte, 4*BlockSize))
+	}
+	for i := 0; i < 100; i += 2 {
+		d.Remove(fmt.Sprintf("file%03d.txt", i))
+	}
+	f, _ := d.Create("big.txt")
+	b.ResetTimer()
+	// Test
+	for i := 0; i < b.N; i++ {
+		if err := f.Preallocate(64*BlockSize, true); err != nil {
+			b.Fatal(err)
+		}
+		if err := f.Truncate(0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// Teardown
+	b.StopTimer()
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func BenchmarkDisk_LargestFreeExtent(b *testing.B) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 16384
+	d, _ := New(tDiskFilename, tBlockCt, WithoutSync())
+	for i := 0; i < 100; i++ {
+		d.WriteFile(fmt.Sprintf("file%03d.txt", i), make([]byte, 4*BlockSize))
+	}
+	for i := 0; i < 100; i += 2 {
+		d.Remove(fmt.Sprintf("file%03d.txt", i))
+	}
+	b.ResetTimer()
+	// Test
+	for i := 0; i < b.N; i++ {
+		if _, _, err := d.LargestFreeExtent(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// Teardown
+	b.StopTimer()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
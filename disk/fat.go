@@ -0,0 +1,53 @@
+package disk
+
+// Decodes the FAT for debugging, one value per data block: FatEntryUnused
+// for a free block, FatEoc for the last block of a chain, and otherwise
+// the index of the next block in the chain
+// Returns: (FAT entries in block order, any error encountered)
+// Scope: exported
+func (d *Disk) DumpFAT() ([]uint16, error) {
+	if d.closed {
+		return nil, DiskClosedError{}
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]uint16, d.dataBlockCt)
+	for block := range entries {
+		entries[block] = d.byteOrder().Uint16(fatBuff[block*FatEntrySize : (block+1)*FatEntrySize])
+	}
+	return entries, nil
+}
+
+// Overwrites the FAT with entries laid out as by DumpFAT, one per data
+// block. The values aren't checked, so this can set up any corruption for
+// a test, or hand-repair a chain; Check reports what the result amounts
+// to. No file may be open, since open handles cache their chains.
+// Scope: exported
+func (d *Disk) LoadFAT(entries []uint16) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if len(entries) != d.dataBlockCt {
+		return CustomError{"FAT must have exactly one entry per data block"}
+	}
+	for name, open := range d.open {
+		if open {
+			return FileAlreadyInUseError{name}
+		}
+	}
+	// padding past the last data block's entry stays as it is
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	for block, entry := range entries {
+		d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize], entry)
+	}
+	if err = d.writeMeta(metaWrite{1, fatBuff}); err != nil {
+		return err
+	}
+	d.freeValid = false
+	return nil
+}
@@ -0,0 +1,109 @@
+package disk
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDisk_DumpFAT(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", make([]byte, BlockSize))
+	d.WriteFile("b.txt", nil)
+	// Test
+	entries, err := d.DumpFAT()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(entries) != tBlockCt {
+		t.Errorf("Expected %v entries, Got %v", tBlockCt, len(entries))
+	}
+	// a.txt takes block 0, b.txt block 1
+	for block, expected := range []uint16{FatEoc, FatEoc, FatEntryUnused} {
+		if entries[block] != expected {
+			t.Errorf("Expected entry %v to be %04x, Got %04x", block, expected, entries[block])
+		}
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_LoadFAT(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", nil)
+	entries, _ := d.DumpFAT()
+	// Test
+	if err := d.LoadFAT(entries[1:]); err == nil {
+		t.Errorf("Expected an error for a short FAT, Got nil")
+	}
+	f, _ := d.Open("a.txt")
+	if _, ok := d.LoadFAT(entries).(FileAlreadyInUseError); !ok {
+		t.Errorf("Expected FileAlreadyInUseError while a file is open")
+	}
+	f.Close()
+	// link a.txt's chain into a free block
+	entries[0] = 2
+	if err := d.LoadFAT(entries); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.DumpFAT(); got[0] != 2 {
+		t.Errorf("Expected entry 0 loaded as 2, Got %04x", got[0])
+	}
+	if problems, _ := d.Check(); len(problems) != 1 || problems[0].Kind != ProblemBadChain {
+		t.Errorf("Expected a bad chain reported, Got %v", problems)
+	}
+	if free, _ := d.FreeBlocks(); free != tBlockCt-1 {
+		t.Errorf("Expected %v free blocks, Got %v", tBlockCt-1, free)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+// The FAT of this disk ends a few entries into its second block, leaving
+// the rest of that block as padding that reads as unused
+func TestDisk_fatPadding(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", BlockSize/FatEntrySize+5
+	d, _ := New(tDiskFilename, tBlockCt, WithSparseImage())
+	// Test
+	if d.FatBlockCount() != 2 {
+		t.Fatalf("Expected 2 FAT blocks, Got %v", d.FatBlockCount())
+	}
+	if free, _ := d.FreeBlocks(); free != tBlockCt {
+		t.Errorf("Expected %v free blocks on a new disk, Got %v", tBlockCt, free)
+	}
+	if _, ok := d.SetAllocCursor(tBlockCt).(BlockOutOfRangeError); !ok {
+		t.Errorf("Expected BlockOutOfRangeError for a cursor in the padding")
+	}
+	// the file takes every real block
+	if err := d.WriteFile("full.bin", make([]byte, tBlockCt*BlockSize)); err != nil {
+		t.Fatal(err)
+	}
+	fatBuff, _ := d.readFat()
+	if d.blockFree(fatBuff, tBlockCt) {
+		t.Errorf("Expected the first padding entry not to count as free")
+	}
+	if free, _ := d.RecomputeFree(); free != 0 {
+		t.Errorf("Expected no free blocks, Got %v", free)
+	}
+	if _, length, _ := d.LargestFreeExtent(); length != 0 {
+		t.Errorf("Expected no free extent, Got one of %v blocks", length)
+	}
+	if _, ok := d.WriteFile("more.txt", []byte("x")).(FullDiskError); !ok {
+		t.Errorf("Expected FullDiskError with only padding left")
+	}
+	if freed, _ := d.ReclaimLeaked(); freed != 0 {
+		t.Errorf("Expected nothing reclaimed, Got %v blocks", freed)
+	}
+	if problems, _ := d.Check(); len(problems) != 0 {
+		t.Errorf("Expected no problems, Got %v", problems)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -1,27 +1,457 @@
 package disk
 
+import (
+	"crypto/cipher"
+	"hash"
+	"io"
+	"math"
+)
+
 type File struct {
-	name   string // filename
-	disk   *Disk  // disk reference
-	desc   int    // file descriptor i.e. the block index on disk
-	offset int    // byte offset from beginning of start block
-	size   int    // size in bytes
+	name   string       // filename
+	disk   *Disk        // disk reference
+	desc   int          // file descriptor i.e. the block index on disk
+	entry  int          // index of the file's root directory entry
+	attr   byte         // attribute flags from the root directory entry
+	offset int          // byte offset from beginning of start block
+	size   int          // size in bytes
+	plain  []byte       // decompressed contents of a compressed file, once loaded
+	dirty  bool         // plain holds changes not yet stored
+	cursor *chainCursor // where the last positioned read ended, if still valid
+	temp   bool         // removed when closed, unless kept
+	resume bool         // offset saved on close, see OpenResume
+	append bool         // writes go to the current end, see OpenAppend
+	perm   byte         // stored permission bits, used if attr has AttrMode
+	cipher cipher.Block // key of an encrypted file, see OpenEncrypted
+	iv     []byte       // initial counter of an encrypted file
+}
+
+// Position within a file's chain, letting reads resume a walk
+type chainCursor struct {
+	index int // position of block within the chain
+	block int // data block index
+}
+
+// Counters describing a write, as reported by WriteWithStats
+type WriteStats struct {
+	Written    int  // bytes written
+	Allocated  int  // data blocks added to the file's chain
+	Contiguous bool // added blocks directly follow the chain's previous end
 }
 
+// Writes data at the current offset, advancing it by the bytes written.
+// Handles from OpenAppend write at the end of the file instead.
+// Returns: (number of bytes written, any error encountered)
 func (f *File) Write(data []byte) (int, error) {
-	return 0, nil
+	if f.append {
+		return f.appendData(data)
+	}
+	n, err := f.WriteAt(data, f.offset)
+	f.offset += n
+	return n, err
+}
+
+// Writes data at the end of the file as stored, which other handles may
+// have moved since this one last looked. Appends are serialized on the
+// disk's append lock, so concurrent appends through several handles each
+// land whole, one after another.
+// Returns: (number of bytes written, any error encountered)
+// Scope: internal
+func (f *File) appendData(data []byte) (int, error) {
+	if err := f.checkDisk(); err != nil {
+		return 0, err
+	}
+	d := f.disk
+	d.appendMu.Lock()
+	defer d.appendMu.Unlock()
+	// a compressed file's size lives in this handle's buffer until stored
+	if f.attr&AttrCompressed == 0 {
+		rootBuff, err := d.readRootDir()
+		if err != nil {
+			return 0, err
+		}
+		entry := d.decodeEntry(rootBuff[f.entry*RootEntrySize : (f.entry+1)*RootEntrySize])
+		f.desc, f.size = entry.StartBlock, entry.Size
+		f.cursor = nil
+	}
+	n, err := f.WriteAt(data, f.size)
+	f.offset = f.size
+	return n, err
 }
 
+// Writes data like Write, also reporting how the file's chain grew. The
+// added blocks are contiguous when each sits right after the one before
+// it on disk, starting from the previous last block; a write adding none
+// counts as contiguous. Compressed files may add blocks only when stored.
+// Returns: (write counters, any error encountered)
+func (f *File) WriteWithStats(data []byte) (WriteStats, error) {
+	if err := f.checkDisk(); err != nil {
+		return WriteStats{}, err
+	}
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return WriteStats{}, err
+	}
+	before, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return WriteStats{}, err
+	}
+	stats := WriteStats{Contiguous: true}
+	stats.Written, err = f.Write(data)
+	// the chain is inspected even after a failed write, which may have
+	// linked in some blocks
+	fatBuff, fatErr := d.readFat()
+	if fatErr != nil {
+		return stats, fatErr
+	}
+	after, _ := d.chainBlocks(fatBuff, f.desc)
+	if len(after) > len(before) {
+		stats.Allocated = len(after) - len(before)
+		for i := len(before); i < len(after); i++ {
+			if after[i] != after[i-1]+1 {
+				stats.Contiguous = false
+			}
+		}
+	}
+	return stats, err
+}
+
+// Writes data at the given byte offset, growing the file and its FAT chain
+// as needed. Writing past the end fills the gap with zeros. Either all of
+// data is written or, if the disk is full or the quota would be exceeded,
+// nothing is. If the data is stored but the root entry then can't be
+// updated, the error is returned along with the bytes written and the
+// handle keeps the old size, as the root entry does; the new size is in
+// the size table, so RepairSizes recovers it.
+// Returns: (number of bytes written, any error encountered)
 func (f *File) WriteAt(data []byte, offset int) (int, error) {
-	return 0, nil
+	if err := f.checkWritable(); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, CustomError{"Negative offset"}
+	}
+	if f.attr&AttrCompressed != 0 {
+		return f.appendCompressed(data, offset)
+	}
+	f.cursor = nil
+	d := f.disk
+	// writing past the end means zero filling from the current end, so
+	// stale bytes of reused blocks never become readable. The fill is
+	// written a block at a time ahead of data, never built up in memory.
+	fill := 0
+	if offset > f.size {
+		fill = offset - f.size
+		offset = f.size
+	}
+	total := fill + len(data)
+	// only the caller's bytes count as written, not the fill ahead of them
+	userBytes := func(n int) int {
+		if n < fill {
+			return 0
+		}
+		return n - fill
+	}
+	end := offset + total
+	if err := f.checkQuota(end); err != nil {
+		return 0, err
+	}
+	// the root entry records sizes in 32 bits
+	if int64(end) > math.MaxUint32 {
+		return 0, FullDiskError{}
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return 0, err
+	}
+	// link in only the blocks needed to hold end bytes, so a write ending
+	// exactly on a block boundary doesn't leave an empty trailing block
+	need := blocksFor(end)
+	if need > len(blocks) {
+		// check the whole write fits before allocating, so a full disk
+		// writes nothing. Unsharing below leaves room for the growth too,
+		// so the check still holds after it.
+		free, err := d.FreeBlocks()
+		if err != nil {
+			return 0, err
+		}
+		// the count takes in block 0, which allocBlock never hands out
+		if d.blockFree(fatBuff, 0) {
+			free--
+		}
+		if need-len(blocks) > free {
+			return 0, FullDiskError{}
+		}
+	}
+	if total > 0 {
+		// blocks a snapshot holds are written through fresh copies
+		reserve := need - len(blocks)
+		if reserve < 0 {
+			reserve = 0
+		}
+		if blocks, err = f.unshareBlocks(fatBuff, blocks, offset/BlockSize, need, reserve); err != nil {
+			return 0, err
+		}
+	}
+	// write data block by block, starting in the block holding offset. New
+	// blocks are linked in one at a time just ahead of their data, so an
+	// interrupted write leaves a valid chain holding what was written.
+	written := 0
+	var sealed, zeros []byte
+	for written < total {
+		pos := offset + written
+		for pos/BlockSize >= len(blocks) {
+			if blocks, err = d.extendChain(fatBuff, blocks); err != nil {
+				return userBytes(written), err
+			}
+		}
+		block, within := blocks[pos/BlockSize], pos%BlockSize
+		n := BlockSize - within
+		if n > total-written {
+			n = total - written
+		}
+		var chunk []byte
+		if written < fill {
+			if n > fill-written {
+				n = fill - written
+			}
+			if zeros == nil {
+				zeros = make([]byte, BlockSize)
+			}
+			chunk = zeros[:n]
+		} else {
+			chunk = data[written-fill : written-fill+n]
+		}
+		// the caller's data is encrypted in a copy
+		if f.cipher != nil {
+			sealed = append(sealed[:0], chunk...)
+			chunk = sealed
+			f.crypt(chunk, pos)
+		}
+		diskOffset := int64((d.dataStartInd+block)*BlockSize + within)
+		if _, err = d.fd.WriteAt(chunk, diskOffset); err != nil {
+			return userBytes(written), err
+		}
+		written += n
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	size := f.size
+	if end > size {
+		size = end
+		// the size record goes down with the data, ahead of the root
+		// entry, so a crash in between leaves the new size recoverable
+		if err = f.storeSizeRecord(size); err != nil {
+			return userBytes(written), err
+		}
+	}
+	if err = f.storeSize(size); err != nil {
+		return userBytes(written), err
+	}
+	f.size = size
+	return userBytes(written), nil
 }
 
+// Reads into buff from the current offset, advancing it by the bytes read.
+// A read that reaches the end of the file returns the remaining bytes with
+// no error, and the following read returns io.EOF.
+// Returns: (number of bytes read, any error encountered)
 func (f *File) Read(buff []byte) (int, error) {
-	return 0, nil
+	if err := f.checkDisk(); err != nil {
+		return 0, err
+	}
+	if f.offset >= f.size && len(buff) > 0 {
+		return 0, io.EOF
+	}
+	n, err := f.ReadAt(buff, f.offset)
+	f.offset += n
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
 }
 
+// Reads into buff from the given byte offset, without moving the current
+// offset. Reads stop at the file size; fewer than len(buff) bytes are
+// returned with io.EOF.
+// Returns: (number of bytes read, any error encountered)
 func (f *File) ReadAt(buff []byte, offset int) (int, error) {
-	return 0, nil
+	if err := f.checkDisk(); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, CustomError{"Negative offset"}
+	}
+	if offset >= f.size {
+		if len(buff) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	if f.attr&AttrCompressed != 0 {
+		return f.readCompressed(buff, offset)
+	}
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	want := len(buff)
+	if want > f.size-offset {
+		want = f.size - offset
+	}
+	// read block by block, starting in the block holding offset
+	read := 0
+	for read < want {
+		pos := offset + read
+		block, err := f.seekBlock(fatBuff, pos/BlockSize)
+		if err != nil {
+			return read, err
+		}
+		within := pos % BlockSize
+		n := BlockSize - within
+		if n > want-read {
+			n = want - read
+		}
+		diskOffset := int64((d.dataStartInd+block)*BlockSize + within)
+		if _, err = d.fd.ReadAt(buff[read:read+n], diskOffset); err != nil {
+			return read, err
+		}
+		f.crypt(buff[read:read+n], pos)
+		read += n
+	}
+	if read < len(buff) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// Reads the raw contents of the file's blocks into buff from the given byte
+// offset, without moving the current offset. Unlike ReadAt, the read is
+// not clamped to the file size but runs to the end of the last allocated
+// block, so it also returns whatever padding follows the data, such as
+// stale bytes from earlier files. Compressed and encrypted files are
+// returned as stored, neither decompressed nor decrypted. This is meant
+// for inspection tools only; normal callers want Read or ReadAt.
+// Returns: (number of bytes read, any error encountered)
+func (f *File) ReadRaw(buff []byte, offset int) (int, error) {
+	if err := f.checkDisk(); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, CustomError{"Negative offset"}
+	}
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return 0, err
+	}
+	limit := len(blocks) * BlockSize
+	if offset >= limit {
+		if len(buff) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	want := len(buff)
+	if want > limit-offset {
+		want = limit - offset
+	}
+	read := 0
+	for read < want {
+		pos := offset + read
+		within := pos % BlockSize
+		n := BlockSize - within
+		if n > want-read {
+			n = want - read
+		}
+		diskOffset := int64((d.dataStartInd+blocks[pos/BlockSize])*BlockSize + within)
+		if _, err = d.fd.ReadAt(buff[read:read+n], diskOffset); err != nil {
+			return read, err
+		}
+		read += n
+	}
+	if read < len(buff) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// Changes the size of the file. Shrinking frees the blocks past the new
+// end; growing zero fills up to the new size, subject to any quota. The
+// current offset is left unchanged.
+func (f *File) Truncate(size int) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if size < 0 {
+		return CustomError{"Negative size"}
+	}
+	if f.attr&AttrCompressed != 0 {
+		return f.truncateCompressed(size)
+	}
+	if size >= f.size {
+		_, err := f.WriteAt(nil, size)
+		return err
+	}
+	f.cursor = nil
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return err
+	}
+	// the start block is kept even for an empty file
+	count := blocksFor(size)
+	if count < 1 {
+		count = 1
+	}
+	_, freed, err := d.resizeChain(fatBuff, blocks, count)
+	if err != nil {
+		return err
+	}
+	// a crash before the chain shrinks leaves the new size recoverable,
+	// the chain merely longer than it needs to be
+	if err = f.storeSizeRecord(size); err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	entry := rootBuff[f.entry*RootEntrySize : (f.entry+1)*RootEntrySize]
+	d.byteOrder().PutUint32(entry[RootEntryFilenameSize:RootEntryFilenameSize+RootEntrySizeFieldSize], uint32(size))
+	d.touchEntry(entry)
+	// chain and size shrink together
+	if err = d.writeMeta(metaWrite{1, fatBuff}, metaWrite{d.rootDirInd, rootBuff}); err != nil {
+		return err
+	}
+	d.adjustFree(freed)
+	f.size = size
+	return nil
+}
+
+// Appends n bytes of zeros to the end of the file, leaving the current
+// offset unchanged. If the disk fills up or the quota would be exceeded,
+// the size is left as it was.
+func (f *File) Grow(n int) error {
+	if n < 0 {
+		return CustomError{"Negative growth"}
+	}
+	return f.Truncate(f.size + n)
 }
 
 func (f *File) Close() error {
@@ -31,9 +461,318 @@ func (f *File) Close() error {
 	if len(f.name) == 0 {
 		return MemberUndefinedError{"name"}
 	}
+	if err := f.checkDisk(); err != nil {
+		return err
+	}
 	if _, ok := f.disk.open[f.name]; !ok {
 		return FileNotOpenError{f.name}
 	}
+	// the handle is released even if storing buffered changes fails
+	err := f.flushCompressed()
+	if saveErr := f.saveOffset(); err == nil {
+		err = saveErr
+	}
 	delete(f.disk.open, f.name)
+	delete(f.disk.closers, f.name)
+	f.disk.pool.release(f)
+	if f.temp {
+		if rmErr := f.disk.Remove(f.name); err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// Keeps a temporary file from CreateTemp, so that closing it no longer
+// removes it
+func (f *File) Keep() {
+	if !f.temp {
+		return
+	}
+	f.temp = false
+	delete(f.disk.closers, f.name)
+}
+
+// Finds the data block at the given position in the file's chain. The walk
+// resumes from where the previous call ended when that isn't past the
+// position, so reads at increasing offsets don't rewalk the chain.
+// Returns: (data block index, any error encountered)
+// Scope: internal
+func (f *File) seekBlock(fatBuff []byte, index int) (int, error) {
+	d := f.disk
+	cursor := chainCursor{0, f.desc}
+	if f.cursor != nil && f.cursor.index <= index {
+		cursor = *f.cursor
+	}
+	for cursor.index < index {
+		next := int(d.byteOrder().Uint16(fatBuff[cursor.block*FatEntrySize : (cursor.block+1)*FatEntrySize]))
+		if next == FatEoc || !d.validBlock(next) {
+			return 0, CorruptChainError{f.desc}
+		}
+		cursor = chainCursor{cursor.index + 1, next}
+	}
+	f.cursor = &cursor
+	return cursor.block, nil
+}
+
+// Counts the data blocks in the file's FAT chain. This reflects the
+// storage actually allocated, which may exceed what the size requires.
+// Returns: (number of blocks in chain, any error encountered)
+func (f *File) BlockCount() (int, error) {
+	if err := f.checkDisk(); err != nil {
+		return 0, err
+	}
+	fatBuff, err := f.disk.readFat()
+	if err != nil {
+		return 0, err
+	}
+	blocks, err := f.disk.chainBlocks(fatBuff, f.desc)
+	return len(blocks), err
+}
+
+// Maps a byte offset to where it is stored, walking the file's chain. The
+// offset may lie anywhere in the allocated chain, past the file size
+// included; for a compressed file it is an offset into the stored,
+// compressed bytes. Data block indices count from the start of the data
+// region, as in the FAT; the block's absolute index is DataStartIndex
+// added to it.
+// Returns: (data block holding the offset, position within that block,
+// any error encountered)
+func (f *File) BlockForOffset(offset int) (int, int, error) {
+	if err := f.checkDisk(); err != nil {
+		return 0, 0, err
+	}
+	if offset < 0 {
+		return 0, 0, CustomError{"Negative offset"}
+	}
+	fatBuff, err := f.disk.readFat()
+	if err != nil {
+		return 0, 0, err
+	}
+	blocks, err := f.disk.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return 0, 0, err
+	}
+	if offset >= len(blocks)*BlockSize {
+		return 0, 0, CustomError{"Offset beyond the allocated chain"}
+	}
+	return blocks[offset/BlockSize], offset % BlockSize, nil
+}
+
+// Streams the file's contents through the hash, one block at a time, up
+// to the file size. The current offset is left unchanged.
+func (f *File) Hash(h hash.Hash) error {
+	return f.streamBlocks(func(data []byte) error {
+		_, err := h.Write(data)
+		return err
+	})
+}
+
+// Passes the file's contents to fn in pieces of size bytes, in order; only
+// the final piece may be shorter. The buffer is reused between calls, so
+// fn must not retain it. An error from fn stops the walk and is returned.
+// The current offset is left unchanged.
+func (f *File) ForEachChunk(size int, fn func(chunk []byte) error) error {
+	if size <= 0 {
+		return CustomError{"Chunk size must be positive"}
+	}
+	chunk := make([]byte, 0, size)
+	err := f.streamBlocks(func(data []byte) error {
+		for len(data) > 0 {
+			n := size - len(chunk)
+			if n > len(data) {
+				n = len(data)
+			}
+			chunk, data = append(chunk, data[:n]...), data[n:]
+			if len(chunk) == size {
+				if err := fn(chunk); err != nil {
+					return err
+				}
+				chunk = chunk[:0]
+			}
+		}
+		return nil
+	})
+	if err != nil || len(chunk) == 0 {
+		return err
+	}
+	return fn(chunk)
+}
+
+// Walks the file's chain once, passing the contents of each data block to
+// fn in order. The final block is cut at the file size, and the buffer is
+// reused between calls, so fn must not retain it. Beyond the FAT and the
+// chain's block list only that one buffer is held, so Hash and
+// ForEachChunk run in bounded memory on files of any size, compressed
+// files aside.
+// Scope: internal
+func (f *File) streamBlocks(fn func(data []byte) error) error {
+	if err := f.checkDisk(); err != nil {
+		return err
+	}
+	if f.attr&AttrCompressed != 0 {
+		return f.streamCompressed(fn)
+	}
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return err
+	}
+	if len(blocks)*BlockSize < f.size {
+		return CorruptChainError{f.desc}
+	}
+	buff := make([]byte, BlockSize)
+	for i, remaining := 0, f.size; remaining > 0; i++ {
+		n := BlockSize
+		if n > remaining {
+			n = remaining
+		}
+		if _, err = d.fd.ReadAt(buff[:n], int64((d.dataStartInd+blocks[i])*BlockSize)); err != nil {
+			return err
+		}
+		f.crypt(buff[:n], i*BlockSize)
+		if err = fn(buff[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// Reads the raw contents of every block in the file's chain
+// Scope: internal
+func (f *File) loadRaw() ([]byte, error) {
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, len(blocks)*BlockSize)
+	for i, block := range blocks {
+		if _, err = d.fd.ReadAt(raw[i*BlockSize:(i+1)*BlockSize], int64((d.dataStartInd+block)*BlockSize)); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// Replaces the raw contents of the file's chain with data, resizing the
+// chain to fit. The recorded size is left for the caller to update.
+// Scope: internal
+func (f *File) storeRaw(data []byte) error {
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return err
+	}
+	count := (len(data) + BlockSize - 1) / BlockSize
+	reserve := count - len(blocks)
+	if reserve < 0 {
+		reserve = 0
+	}
+	if blocks, err = f.unshareBlocks(fatBuff, blocks, 0, count, reserve); err != nil {
+		return err
+	}
+	blocks, delta, err := d.resizeChain(fatBuff, blocks, count)
+	if err != nil {
+		return err
+	}
+	if delta != 0 {
+		if err = d.writeMeta(metaWrite{1, fatBuff}); err != nil {
+			return err
+		}
+		d.adjustFree(delta)
+	}
+	for i := 0; i*BlockSize < len(data); i++ {
+		end := (i + 1) * BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err = d.fd.WriteAt(data[i*BlockSize:end], int64((d.dataStartInd+blocks[i])*BlockSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Puts the file back to size and offset after an operation that may have
+// grown it failed part way, releasing the blocks it added
+// Scope: internal
+func (f *File) rollback(size, offset int) error {
+	f.offset = offset
+	if f.size <= size {
+		return nil
+	}
+	return f.Truncate(size)
+}
+
+// Records size in the file's root directory entry and stamps its
+// modification time
+// Scope: internal
+func (f *File) storeSize(size int) error {
+	d := f.disk
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	entry := rootBuff[f.entry*RootEntrySize : (f.entry+1)*RootEntrySize]
+	d.byteOrder().PutUint32(entry[RootEntryFilenameSize:RootEntryFilenameSize+RootEntrySizeFieldSize], uint32(size))
+	d.touchEntry(entry)
+	return d.writeMeta(metaWrite{d.rootDirInd, rootBuff})
+}
+
+// Fails with a QuotaExceededError if the file's quota forbids growing it
+// to size bytes
+// Scope: internal
+func (f *File) checkQuota(size int) error {
+	rootBuff, err := f.disk.readRootDir()
+	if err != nil {
+		return err
+	}
+	entry := rootBuff[f.entry*RootEntrySize : (f.entry+1)*RootEntrySize]
+	quota := int(f.disk.byteOrder().Uint32(entry[RootEntryQuotaOffset : RootEntryQuotaOffset+RootEntryQuotaSize]))
+	if quota > 0 && size > quota && size > f.size {
+		return QuotaExceededError{f.name, quota}
+	}
+	return nil
+}
+
+// Ensures the file still refers to a usable disk
+// Scope: internal
+func (f *File) checkDisk() error {
+	if f.disk == nil {
+		return MemberUndefinedError{"disk"}
+	}
+	if f.disk.closed {
+		return DiskClosedError{}
+	}
+	return nil
+}
+
+// Checks the file's disk accepts modifications, and that the file's mode
+// lets its owner write it
+// Scope: internal
+func (f *File) checkWritable() error {
+	if err := f.checkDisk(); err != nil {
+		return err
+	}
+	if err := f.disk.checkWritable(); err != nil {
+		return err
+	}
+	if f.Mode()&0200 == 0 {
+		return PermissionDeniedError{f.name}
+	}
 	return nil
 }
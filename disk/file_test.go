@@ -1,24 +1,634 @@
 package disk
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"io"
 	"os"
 	"testing"
 )
 
-func TestFile_Read(t *testing.T) {
+// Device failing every write once its allowance runs out, to simulate a
+// crash part way through an operation
+type failingDevice struct {
+	BlockDevice
+	writes int // writes left before failing, or -1 for no limit
+}
+
+func (f *failingDevice) WriteAt(data []byte, offset int64) (int, error) {
+	if f.writes == 0 {
+		return 0, CustomError{"Simulated write failure"}
+	}
+	if f.writes > 0 {
+		f.writes--
+	}
+	return f.BlockDevice.WriteAt(data, offset)
+}
 
+func TestFile_Read(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("abcdefgh"), BlockSize/4)
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(tData)
+	f.Close()
+	f, _ = d.Open(tFilename)
+	// Test
+	got := make([]byte, 0, len(tData))
+	buff := make([]byte, 1000)
+	for {
+		n, err := f.Read(buff)
+		got = append(got, buff[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes read back intact, Got %v bytes", len(tData), len(got))
+	}
+	if f.offset != len(tData) {
+		t.Errorf("Expected file offset %v, Got %v", len(tData), f.offset)
+	}
+	t.Run("partial block at end", func(t *testing.T) {
+		// the next file's block follows straight on, so an over-read
+		// would pick up its bytes
+		tShort := bytes.Repeat([]byte("s"), BlockSize+100)
+		d.WriteFile("short.txt", tShort)
+		d.WriteFile("next.txt", bytes.Repeat([]byte("n"), BlockSize))
+		f, _ := d.Open("short.txt")
+		defer f.Close()
+		f.offset = BlockSize
+		buff := bytes.Repeat([]byte("x"), BlockSize)
+		n, err := f.Read(buff)
+		if n != 100 || err != nil {
+			t.Errorf("Expected 100 bytes and nil, Got %v bytes and %v", n, err)
+		}
+		if !bytes.Equal(buff[:n], tShort[BlockSize:]) {
+			t.Errorf("Expected the file's last bytes, Got %q", buff[:n])
+		}
+		// nothing past the remainder is touched
+		if !bytes.Equal(buff[n:], bytes.Repeat([]byte("x"), BlockSize-n)) {
+			t.Errorf("Expected the rest of the buffer untouched")
+		}
+		if n, err = f.Read(buff); n != 0 || err != io.EOF {
+			t.Errorf("Expected 0 bytes and io.EOF, Got %v bytes and %v", n, err)
+		}
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
 }
 
 func TestFile_ReadAt(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("abcdefgh"), BlockSize/4)
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(tData)
+	// Test
+	// read straddling the block boundary
+	buff := make([]byte, 20)
+	n, err := f.ReadAt(buff, BlockSize-10)
+	if err != nil {
+		t.Error(err)
+	}
+	if n != len(buff) || !bytes.Equal(buff, tData[BlockSize-10:BlockSize+10]) {
+		t.Errorf("Expected %q, Got %q", tData[BlockSize-10:BlockSize+10], buff[:n])
+	}
+	// short read at the end of the file
+	n, err = f.ReadAt(buff, len(tData)-5)
+	if err != io.EOF || n != 5 {
+		t.Errorf("Expected 5 bytes and io.EOF, Got %v bytes and %v", n, err)
+	}
+	if n, err = f.ReadAt(buff, len(tData)); err != io.EOF || n != 0 {
+		t.Errorf("Expected 0 bytes and io.EOF past end, Got %v bytes and %v", n, err)
+	}
+	if f.offset != len(tData) {
+		t.Errorf("Expected file offset unchanged at %v, Got %v", len(tData), f.offset)
+	}
+	t.Run("seekBlock", func(t *testing.T) {
+		// Setup
+		f, _ := d.Create("long.txt")
+		tLong := bytes.Repeat([]byte("0123456789abcdef"), BlockSize)
+		f.Write(tLong)
+		fatBuff, _ := d.readFat()
+		blocks, _ := d.chainBlocks(fatBuff, f.desc)
+		// Test
+		buff := make([]byte, 10)
+		f.ReadAt(buff, 2*BlockSize)
+		if f.cursor == nil || *f.cursor != (chainCursor{2, blocks[2]}) {
+			t.Fatalf("Expected cursor at chain position 2, Got %v", f.cursor)
+		}
+		// break the chain ahead of the cursor; a forward read resumes past it
+		d.byteOrder().PutUint16(fatBuff[f.desc*FatEntrySize:], FatEoc)
+		d.WriteBlock(1, fatBuff[:BlockSize])
+		if _, err := f.ReadAt(buff, 5*BlockSize); err != nil || !bytes.Equal(buff, tLong[5*BlockSize:5*BlockSize+10]) {
+			t.Errorf("Expected forward read to resume from the cursor, Got %q, %v", buff, err)
+		}
+		// a backward read walks from the start and finds the break
+		if _, err := f.ReadAt(buff, BlockSize); err == nil {
+			t.Errorf("Expected CorruptChainError walking from the start, Got nil")
+		}
+		f.Write(nil)
+		if f.cursor != nil {
+			t.Errorf("Expected writes to clear the cursor")
+		}
+		// Teardown
+		f.Close()
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
 
+func TestFile_ReadRaw(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(bytes.Repeat([]byte("x"), BlockSize+100))
+	f.Truncate(BlockSize + 10)
+	// Test
+	buff := make([]byte, 2*BlockSize)
+	n, err := f.ReadRaw(buff, 0)
+	if err != nil || n != 2*BlockSize {
+		t.Fatalf("Expected %v raw bytes, Got %v, %v", 2*BlockSize, n, err)
+	}
+	// stale bytes past the truncated size are still visible
+	if !bytes.Equal(buff[BlockSize+10:BlockSize+100], bytes.Repeat([]byte("x"), 90)) {
+		t.Errorf("Expected stale padding after size, Got %q", buff[BlockSize+10:BlockSize+100])
+	}
+	if n, err = f.ReadRaw(buff, BlockSize*3/2); err != io.EOF || n != BlockSize/2 {
+		t.Errorf("Expected %v bytes and io.EOF at the block boundary, Got %v bytes and %v", BlockSize/2, n, err)
+	}
+	// default reads still stop at size
+	if n, _ = f.ReadAt(buff, 0); n != BlockSize+10 {
+		t.Errorf("Expected ReadAt to stop at %v, Got %v", BlockSize+10, n)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_Truncate(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(bytes.Repeat([]byte{0xAA}, 3*BlockSize))
+	// Test
+	if err := f.Truncate(BlockSize + 1); err != nil {
+		t.Error(err)
+	}
+	if blocks, _ := f.BlockCount(); blocks != 2 {
+		t.Errorf("Expected 2 blocks after shrink, Got %v", blocks)
+	}
+	if free, _ := d.RecomputeFree(); free != tBlockCt-2 {
+		t.Errorf("Expected %v free blocks after shrink, Got %v", tBlockCt-2, free)
+	}
+	// growing again exposes zeros, not the old contents
+	if err := f.Truncate(2 * BlockSize); err != nil {
+		t.Error(err)
+	}
+	buff := make([]byte, BlockSize-1)
+	f.ReadAt(buff, BlockSize+1)
+	if !bytes.Equal(buff, make([]byte, len(buff))) {
+		t.Error("Expected zeros in grown region")
+	}
+	if err := f.Truncate(0); err != nil {
+		t.Error(err)
+	}
+	if blocks, _ := f.BlockCount(); blocks != 1 || f.size != 0 {
+		t.Errorf("Expected start block kept and size 0, Got %v blocks and size %v", blocks, f.size)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_Grow(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write([]byte("record"))
+	f.offset = 2
+	// Test
+	if err := f.Grow(BlockSize); err != nil {
+		t.Error(err)
+	}
+	if f.size != BlockSize+6 || f.offset != 2 {
+		t.Errorf("Expected size %v and offset 2, Got %v and %v", BlockSize+6, f.size, f.offset)
+	}
+	buff := make([]byte, BlockSize)
+	f.ReadAt(buff, 6)
+	if !bytes.Equal(buff, make([]byte, BlockSize)) {
+		t.Errorf("Expected grown space to read as zeros")
+	}
+	if err := f.Grow(tBlockCt * BlockSize); err == nil {
+		t.Errorf("Expected FullDiskError, Got nil")
+	} else if _, ok := err.(FullDiskError); !ok {
+		t.Errorf("Expected FullDiskError, Got %v", err)
+	}
+	if f.size != BlockSize+6 {
+		t.Errorf("Expected size unchanged at %v after failed growth, Got %v", BlockSize+6, f.size)
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
 }
 
 func TestFile_Write(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	// Test
+	t.Run("closedDisk", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		f, _ := d.Create(tFilename)
+		d.Close()
+		// Test
+		n, err := f.Write([]byte("stale"))
+		if _, ok := err.(DiskClosedError); !ok {
+			t.Errorf("Expected DiskClosedError, Got %v", err)
+		}
+		if n != 0 {
+			t.Errorf("Expected 0 bytes written, Got %v", n)
+		}
+		if _, err = f.Read(make([]byte, 8)); err == nil {
+			t.Error("Expected error reading through stale handle")
+		}
+		if _, ok := f.Close().(DiskClosedError); !ok {
+			t.Error("Expected DiskClosedError closing stale handle")
+		}
+		// Teardown
+		os.Remove(tDiskFilename)
+	})
+	t.Run("exactBlockFill", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		f, _ := d.Create(tFilename)
+		// Test
+		f.Write(make([]byte, BlockSize))
+		// an empty write at the block boundary needs no new block either
+		f.Write(nil)
+		if blocks, _ := f.BlockCount(); blocks != 1 {
+			t.Errorf("Expected 1 block for %v bytes, Got %v", BlockSize, blocks)
+		}
+		if free, _ := d.RecomputeFree(); free != tBlockCt-1 {
+			t.Errorf("Expected %v free blocks, Got %v", tBlockCt-1, free)
+		}
+		f.Write([]byte{1})
+		if blocks, _ := f.BlockCount(); blocks != 2 {
+			t.Errorf("Expected 2 blocks for %v bytes, Got %v", BlockSize+1, blocks)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("interrupted", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		d.Close()
+		fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+		dev := &failingDevice{fd, -1}
+		d, _ = MountDevice(dev)
+		f, _ := d.Create(tFilename)
+		// Test
+		// first block's data, then two more blocks each linked by two FAT
+		// writes ahead of their data, then fail linking the fourth
+		dev.writes = 1 + 2*(2+1)
+		if _, err := f.Write(make([]byte, 5*BlockSize)); err == nil {
+			t.Fatal("Expected simulated write failure, Got nil")
+		}
+		d.Close()
+		d, _ = Mount(tDiskFilename)
+		fatBuff, _ := d.readFat()
+		blocks, err := d.chainBlocks(fatBuff, f.desc)
+		if err != nil {
+			t.Errorf("Expected walkable chain, Got %v", err)
+		}
+		if len(blocks) != 3 {
+			t.Errorf("Expected 3 linked blocks, Got %v", len(blocks))
+		}
+		// the fourth block's allocation never reached the disk
+		if free, _ := d.RecomputeFree(); free != tBlockCt-3 {
+			t.Errorf("Expected %v free blocks, Got %v", tBlockCt-3, free)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("size update fails", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt)
+		d.Close()
+		fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+		dev := &failingDevice{fd, -1}
+		d, _ = MountDevice(dev)
+		f, _ := d.Create(tFilename)
+		// Test
+		// the data and size record land, the root entry doesn't
+		dev.writes = 2
+		n, err := f.Write([]byte("durable"))
+		if err == nil {
+			t.Fatal("Expected simulated write failure, Got nil")
+		}
+		if n != 7 || f.size != 0 {
+			t.Errorf("Expected 7 bytes written and size 0 kept, Got %v bytes and size %v", n, f.size)
+		}
+		d.Close()
+		d, _ = Mount(tDiskFilename)
+		if got, _ := d.ReadFile(tFilename); len(got) != 0 {
+			t.Errorf("Expected the stale size 0 before repair, Got %v bytes", len(got))
+		}
+		if err = d.RepairSizes(); err != nil {
+			t.Error(err)
+		}
+		if got, _ := d.ReadFile(tFilename); string(got) != "durable" {
+			t.Errorf("Expected durable after repair, Got %q", got)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("fullDisk", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, 2)
+		f, _ := d.Create(tFilename)
+		// Test
+		n, err := f.Write(make([]byte, 3*BlockSize))
+		if _, ok := err.(FullDiskError); !ok {
+			t.Errorf("Expected FullDiskError, Got %v", err)
+		}
+		if n != 0 || f.size != 0 {
+			t.Errorf("Expected nothing written, Got %v bytes and size %v", n, f.size)
+		}
+		if blocks, _ := f.BlockCount(); blocks != 1 {
+			t.Errorf("Expected chain left at 1 block, Got %v", blocks)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("fullDiskBlockZero", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, 16)
+		d.WriteFile("first.txt", nil)
+		f, _ := d.Create(tFilename)
+		d.Remove("first.txt")
+		// Test
+		// block 0 counts as free but is never allocated
+		free, _ := d.FreeBlocks()
+		n, err := f.Write(make([]byte, (free+1)*BlockSize))
+		if _, ok := err.(FullDiskError); !ok {
+			t.Errorf("Expected FullDiskError, Got %v", err)
+		}
+		if n != 0 || f.size != 0 {
+			t.Errorf("Expected nothing written, Got %v bytes and size %v", n, f.size)
+		}
+		if blocks, _ := f.BlockCount(); blocks != 1 {
+			t.Errorf("Expected chain left at 1 block, Got %v", blocks)
+		}
+		// Teardown
+		f.Close()
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	tData := bytes.Repeat([]byte("0123456789"), BlockSize/5)
+	n, err := f.Write(tData)
+	if err != nil {
+		t.Error(err)
+	}
+	if n != len(tData) || f.offset != len(tData) {
+		t.Errorf("Expected %v bytes written and offset, Got %v and %v", len(tData), n, f.offset)
+	}
+	f.Write(tData)
+	if blocks, _ := f.BlockCount(); blocks != 4 {
+		t.Errorf("Expected 4 blocks, Got %v", blocks)
+	}
+	// size survives reopen
+	f.Close()
+	f, _ = d.Open(tFilename)
+	if f.size != 2*len(tData) {
+		t.Errorf("Expected file size %v, Got %v", 2*len(tData), f.size)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
 
+func TestFile_WriteWithStats(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create("a.txt")
+	// Test
+	stats, err := f.WriteWithStats(make([]byte, 2*BlockSize))
+	if err != nil {
+		t.Error(err)
+	}
+	if stats != (WriteStats{2 * BlockSize, 1, true}) {
+		t.Errorf("Expected 1 contiguous block added, Got %+v", stats)
+	}
+	if stats, _ = f.WriteWithStats([]byte("x")); stats.Allocated != 1 || !stats.Contiguous {
+		t.Errorf("Expected 1 contiguous block added, Got %+v", stats)
+	}
+	// another file now sits right after a.txt's chain
+	d.WriteFile("b.txt", nil)
+	if stats, _ = f.WriteWithStats(make([]byte, BlockSize)); stats.Allocated != 1 || stats.Contiguous {
+		t.Errorf("Expected 1 non-contiguous block added, Got %+v", stats)
+	}
+	if stats, _ = f.WriteWithStats([]byte("y")); stats.Allocated != 0 || !stats.Contiguous {
+		t.Errorf("Expected no blocks added, Got %+v", stats)
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
 }
 
 func TestFile_WriteAt(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename, tData := "test.txt", []byte("hello")
+	tOffset := BlockSize + 10
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	// Test
+	n, err := f.WriteAt(tData, tOffset)
+	if err != nil {
+		t.Error(err)
+	}
+	if n != len(tData) {
+		t.Errorf("Expected %v bytes written, Got %v", len(tData), n)
+	}
+	if f.size != tOffset+len(tData) {
+		t.Errorf("Expected file size %v, Got %v", tOffset+len(tData), f.size)
+	}
+	if f.offset != 0 {
+		t.Errorf("Expected file offset unchanged at 0, Got %v", f.offset)
+	}
+	fatBuff, _ := d.readFat()
+	blocks, _ := d.chainBlocks(fatBuff, f.desc)
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks in chain, Got %v", len(blocks))
+	}
+	raw := make([]byte, BlockSize)
+	d.fd.ReadAt(raw, int64((d.dataStartInd+blocks[1])*BlockSize))
+	if !bytes.Equal(raw[10:10+len(tData)], tData) {
+		t.Errorf("Expected data %q in second block, Got %q", tData, raw[10:10+len(tData)])
+	}
+	if !bytes.Equal(raw[:10], make([]byte, 10)) {
+		t.Error("Expected zero fill ahead of data")
+	}
+	if _, err = f.WriteAt(tData, -1); err == nil {
+		t.Error("Expected error for negative offset")
+	}
+	t.Run("hugeOffset", func(t *testing.T) {
+		// Setup
+		d, _ := New("tiny.disk", 8)
+		f, _ := d.Create(tFilename)
+		f.Write(tData)
+		// Test
+		for _, offset := range []int{1 << 36, 1 << 20} {
+			if _, err := f.WriteAt([]byte{1}, offset); err == nil {
+				t.Errorf("Expected FullDiskError at offset %v, Got nil", offset)
+			} else if _, ok := err.(FullDiskError); !ok {
+				t.Errorf("Expected FullDiskError at offset %v, Got %v", offset, err)
+			}
+		}
+		if err := f.Truncate(1 << 36); err == nil {
+			t.Errorf("Expected FullDiskError truncating up, Got nil")
+		}
+		if f.size != len(tData) {
+			t.Errorf("Expected size unchanged at %v, Got %v", len(tData), f.size)
+		}
+		if n, _ := f.BlockCount(); n != 1 {
+			t.Errorf("Expected 1 block in chain, Got %v", n)
+		}
+		// Teardown
+		f.Close()
+		d.Close()
+		os.Remove("tiny.disk")
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_BlockCount(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	// extend the chain by hand: start block -> 5 -> EOC
+	fatBuff, _ := d.readFat()
+	d.byteOrder().PutUint16(fatBuff[f.desc*FatEntrySize:], 5)
+	d.byteOrder().PutUint16(fatBuff[5*FatEntrySize:], FatEoc)
+	d.writeMeta(metaWrite{1, fatBuff})
+	// Test
+	n, err := f.BlockCount()
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 blocks, Got %v", n)
+	}
+	n, err = d.BlockCountOf(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 blocks from disk, Got %v", n)
+	}
+	_, err = d.BlockCountOf("missing.txt")
+	if _, ok := err.(FileNotFoundError); !ok {
+		t.Error("Expected FileNotFoundError for missing file")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
 
+func TestFile_BlockForOffset(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create("test.txt")
+	g, _ := d.Create("other.txt")
+	// test.txt takes blocks 0 and 2 around other.txt's block 1
+	f.Write(make([]byte, BlockSize+1))
+	// Test
+	for _, tc := range []struct {
+		offset, block, within int
+	}{
+		{0, 0, 0},
+		{BlockSize - 1, 0, BlockSize - 1},
+		{BlockSize, 2, 0},
+		// the bytes past the size are still in the chain
+		{2*BlockSize - 1, 2, BlockSize - 1},
+	} {
+		block, within, err := f.BlockForOffset(tc.offset)
+		if err != nil || block != tc.block || within != tc.within {
+			t.Errorf("Expected offset %v in block %v at %v, Got %v at %v (%v)", tc.offset, tc.block, tc.within, block, within, err)
+		}
+	}
+	if _, _, err := f.BlockForOffset(2 * BlockSize); err == nil {
+		t.Errorf("Expected an error past the chain, Got nil")
+	}
+	// Teardown
+	f.Close()
+	g.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_Hash(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("hash me "), BlockSize/3)
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(tData)
+	// dirty the tail of the last block past the file size
+	fatBuff, _ := d.readFat()
+	blocks, _ := d.chainBlocks(fatBuff, f.desc)
+	last := (d.dataStartInd+blocks[len(blocks)-1]+1)*BlockSize - 1
+	d.fd.WriteAt([]byte{0xFF}, int64(last))
+	// Test
+	h := sha256.New()
+	if err := f.Hash(h); err != nil {
+		t.Error(err)
+	}
+	exp := sha256.Sum256(tData)
+	if !bytes.Equal(h.Sum(nil), exp[:]) {
+		t.Errorf("Expected hash %x, Got %x", exp, h.Sum(nil))
+	}
+	f.Close()
+	h.Reset()
+	if err := d.HashFile(tFilename, h); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(h.Sum(nil), exp[:]) {
+		t.Errorf("Expected hash %x from disk, Got %x", exp, h.Sum(nil))
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
 }
 
 func TestFile_Close(t *testing.T) {
@@ -42,4 +652,51 @@ func TestFile_Close(t *testing.T) {
 	// Teardown
 	f.disk.fd.Close()
 	os.Remove(tDiskFilename)
-}
\ No newline at end of file
+}
+func TestFile_ForEachChunk(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("chunky "), BlockSize/2)
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	f.Write(tData)
+	// Test
+	for _, size := range []int{1000, BlockSize, 3 * BlockSize, len(tData) + 1} {
+		var got []byte
+		chunks := 0
+		err := f.ForEachChunk(size, func(chunk []byte) error {
+			if len(chunk) != size && len(got)+len(chunk) != len(tData) {
+				t.Errorf("Expected chunk of %v bytes, Got %v", size, len(chunk))
+			}
+			got = append(got, chunk...)
+			chunks++
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if exp := (len(tData) + size - 1) / size; chunks != exp {
+			t.Errorf("Expected %v chunks of %v bytes, Got %v", exp, size, chunks)
+		}
+		if !bytes.Equal(got, tData) {
+			t.Errorf("Expected chunks of %v bytes to rebuild the file", size)
+		}
+	}
+	stop := CustomError{"stop"}
+	calls := 0
+	err := f.ForEachChunk(100, func(chunk []byte) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Expected walk stopped by callback error after 1 call, Got %v after %v", err, calls)
+	}
+	if err = f.ForEachChunk(0, nil); err == nil {
+		t.Errorf("Expected error for chunk size 0, Got nil")
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package disk
+
+import "os"
+
+// Advisory file locks aren't supported on this platform, so mounts go
+// unguarded
+// Scope: internal
+func flockFile(file *os.File, exclusive bool) error {
+	return nil
+}
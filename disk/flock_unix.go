@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package disk
+
+import (
+	"os"
+	"syscall"
+)
+
+// Takes an advisory lock on the whole file without waiting, exclusive or
+// shared. The lock is released when the file is closed.
+// Scope: internal
+func flockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return DiskBusyError{file.Name()}
+	}
+	return err
+}
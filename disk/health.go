@@ -0,0 +1,62 @@
+package disk
+
+// Summary of a disk's state, as reported by Health
+type HealthReport struct {
+	Closed        bool    // the disk is closed; no other field is filled in
+	ReadOnly      bool    // mounted without write access
+	BackupSuper   bool    // running from the backup superblock, the primary being damaged
+	Dirty         bool    // the journal holds an update not yet applied, left by a crash under a read-only mount
+	FreeBlocks    int     // free data blocks
+	FreePercent   float64 // free data blocks as a percentage of all of them
+	Fragmentation float64 // score of the latest Fragmentation call, or -1 if the FAT has changed since
+	OpenFiles     int     // files currently open
+	Checked       bool    // Check has run since the disk was mounted
+	CheckPassed   bool    // the latest Check found no problems
+	Err           error   // first error met reading the disk, if any
+}
+
+// Reports the disk's state in one call, e.g. for a service health check.
+// The report is cheap to take: the free count comes from the cached count
+// where one is kept, and only the journal header is read. Nothing scans
+// the chains, so fragmentation and the result of Check are those of the
+// latest calls to Fragmentation and Check; call those first for a fresh
+// full picture. Errors reading the disk are reported in Err rather than
+// returned, with the fields that depend on them left zero.
+// Returns: report on the disk's state
+// Scope: exported
+func (d *Disk) Health() HealthReport {
+	if d.closed {
+		return HealthReport{Closed: true}
+	}
+	report := HealthReport{
+		ReadOnly:      d.readOnly,
+		BackupSuper:   d.fromBackup,
+		Fragmentation: -1,
+		Checked:       d.checked,
+		CheckPassed:   d.checked && d.checkPassed,
+	}
+	if d.fragValid {
+		report.Fragmentation = d.frag
+	}
+	for _, open := range d.open {
+		if open {
+			report.OpenFiles++
+		}
+	}
+	free, err := d.FreeBlocks()
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	report.FreeBlocks = free
+	report.FreePercent = 100 * float64(free) / float64(d.dataBlockCt)
+	if d.journalInd > 0 {
+		header := make([]byte, BlockSize)
+		if _, err = d.fd.ReadAt(header, int64(d.journalInd*BlockSize)); err != nil {
+			report.Err = err
+			return report
+		}
+		report.Dirty = d.byteOrder().Uint16(header[JournalCountOffset:JournalCountOffset+JournalCountSize]) != 0
+	}
+	return report
+}
@@ -0,0 +1,61 @@
+package disk
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDisk_Health(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+	// Test
+	report := d.Health()
+	if report.FreeBlocks != tBlockCt || report.FreePercent != 100 || report.Err != nil {
+		t.Errorf("Expected an empty disk, Got %+v", report)
+	}
+	if report.Fragmentation != -1 || report.Checked || report.Dirty {
+		t.Errorf("Expected nothing measured or pending, Got %+v", report)
+	}
+	d.WriteFile("a.txt", make([]byte, 2*BlockSize))
+	f, _ := d.Open("a.txt")
+	score, _ := d.Fragmentation()
+	if report = d.Health(); report.OpenFiles != 1 || report.Fragmentation != score {
+		t.Errorf("Expected 1 open file and fragmentation %v, Got %+v", score, report)
+	}
+	if report.FreeBlocks != tBlockCt-2 {
+		t.Errorf("Expected %v free blocks, Got %v", tBlockCt-2, report.FreeBlocks)
+	}
+	f.Close()
+	// a change to the FAT outdates the score
+	d.WriteFile("b.txt", nil)
+	if report = d.Health(); report.Fragmentation != -1 {
+		t.Errorf("Expected fragmentation -1 after the FAT changed, Got %v", report.Fragmentation)
+	}
+	d.Check()
+	if report = d.Health(); !report.Checked || !report.CheckPassed {
+		t.Errorf("Expected a passed Check, Got %+v", report)
+	}
+	// an allocated block no file reaches fails the next Check
+	fatBuff, _ := d.readFat()
+	d.byteOrder().PutUint16(fatBuff[10*FatEntrySize:], FatEoc)
+	d.writeMeta(metaWrite{1, fatBuff})
+	d.Check()
+	if report = d.Health(); !report.Checked || report.CheckPassed {
+		t.Errorf("Expected a failed Check, Got %+v", report)
+	}
+	// an update left in the journal shows under a read-only mount
+	rootBuff, _ := d.readRootDir()
+	d.logJournal([]metaWrite{{d.rootDirInd, rootBuff}})
+	d.Close()
+	if report = d.Health(); !report.Closed {
+		t.Errorf("Expected a closed disk reported, Got %+v", report)
+	}
+	d, _ = MountReadOnly(tDiskFilename)
+	if report = d.Health(); !report.ReadOnly || !report.Dirty || report.Checked {
+		t.Errorf("Expected a dirty read-only disk not yet checked, Got %+v", report)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,140 @@
+package disk
+
+import "hash/crc32"
+
+const (
+	JournalCountOffset   = 0x00
+	JournalCountSize     = 2
+	JournalTargetsOffset = 0x02
+	JournalTargetSize    = 2
+	JournalCrcOffset     = 0xFFC
+	JournalCrcSize       = 4
+)
+
+// A pending update of one or more consecutive metadata blocks
+type metaWrite struct {
+	block int    // absolute index of the first block written
+	data  []byte // whole blocks of data
+}
+
+// Writes metadata blocks to disk. On a journaled disk the blocks are first
+// logged and committed to the journal, so the update as a whole either
+// takes effect or doesn't, even across a crash. During a transaction the
+// blocks are only held in memory, and are journaled when it stores them.
+// Scope: internal
+func (d *Disk) writeMeta(writes ...metaWrite) error {
+	if d.journalInd == 0 || d.tx != nil {
+		return d.applyMeta(writes)
+	}
+	if err := d.logJournal(writes); err != nil {
+		return err
+	}
+	if err := d.applyMeta(writes); err != nil {
+		return err
+	}
+	if err := d.sync(); err != nil {
+		return err
+	}
+	return d.clearJournal()
+}
+
+// Writes metadata blocks in place
+// Scope: internal
+func (d *Disk) applyMeta(writes []metaWrite) error {
+	for _, w := range writes {
+		if _, err := d.fd.WriteAt(w.data, int64(w.block*BlockSize)); err != nil {
+			// part of the update may have landed
+			d.mapValid = false
+			return err
+		}
+		d.noteMetaWrite(w)
+	}
+	return nil
+}
+
+// Copies each block to the journal, then commits by writing a header that
+// lists the target block of every copy. The header checksum marks the
+// commit as complete; a torn header write reads back as uncommitted.
+// Scope: internal
+func (d *Disk) logJournal(writes []metaWrite) error {
+	header := make([]byte, BlockSize)
+	count := 0
+	for _, w := range writes {
+		for off := 0; off < len(w.data); off += BlockSize {
+			// first journal block is reserved for the header
+			if count+1 >= d.journalBlockCt {
+				return JournalFullError{}
+			}
+			pos := JournalTargetsOffset + count*JournalTargetSize
+			target := header[pos : pos+JournalTargetSize]
+			d.byteOrder().PutUint16(target, uint16(w.block+off/BlockSize))
+			image := w.data[off : off+BlockSize]
+			if _, err := d.fd.WriteAt(image, int64((d.journalInd+1+count)*BlockSize)); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	// block images must be durable before the header commits them
+	if err := d.sync(); err != nil {
+		return err
+	}
+	d.byteOrder().PutUint16(header[JournalCountOffset:JournalCountOffset+JournalCountSize], uint16(count))
+	crc := header[JournalCrcOffset : JournalCrcOffset+JournalCrcSize]
+	d.byteOrder().PutUint32(crc, crc32.ChecksumIEEE(header[:JournalCrcOffset]))
+	if _, err := d.fd.WriteAt(header, int64(d.journalInd*BlockSize)); err != nil {
+		return err
+	}
+	return d.sync()
+}
+
+// Applies a committed journal left behind by an interrupted update, or
+// discards an uncommitted one. Called by Mount.
+// Scope: internal
+func (d *Disk) replayJournal() error {
+	header := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(header, int64(d.journalInd*BlockSize)); err != nil {
+		return err
+	}
+	count := int(d.byteOrder().Uint16(header[JournalCountOffset : JournalCountOffset+JournalCountSize]))
+	if count == 0 {
+		return nil
+	}
+	stored := d.byteOrder().Uint32(header[JournalCrcOffset : JournalCrcOffset+JournalCrcSize])
+	if stored != crc32.ChecksumIEEE(header[:JournalCrcOffset]) {
+		// the crash hit before the commit completed, so nothing was applied
+		return d.clearJournal()
+	}
+	if count >= d.journalBlockCt {
+		return CorruptJournalError{"entry count"}
+	}
+	image := make([]byte, BlockSize)
+	for i := 0; i < count; i++ {
+		pos := JournalTargetsOffset + i*JournalTargetSize
+		target := int(d.byteOrder().Uint16(header[pos : pos+JournalTargetSize]))
+		// only the FAT, root directory and size table are ever journaled
+		table := d.sizeTableBlockCt() > 0 && target == d.sizeTableInd()
+		if (target < 1 || target > d.rootDirInd) && !table {
+			return CorruptJournalError{"target block"}
+		}
+		if _, err := d.fd.ReadAt(image, int64((d.journalInd+1+i)*BlockSize)); err != nil {
+			return err
+		}
+		if _, err := d.fd.WriteAt(image, int64(target*BlockSize)); err != nil {
+			return err
+		}
+	}
+	if err := d.sync(); err != nil {
+		return err
+	}
+	return d.clearJournal()
+}
+
+// Zeroes the journal header, marking the journal empty
+// Scope: internal
+func (d *Disk) clearJournal() error {
+	if _, err := d.fd.WriteAt(make([]byte, BlockSize), int64(d.journalInd*BlockSize)); err != nil {
+		return err
+	}
+	return d.sync()
+}
@@ -0,0 +1,86 @@
+package disk
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestDisk_WithJournal(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	// Test
+	t.Run("replayJournal", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+		rootBuff, _ := d.readRootDir()
+		d.initRootEntry(rootBuff, tFilename, 0)
+		// commit to the journal but crash before applying
+		if err := d.logJournal([]metaWrite{{d.rootDirInd, rootBuff}}); err != nil {
+			t.Error(err)
+		}
+		d.Close()
+		// Test
+		d, err := Mount(tDiskFilename)
+		if err != nil {
+			t.Error(err)
+		}
+		if _, err = d.Open(tFilename); err != nil {
+			t.Errorf("Expected committed entry to be replayed, Got %v", err)
+		}
+		header := make([]byte, BlockSize)
+		d.fd.ReadAt(header, int64(d.journalInd*BlockSize))
+		if count := binary.LittleEndian.Uint16(header); count != 0 {
+			t.Errorf("Expected journal cleared after replay, Got %v entries", count)
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	t.Run("tornCommit", func(t *testing.T) {
+		// Setup
+		d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+		rootBuff, _ := d.readRootDir()
+		d.initRootEntry(rootBuff, tFilename, 0)
+		d.logJournal([]metaWrite{{d.rootDirInd, rootBuff}})
+		// damage the header so its checksum no longer matches
+		d.fd.WriteAt([]byte{0xFF}, int64(d.journalInd*BlockSize+JournalTargetsOffset+1))
+		d.Close()
+		// Test
+		d, err := Mount(tDiskFilename)
+		if err != nil {
+			t.Error(err)
+		}
+		if _, err = d.Open(tFilename); err == nil {
+			t.Error("Expected uncommitted entry to be discarded")
+		}
+		// Teardown
+		d.Close()
+		os.Remove(tDiskFilename)
+	})
+	d, err := New(tDiskFilename, tBlockCt, WithJournal())
+	if err != nil {
+		t.Error(err)
+	}
+	if d.journalInd != 2+d.fatBlockCt+tBlockCt {
+		t.Errorf("Expected journal index %v, Got %v", 2+d.fatBlockCt+tBlockCt, d.journalInd)
+	}
+	file, err := d.Create(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	file.Close()
+	if err = d.Remove(tFilename); err != nil {
+		t.Error(err)
+	}
+	d.Close()
+	// journaled layout passes validation
+	d, err = MountValidated(tDiskFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,105 @@
+package disk
+
+import "sync"
+
+// Mode of an advisory lock on a filename
+type LockMode int
+
+const (
+	LockShared    LockMode = iota // any number of shared holders at once
+	LockExclusive                 // a single holder, excluding all others
+)
+
+// Advisory locks held on filenames, shared by every copy of a Disk
+type lockTable struct {
+	mu     sync.Mutex
+	cond   *sync.Cond      // signalled whenever a lock is released
+	shared map[string]int  // number of shared holders of each name
+	excl   map[string]bool // names held exclusively
+}
+
+// Creates an empty lock table
+// Scope: internal
+func newLockTable() *lockTable {
+	t := &lockTable{shared: make(map[string]int), excl: make(map[string]bool)}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// Takes an advisory lock on the filename, waiting until it can be granted.
+// Locks are held in memory only and are independent of opening the file:
+// the file need not exist or be open, and holding a lock doesn't stop
+// anyone from opening it. They let cooperating goroutines coordinate
+// access beyond the single-open rule.
+// Scope: exported
+func (d *Disk) Lock(filename string, mode LockMode) error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	t, name := d.locks, d.normName(filename)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for !t.grantable(name, mode) {
+		t.cond.Wait()
+	}
+	t.take(name, mode)
+	return nil
+}
+
+// Takes an advisory lock on the filename like Lock, but fails with a
+// LockHeldError instead of waiting if it can't be granted immediately
+// Scope: exported
+func (d *Disk) TryLock(filename string, mode LockMode) error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	t, name := d.locks, d.normName(filename)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.grantable(name, mode) {
+		return LockHeldError{filename}
+	}
+	t.take(name, mode)
+	return nil
+}
+
+// Releases an advisory lock on the filename: the exclusive lock if one is
+// held, and otherwise one shared hold
+// Scope: exported
+func (d *Disk) Unlock(filename string) error {
+	t, name := d.locks, d.normName(filename)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.excl[name]:
+		delete(t.excl, name)
+	case t.shared[name] > 0:
+		if t.shared[name]--; t.shared[name] == 0 {
+			delete(t.shared, name)
+		}
+	default:
+		return LockNotHeldError{filename}
+	}
+	t.cond.Broadcast()
+	return nil
+}
+
+// Reports whether a lock of the given mode can be taken now. Called with
+// the table locked.
+// Scope: internal
+func (t *lockTable) grantable(name string, mode LockMode) bool {
+	if mode == LockExclusive {
+		return !t.excl[name] && t.shared[name] == 0
+	}
+	return !t.excl[name]
+}
+
+// Records a granted lock. Called with the table locked.
+// Scope: internal
+func (t *lockTable) take(name string, mode LockMode) {
+	if mode == LockExclusive {
+		t.excl[name] = true
+	} else {
+		t.shared[name]++
+	}
+}
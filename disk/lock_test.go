@@ -0,0 +1,60 @@
+package disk
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDisk_Lock(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if err := d.Lock(tFilename, LockShared); err != nil {
+		t.Error(err)
+	}
+	if err := d.TryLock(tFilename, LockShared); err != nil {
+		t.Errorf("Expected second shared lock to be granted, Got %v", err)
+	}
+	if _, ok := d.TryLock(tFilename, LockExclusive).(LockHeldError); !ok {
+		t.Errorf("Expected LockHeldError for exclusive lock over shared holders")
+	}
+	// an exclusive waiter is granted once every shared hold is released
+	granted := make(chan error)
+	go func() {
+		granted <- d.Lock(tFilename, LockExclusive)
+	}()
+	d.Unlock(tFilename)
+	select {
+	case <-granted:
+		t.Fatal("Expected exclusive lock to wait for the remaining shared hold")
+	case <-time.After(20 * time.Millisecond):
+	}
+	d.Unlock(tFilename)
+	select {
+	case err := <-granted:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected exclusive lock to be granted")
+	}
+	if _, ok := d.TryLock(tFilename, LockShared).(LockHeldError); !ok {
+		t.Errorf("Expected LockHeldError for shared lock under exclusive holder")
+	}
+	// locks don't depend on the file existing or being open
+	if _, err := d.Create(tFilename); err != nil {
+		t.Errorf("Expected Create to ignore advisory locks, Got %v", err)
+	}
+	if err := d.Unlock(tFilename); err != nil {
+		t.Error(err)
+	}
+	if _, ok := d.Unlock(tFilename).(LockNotHeldError); !ok {
+		t.Errorf("Expected LockNotHeldError unlocking a free name")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,214 @@
+package disk
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	MetaKeyLenSize   = 1
+	MetaValueLenSize = 2
+	MetaHeaderSize   = MetaKeyLenSize + MetaValueLenSize
+	MetaMaxKeySize   = 255
+	MetaOffsetPrefix = "offset/" // keys of offsets saved by OpenResume
+)
+
+// Stores a user-defined key/value pair in the superblock's padding, for
+// stamping an image with small application data that survives Mount.
+// Setting an existing key replaces its value. Each pair takes
+// MetaHeaderSize bytes besides the key and value, and all pairs together
+// must fit in SbPaddSize bytes, or a MetadataFullError is returned. Keys
+// beginning with MetaOffsetPrefix are reserved.
+// Scope: exported
+func (d *Disk) SetMetadata(key, value string) error {
+	if strings.HasPrefix(key, MetaOffsetPrefix) {
+		return CustomError{"Metadata key prefix reserved"}
+	}
+	return d.setMetadata(key, value)
+}
+
+// Stores a metadata pair, reserved keys included
+// Scope: internal
+func (d *Disk) setMetadata(key, value string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if len(key) == 0 || len(key) > MetaMaxKeySize {
+		return CustomError{"Metadata key must be 1 to 255 bytes"}
+	}
+	pairs, err := d.readMetadata()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range pairs {
+		if pairs[i][0] == key {
+			pairs[i][1] = value
+			replaced = true
+		}
+	}
+	if !replaced {
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return d.storeMetadata(pairs)
+}
+
+// Drops the metadata pairs with the given keys, if any are stored
+// Scope: internal
+func (d *Disk) deleteMetadata(keys ...string) error {
+	pairs, err := d.readMetadata()
+	if err != nil {
+		return err
+	}
+	drop := make(map[string]bool)
+	for _, key := range keys {
+		drop[key] = true
+	}
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		if !drop[pair[0]] {
+			kept = append(kept, pair)
+		}
+	}
+	if len(kept) == len(pairs) {
+		return nil
+	}
+	return d.storeMetadata(kept)
+}
+
+// Moves a stored metadata value to another key, if it is stored. A value
+// already under the new key, e.g. left by a crash, is replaced.
+// Scope: internal
+func (d *Disk) renameMetadata(from, to string) error {
+	pairs, err := d.readMetadata()
+	if err != nil {
+		return err
+	}
+	moved := false
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		switch pair[0] {
+		case from:
+			pair[0] = to
+			moved = true
+		case to:
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	if !moved {
+		return nil
+	}
+	return d.storeMetadata(kept)
+}
+
+// Encodes the metadata pairs into the superblock's padding
+// Scope: internal
+func (d *Disk) storeMetadata(pairs [][2]string) error {
+	size := 0
+	for _, pair := range pairs {
+		size += MetaHeaderSize + len(pair[0]) + len(pair[1])
+	}
+	if size > SbPaddSize {
+		return MetadataFullError{size, SbPaddSize}
+	}
+	padding := make([]byte, SbPaddSize)
+	pos := 0
+	for _, pair := range pairs {
+		padding[pos] = byte(len(pair[0]))
+		d.byteOrder().PutUint16(padding[pos+MetaKeyLenSize:pos+MetaHeaderSize], uint16(len(pair[1])))
+		pos += MetaHeaderSize
+		pos += copy(padding[pos:], pair[0])
+		pos += copy(padding[pos:], pair[1])
+	}
+	return d.SetSuperblockField(SbPaddOffset, padding)
+}
+
+// Looks up a key stored by SetMetadata
+// Returns: (stored value, whether the key was found, any error encountered)
+// Scope: exported
+func (d *Disk) GetMetadata(key string) (string, bool, error) {
+	if d.closed {
+		return "", false, DiskClosedError{}
+	}
+	pairs, err := d.readMetadata()
+	if err != nil {
+		return "", false, err
+	}
+	for _, pair := range pairs {
+		if pair[0] == key {
+			return pair[1], true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Decodes the key/value pairs in the superblock's padding. Pairs are
+// stored back to back as a key length byte, a value length, then the key
+// and value bytes; a zero key length, or the end of the padding, ends the
+// list. Images from before metadata have all-zero padding, so read as
+// having none.
+// Returns: (key/value pairs in stored order, any error encountered)
+// Scope: internal
+func (d *Disk) readMetadata() ([][2]string, error) {
+	padding := make([]byte, SbPaddSize)
+	if _, err := d.fd.ReadAt(padding, int64(d.superblockInd()*BlockSize+SbPaddOffset)); err != nil {
+		return nil, err
+	}
+	var pairs [][2]string
+	for pos := 0; pos < SbPaddSize && padding[pos] != 0; {
+		if pos+MetaHeaderSize > SbPaddSize {
+			return nil, CorruptSuperblockError{"metadata"}
+		}
+		keyLen := int(padding[pos])
+		valueLen := int(d.byteOrder().Uint16(padding[pos+MetaKeyLenSize : pos+MetaHeaderSize]))
+		pos += MetaHeaderSize
+		if pos+keyLen+valueLen > SbPaddSize {
+			return nil, CorruptSuperblockError{"metadata"}
+		}
+		key := string(padding[pos : pos+keyLen])
+		value := string(padding[pos+keyLen : pos+keyLen+valueLen])
+		pairs = append(pairs, [2]string{key, value})
+		pos += keyLen + valueLen
+	}
+	return pairs, nil
+}
+
+// Opens the file with given filename like Open, positioned at the offset
+// saved the last time it was opened this way. Closing the handle saves its
+// offset in the superblock metadata, so processing can resume from it
+// after a remount; the saved offset goes away when the file is removed.
+// Since this writes on Close even after only reading, it needs a writable
+// disk. Files never opened this way, or opened only with Open, start at 0.
+// Returns: (File structure reference, any error that occurred)
+// Scope: exported
+func (d *Disk) OpenResume(filename string) (File, error) {
+	if err := d.checkWritable(); err != nil {
+		return File{}, err
+	}
+	file, err := d.Open(filename)
+	if err != nil {
+		return File{}, err
+	}
+	saved, found, err := d.GetMetadata(MetaOffsetPrefix + file.name)
+	if err == nil && found {
+		if file.offset, err = strconv.Atoi(saved); err != nil {
+			err = CorruptSuperblockError{"saved offset"}
+		}
+	}
+	if err != nil {
+		file.Close()
+		return File{}, err
+	}
+	file.resume = true
+	return file, nil
+}
+
+// Saves the offset of a handle opened with OpenResume
+// Scope: internal
+func (f *File) saveOffset() error {
+	if !f.resume {
+		return nil
+	}
+	return f.disk.setMetadata(MetaOffsetPrefix+f.name, strconv.Itoa(f.offset))
+}
@@ -0,0 +1,92 @@
+package disk
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDisk_SetMetadata(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	if _, found, err := d.GetMetadata("creator"); found || err != nil {
+		t.Errorf("Expected no metadata on a new disk, Got %v, %v", found, err)
+	}
+	d.SetMetadata("creator", "imager")
+	d.SetMetadata("schema", "1")
+	if err := d.SetMetadata("schema", "2"); err != nil {
+		t.Error(err)
+	}
+	d.Close()
+	// metadata survives remounting, and still checksums correctly
+	d, err := MountValidated(tDiskFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{"creator": "imager", "schema": "2"} {
+		if value, found, _ := d.GetMetadata(key); !found || value != expected {
+			t.Errorf("Expected %s=%q, Got %q (found %v)", key, expected, value, found)
+		}
+	}
+	// the padding holds SbPaddSize bytes including each pair's header
+	big := strings.Repeat("x", SbPaddSize-MetaHeaderSize-len("big")-(MetaHeaderSize*2+len("creatorimagerschema2")))
+	if err = d.SetMetadata("big", big); err != nil {
+		t.Errorf("Expected exactly full metadata to fit, Got %v", err)
+	}
+	if err = d.SetMetadata("more", ""); err == nil {
+		t.Errorf("Expected MetadataFullError, Got nil")
+	} else if _, ok := err.(MetadataFullError); !ok {
+		t.Errorf("Expected MetadataFullError, Got %v", err)
+	}
+	if value, _, _ := d.GetMetadata("big"); value != big {
+		t.Errorf("Expected full-size value to read back")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_OpenResume(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, []byte("first,second"))
+	// Test
+	f, err := d.OpenResume(tFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := make([]byte, 6)
+	f.Read(buff)
+	f.Close()
+	d.Close()
+	d, _ = Mount(tDiskFilename)
+	f, _ = d.OpenResume(tFilename)
+	if f.offset != 6 {
+		t.Errorf("Expected resumed offset 6, Got %v", f.offset)
+	}
+	f.Close()
+	// plain opens are unaffected
+	f, _ = d.Open(tFilename)
+	if f.offset != 0 {
+		t.Errorf("Expected Open at offset 0, Got %v", f.offset)
+	}
+	f.Close()
+	if err = d.SetMetadata(MetaOffsetPrefix+tFilename, "0"); err == nil {
+		t.Errorf("Expected reserved key prefix rejected, Got nil")
+	}
+	// removal forgets the saved offset
+	d.Remove(tFilename)
+	d.WriteFile(tFilename, []byte("again"))
+	f, _ = d.OpenResume(tFilename)
+	if f.offset != 0 {
+		t.Errorf("Expected recreated file at offset 0, Got %v", f.offset)
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package disk
+
+import "os"
+
+// Opens the disk file for use with MountDevice. Memory mapping isn't
+// supported on this platform, so the file is used for regular I/O.
+// Scope: exported
+func OpenMapped(filename string) (BlockDevice, error) {
+	return os.OpenFile(filename, os.O_RDWR, 0)
+}
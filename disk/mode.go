@@ -0,0 +1,92 @@
+package disk
+
+import (
+	"os"
+	"time"
+)
+
+// Permission bits of files whose mode was never set, including every file
+// on disks written before modes were stored
+const DefaultFileMode os.FileMode = 0644
+
+// Expands a root entry's stored mode byte. Only read and write bits are
+// kept, shifted down one so all six fit the entry's last spare byte;
+// entries without AttrMode have the default mode.
+// Scope: internal
+func decodeMode(attr, perm byte) os.FileMode {
+	if attr&AttrMode == 0 {
+		return DefaultFileMode
+	}
+	return os.FileMode(perm) << 1
+}
+
+// Reports the file's permission bits
+// Returns: permission bits, as set by Chmod or DefaultFileMode
+func (f *File) Mode() os.FileMode {
+	return decodeMode(f.attr, f.perm)
+}
+
+// Sets the file's permission bits, taking effect for every handle opened
+// afterwards. The root entry has one spare byte for the mode, which holds
+// only the read and write bits of owner, group and others, so a mode with
+// execute or any other bits set is rejected rather than stored without
+// them. Without owner write permission, writes, truncation and
+// preallocation through any handle fail with a PermissionDeniedError,
+// while Chmod itself still works.
+func (f *File) Chmod(mode os.FileMode) error {
+	if err := f.checkDisk(); err != nil {
+		return err
+	}
+	if mode&^0666 != 0 {
+		return CustomError{"Only read and write permission bits can be stored"}
+	}
+	d := f.disk
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	entry := rootBuff[f.entry*RootEntrySize : (f.entry+1)*RootEntrySize]
+	entry[RootEntryAttrOffset] |= AttrMode
+	entry[RootEntryModeOffset] = byte(mode >> 1)
+	if err = d.writeMeta(metaWrite{d.rootDirInd, rootBuff}); err != nil {
+		return err
+	}
+	f.attr, f.perm = entry[RootEntryAttrOffset], entry[RootEntryModeOffset]
+	return nil
+}
+
+// Describes a file for os.FileInfo, from its root directory entry
+type fileInfo struct {
+	entry DirEntry // decoded root entry
+}
+
+func (fi fileInfo) Name() string       { return fi.entry.Name }
+func (fi fileInfo) Size() int64        { return int64(fi.entry.Size) }
+func (fi fileInfo) Mode() os.FileMode  { return fi.entry.Mode }
+func (fi fileInfo) ModTime() time.Time { return fi.entry.ModTime }
+func (fi fileInfo) IsDir() bool        { return false }
+
+// Returns the file's DirEntry
+func (fi fileInfo) Sys() interface{} { return fi.entry }
+
+// Describes the file with given filename, whether open or not. The
+// FileInfo's Sys method returns the file's DirEntry.
+// Returns: (description of the file, any error encountered)
+// Scope: exported
+func (d *Disk) Stat(filename string) (os.FileInfo, error) {
+	if d.closed {
+		return nil, DiskClosedError{}
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return nil, err
+	}
+	i := d.findRootEntry(rootBuff, filename)
+	if i < 0 || rootBuff[i+RootEntryAttrOffset]&AttrPending != 0 {
+		return nil, FileNotFoundError{filename}
+	}
+	return fileInfo{d.decodeEntry(rootBuff[i : i+RootEntrySize])}, nil
+}
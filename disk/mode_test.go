@@ -0,0 +1,89 @@
+package disk
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFile_Chmod(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, []byte("contents"))
+	f, _ := d.Open(tFilename)
+	// Test
+	if f.Mode() != DefaultFileMode {
+		t.Errorf("Expected mode %v for a new file, Got %v", DefaultFileMode, f.Mode())
+	}
+	if err := f.Chmod(0444); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 0); err == nil {
+		t.Errorf("Expected PermissionDeniedError writing a read-only file, Got nil")
+	} else if _, ok := err.(PermissionDeniedError); !ok {
+		t.Errorf("Expected PermissionDeniedError writing a read-only file, Got %v", err)
+	}
+	if _, ok := f.Truncate(0).(PermissionDeniedError); !ok {
+		t.Errorf("Expected PermissionDeniedError truncating a read-only file")
+	}
+	f.Close()
+	// the mode is stored with the entry
+	if _, ok := d.WriteFile(tFilename, nil).(PermissionDeniedError); !ok {
+		t.Errorf("Expected PermissionDeniedError replacing a read-only file")
+	}
+	if got, _ := d.ReadFile(tFilename); string(got) != "contents" {
+		t.Errorf("Expected contents kept, Got %q", got)
+	}
+	f, _ = d.Open(tFilename)
+	// execute bits can't be stored, so the mode is left as it was
+	for _, mode := range []os.FileMode{0755, 0644 | os.ModeSetuid} {
+		if err := f.Chmod(mode); err == nil {
+			t.Errorf("Expected an error for mode %v, Got nil", mode)
+		}
+	}
+	if f.Mode() != 0444 {
+		t.Errorf("Expected mode 0444, Got %v", f.Mode())
+	}
+	if err := f.Chmod(0644); err != nil {
+		t.Error(err)
+	}
+	if _, err := f.WriteAt([]byte("C"), 0); err != nil {
+		t.Errorf("Expected writes allowed again, Got %v", err)
+	}
+	f.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Stat(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, []byte("contents"))
+	f, _ := d.Open(tFilename)
+	f.Chmod(0600)
+	f.Close()
+	// Test
+	fi, err := d.Stat(tFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != tFilename || fi.Size() != 8 || fi.IsDir() {
+		t.Errorf("Expected regular file %s of 8 bytes, Got %s of %v bytes", tFilename, fi.Name(), fi.Size())
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("Expected mode 0600, Got %v", fi.Mode())
+	}
+	if entry, ok := fi.Sys().(DirEntry); !ok || entry.Name != tFilename {
+		t.Errorf("Expected Sys to return the DirEntry, Got %v", fi.Sys())
+	}
+	if _, err = d.Stat("missing.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
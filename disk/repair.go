@@ -0,0 +1,283 @@
+package disk
+
+import "bytes"
+
+// Selects which kinds of problem Repair fixes. Problems of kinds left
+// disabled are passed over untouched.
+type RepairOptions struct {
+	TruncateBadChains bool // end bad chains at their last valid block
+	SplitCrossLinks   bool // give the later file its own copy of the shared blocks
+	FreeOrphans       bool // release orphaned chains
+	ClearStaleSlots   bool // empty size records and IV slots their entries don't match
+	RecomputeFree     bool // recount the free blocks once repairs are done
+}
+
+// Fixes problems reported by Check. Bad chains are ended at their last
+// valid block. Cross-links are undone by copying the later file's chain
+// from the first shared block on into newly allocated blocks, so both
+// files keep their current contents. Orphaned chains are freed. Fixes are
+// applied in that order, all stored together at the end, and chains of
+// open files are left alone. Stale slots are then emptied in the size
+// table; an encrypted file whose IV is gone stays reported, since nothing
+// can bring it back. Sizes aren't adjusted to the repaired chains; follow
+// up with RepairSizes.
+// Returns: any errors encountered, combined in a MultiError
+// Scope: exported
+func (d *Disk) Repair(problems []Problem, opts RepairOptions) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	changed := false
+	for _, p := range problems {
+		if p.Kind != ProblemBadChain || !opts.TruncateBadChains {
+			continue
+		}
+		if err = d.truncateBadChain(fatBuff, rootBuff, p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		changed = true
+	}
+	for _, p := range problems {
+		if p.Kind != ProblemCrossLink || !opts.SplitCrossLinks {
+			continue
+		}
+		if err = d.splitCrossLink(fatBuff, rootBuff, p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		changed = true
+	}
+	if opts.FreeOrphans {
+		// ownership is taken after the other fixes, which may have
+		// released blocks from chains or claimed new ones
+		owned := make(map[int]bool)
+		for i := 0; i < len(rootBuff); i += RootEntrySize {
+			if rootBuff[i] == 0 {
+				continue
+			}
+			blocks, _ := d.walkChain(fatBuff, d.entryStartBlock(rootBuff[i:i+RootEntrySize]))
+			for _, block := range blocks {
+				owned[block] = true
+			}
+		}
+		for _, p := range problems {
+			if p.Kind != ProblemOrphan {
+				continue
+			}
+			for block := p.Block; d.validBlock(block) && !owned[block]; {
+				fatEntry := fatBuff[block*FatEntrySize : (block+1)*FatEntrySize]
+				next := d.byteOrder().Uint16(fatEntry)
+				if next == FatEntryUnused {
+					break
+				}
+				d.byteOrder().PutUint16(fatEntry, FatEntryUnused)
+				changed = true
+				block = int(next)
+			}
+		}
+	}
+	if changed {
+		if err = d.writeMeta(metaWrite{1, fatBuff}, metaWrite{d.rootDirInd, rootBuff}); err != nil {
+			return err
+		}
+		d.freeValid = false
+	}
+	if opts.ClearStaleSlots {
+		if err = d.clearStaleSlots(fatBuff, rootBuff, problems); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if opts.RecomputeFree {
+		if _, err = d.RecomputeFree(); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return MultiError{errs}
+	}
+	return nil
+}
+
+// Frees allocated data blocks that no root entry's chain reaches, such as
+// the start block of a Create that failed before storing its entry. This
+// is the orphan fix of Repair on its own, run in a single scan without the
+// rest of Check; reserved files count as referenced.
+// Returns: (number of blocks freed, any error encountered)
+// Scope: exported
+func (d *Disk) ReclaimLeaked() (int, error) {
+	if err := d.checkWritable(); err != nil {
+		return 0, err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return 0, err
+	}
+	owned := make(map[int]bool)
+	for i := 0; i < len(rootBuff); i += RootEntrySize {
+		if rootBuff[i] == 0 {
+			continue
+		}
+		// a bad chain still owns the blocks it reached
+		blocks, _ := d.walkChain(fatBuff, d.entryStartBlock(rootBuff[i:i+RootEntrySize]))
+		for _, block := range blocks {
+			owned[block] = true
+		}
+	}
+	freed := 0
+	for block := 0; block < d.dataBlockCt; block++ {
+		fatEntry := fatBuff[block*FatEntrySize : (block+1)*FatEntrySize]
+		if !owned[block] && d.byteOrder().Uint16(fatEntry) != FatEntryUnused {
+			d.byteOrder().PutUint16(fatEntry, FatEntryUnused)
+			freed++
+		}
+	}
+	if freed == 0 {
+		return 0, nil
+	}
+	if err = d.writeMeta(metaWrite{1, fatBuff}); err != nil {
+		return 0, err
+	}
+	d.adjustFree(freed)
+	return freed, nil
+}
+
+// Empties the stale parts of the slots named by ProblemStaleSlot problems,
+// judged against the repaired FAT and root directory
+// Scope: internal
+func (d *Disk) clearStaleSlots(fatBuff, rootBuff []byte, problems []Problem) error {
+	table, err := d.readSizeTable()
+	if err != nil || table == nil {
+		return err
+	}
+	cleared := append([]byte(nil), table...)
+	for _, p := range problems {
+		if p.Kind != ProblemStaleSlot || p.Block < 0 || p.Block >= len(rootBuff)/RootEntrySize {
+			continue
+		}
+		record, iv := d.staleSlots(table, fatBuff, rootBuff, p.Block)
+		if record {
+			copy(cleared[p.Block*SizeRecordSize:(p.Block+1)*SizeRecordSize], make([]byte, SizeRecordSize))
+		}
+		if iv {
+			at := IvRecordOffset + p.Block*IvRecordSize
+			copy(cleared[at:at+IvRecordSize], make([]byte, IvRecordSize))
+		}
+	}
+	if bytes.Equal(cleared, table) {
+		return nil
+	}
+	_, err = d.fd.WriteAt(cleared, int64(d.sizeTableInd()*BlockSize))
+	return err
+}
+
+// Ends a bad chain at its last valid block, within the FAT buffer
+// Scope: internal
+func (d *Disk) truncateBadChain(fatBuff, rootBuff []byte, p Problem) error {
+	i := d.findRootEntry(rootBuff, p.Filename)
+	if i < 0 {
+		return FileNotFoundError{p.Filename}
+	}
+	if d.checkIsOpen(p.Filename) {
+		return FileAlreadyInUseError{p.Filename}
+	}
+	start := d.entryStartBlock(rootBuff[i : i+RootEntrySize])
+	// with no valid block there is nothing to keep the chain's start
+	if !d.validBlock(p.Block) {
+		return CorruptChainError{start}
+	}
+	d.byteOrder().PutUint16(fatBuff[p.Block*FatEntrySize:(p.Block+1)*FatEntrySize], FatEoc)
+	return nil
+}
+
+// Copies the later file's chain from the first shared block on into newly
+// allocated blocks and links the copy in place of the shared part, within
+// the FAT and root directory buffers. The copied data is written at once;
+// it is only reachable once the buffers are stored.
+// Scope: internal
+func (d *Disk) splitCrossLink(fatBuff, rootBuff []byte, p Problem) error {
+	i := d.findRootEntry(rootBuff, p.Other)
+	if i < 0 {
+		return FileNotFoundError{p.Other}
+	}
+	if d.checkIsOpen(p.Other) {
+		return FileAlreadyInUseError{p.Other}
+	}
+	entry := rootBuff[i : i+RootEntrySize]
+	blocks, _ := d.walkChain(fatBuff, d.entryStartBlock(entry))
+	shared := -1
+	for j, block := range blocks {
+		if block == p.Block {
+			shared = j
+			break
+		}
+	}
+	// an earlier repair already split it
+	if shared < 0 {
+		return nil
+	}
+	// check the copy fits before allocating any of it
+	free := 0
+	for block := 1; block < d.dataBlockCt; block++ {
+		if d.blockFree(fatBuff, block) {
+			free++
+		}
+	}
+	if free < len(blocks)-shared {
+		return FullDiskError{}
+	}
+	// a size record is checksummed with the start block, so a copied
+	// start needs it rewritten
+	recorded, found := 0, false
+	if shared == 0 && d.sizeTableBlockCt() > 0 && entry[RootEntryAttrOffset]&AttrCompressed == 0 {
+		recorded, found, _ = d.readSizeRecord(i/RootEntrySize, blocks)
+	}
+	data := make([]byte, BlockSize)
+	copied := make([]int, 0, len(blocks)-shared)
+	for _, block := range blocks[shared:] {
+		if _, err := d.fd.ReadAt(data, int64((d.dataStartInd+block)*BlockSize)); err != nil {
+			return err
+		}
+		// allocation marks the copy as the end of the chain until the
+		// next block is linked after it
+		dup, err := d.allocBlock(fatBuff)
+		if err != nil {
+			return err
+		}
+		if _, err = d.fd.WriteAt(data, int64((d.dataStartInd+dup)*BlockSize)); err != nil {
+			return err
+		}
+		if len(copied) > 0 {
+			prev := copied[len(copied)-1]
+			d.byteOrder().PutUint16(fatBuff[prev*FatEntrySize:(prev+1)*FatEntrySize], uint16(dup))
+		}
+		copied = append(copied, dup)
+	}
+	if shared == 0 {
+		dtBlkOffset := RootEntryFilenameSize + RootEntrySizeFieldSize
+		d.byteOrder().PutUint16(entry[dtBlkOffset:dtBlkOffset+RootEntryStartBlockSize], uint16(copied[0]))
+		if found {
+			at := int64(d.sizeTableInd()*BlockSize + i/RootEntrySize*SizeRecordSize)
+			if _, err := d.fd.WriteAt(d.encodeSizeRecord(copied[0], recorded), at); err != nil {
+				return err
+			}
+		}
+	} else {
+		prev := blocks[shared-1]
+		d.byteOrder().PutUint16(fatBuff[prev*FatEntrySize:(prev+1)*FatEntrySize], uint16(copied[0]))
+	}
+	return nil
+}
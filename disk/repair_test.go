@@ -0,0 +1,118 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDisk_Repair(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("b"), 3*BlockSize)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("a.txt", make([]byte, 2*BlockSize))
+	d.WriteFile("b.txt", tData)
+	d.WriteFile("c.txt", make([]byte, 2*BlockSize))
+	fatBuff, _ := d.readFat()
+	rootBuff, _ := d.readRootDir()
+	chain := func(name string) []int {
+		blocks, _ := d.chainBlocks(fatBuff, d.entryStartBlock(rootBuff[d.findRootEntry(rootBuff, name):]))
+		return blocks
+	}
+	aBlocks, bBlocks, cBlocks := chain("a.txt"), chain("b.txt"), chain("c.txt")
+	link := func(block, next int) {
+		d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:], uint16(next))
+	}
+	link(aBlocks[1], bBlocks[1])
+	link(cBlocks[1], cBlocks[0])
+	link(50, 51)
+	link(51, FatEoc)
+	d.WriteBlock(1, fatBuff[:BlockSize])
+	problems, _ := d.Check()
+	// Test
+	t.Run("selective", func(t *testing.T) {
+		if err := d.Repair(problems, RepairOptions{FreeOrphans: true}); err != nil {
+			t.Error(err)
+		}
+		remaining, _ := d.Check()
+		if !reflect.DeepEqual(remaining, problems[:2]) {
+			t.Errorf("Expected only the orphan repaired, Got %v", remaining)
+		}
+	})
+	t.Run("all", func(t *testing.T) {
+		opts := RepairOptions{TruncateBadChains: true, SplitCrossLinks: true, FreeOrphans: true, RecomputeFree: true}
+		if err := d.Repair(problems, opts); err != nil {
+			t.Error(err)
+		}
+		if remaining, _ := d.Check(); len(remaining) != 0 {
+			t.Errorf("Expected no problems after repair, Got %v", remaining)
+		}
+		if ok, err := d.VerifyFile("b.txt", tData); !ok {
+			t.Errorf("Expected b.txt contents kept by its copy, Got %v", err)
+		}
+		// a.txt keeps the shared tail, c.txt ends where its loop began
+		if n, _ := d.BlockCountOf("a.txt"); n != 4 {
+			t.Errorf("Expected a.txt to keep 4 blocks, Got %v", n)
+		}
+		if n, _ := d.BlockCountOf("c.txt"); n != 2 {
+			t.Errorf("Expected c.txt truncated to 2 blocks, Got %v", n)
+		}
+		free, _ := d.FreeBlocks()
+		if recount, _ := d.RecomputeFree(); free != recount || free != tBlockCt-9 {
+			t.Errorf("Expected %v free blocks, Got %v (recounted %v)", tBlockCt-9, free, recount)
+		}
+	})
+	t.Run("open files", func(t *testing.T) {
+		f, _ := d.Open("c.txt")
+		err := d.Repair([]Problem{{ProblemBadChain, "c.txt", "", cBlocks[0]}}, RepairOptions{TruncateBadChains: true})
+		if err == nil {
+			t.Errorf("Expected FileAlreadyInUseError, Got nil")
+		}
+		f.Close()
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_ReclaimLeaked(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", make([]byte, 2*BlockSize))
+	d.Reserve("pending.txt")
+	free, _ := d.FreeBlocks()
+	fatBuff, _ := d.readFat()
+	link := func(block, next int) {
+		d.byteOrder().PutUint16(fatBuff[block*FatEntrySize:], uint16(next))
+	}
+	link(40, FatEoc)
+	link(50, 51)
+	link(51, FatEoc)
+	d.WriteBlock(1, fatBuff[:BlockSize])
+	// Test
+	n, err := d.ReclaimLeaked()
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 blocks reclaimed, Got %v", n)
+	}
+	if got, _ := d.FreeBlocks(); got != free {
+		t.Errorf("Expected %v free blocks, Got %v", free, got)
+	}
+	if ok, err := d.VerifyFile("test.txt", make([]byte, 2*BlockSize)); !ok {
+		t.Errorf("Expected test.txt left intact, Got %v", err)
+	}
+	if problems, _ := d.Check(); len(problems) != 0 {
+		t.Errorf("Expected no problems, Got %v", problems)
+	}
+	if n, _ = d.ReclaimLeaked(); n != 0 {
+		t.Errorf("Expected nothing left to reclaim, Got %v", n)
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
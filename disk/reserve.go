@@ -0,0 +1,70 @@
+package disk
+
+// Name reserved by Reserve, holding its pending file open for writing.
+// The contents written through it become visible under the name on Commit.
+type Handle struct {
+	File // pending file
+}
+
+// Reserves a filename by creating its root entry and FAT chain marked
+// pending. The pending file can be written through the handle, but stays
+// out of Entries and can't be opened until Commit. Abort frees it again.
+// The name can't be taken by another file meanwhile. A pending file left
+// by a crash stays hidden, but can still be removed by name.
+// Returns: (handle on the pending file, any error that occurred)
+// Scope: exported
+func (d *Disk) Reserve(filename string) (Handle, error) {
+	file, err := d.create(filename, AttrPending)
+	if err != nil {
+		return Handle{}, err
+	}
+	return Handle{file}, nil
+}
+
+// Publishes a reserved name, clearing its pending mark, and closes the
+// handle
+// Scope: exported
+func (d *Disk) Commit(h Handle) error {
+	if err := d.checkPending(h); err != nil {
+		return err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return err
+	}
+	entry := rootBuff[h.entry*RootEntrySize : (h.entry+1)*RootEntrySize]
+	entry[RootEntryAttrOffset] &^= AttrPending
+	d.touchEntry(entry)
+	if err = d.writeMeta(metaWrite{d.rootDirInd, rootBuff}); err != nil {
+		return err
+	}
+	return h.Close()
+}
+
+// Rolls back a reservation, closing the handle and freeing the pending
+// file's root entry and FAT chain
+// Scope: exported
+func (d *Disk) Abort(h Handle) error {
+	if err := d.checkPending(h); err != nil {
+		return err
+	}
+	if err := h.Close(); err != nil {
+		return err
+	}
+	return d.Remove(h.name)
+}
+
+// Ensures a handle is still an open reservation on this disk
+// Scope: internal
+func (d *Disk) checkPending(h Handle) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if h.disk != d || !d.checkIsOpen(h.name) {
+		return FileNotOpenError{h.name}
+	}
+	if h.attr&AttrPending == 0 {
+		return CustomError{"File is not pending"}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package disk
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDisk_Reserve(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	// Test
+	h, err := d.Reserve(tFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte("computed"))
+	if entries, _ := d.Entries(); len(entries) != 0 {
+		t.Errorf("Expected pending file hidden from Entries, Got %v", entries)
+	}
+	if _, err = d.Create(tFilename); err == nil {
+		t.Errorf("Expected reserved name to be taken, Got nil")
+	}
+	if problems, _ := d.Check(); len(problems) != 0 {
+		t.Errorf("Expected pending chain not reported as orphaned, Got %v", problems)
+	}
+	if err = d.Commit(h); err != nil {
+		t.Error(err)
+	}
+	if data, err := d.ReadFile(tFilename); err != nil || string(data) != "computed" {
+		t.Errorf("Expected committed contents %q, Got %q, %v", "computed", data, err)
+	}
+	if err = d.Commit(h); err == nil {
+		t.Errorf("Expected FileNotOpenError committing twice, Got nil")
+	}
+	t.Run("Abort", func(t *testing.T) {
+		// Setup
+		free, _ := d.FreeBlocks()
+		h, _ := d.Reserve("aborted.txt")
+		h.Write(make([]byte, 3*BlockSize))
+		// Test
+		if err := d.Abort(h); err != nil {
+			t.Error(err)
+		}
+		if _, err := d.Open("aborted.txt"); err == nil {
+			t.Errorf("Expected aborted file gone")
+		}
+		if after, _ := d.RecomputeFree(); after != free {
+			t.Errorf("Expected %v free blocks after abort, Got %v", free, after)
+		}
+	})
+	t.Run("crash", func(t *testing.T) {
+		// Setup
+		d.Reserve("left.txt")
+		d.Close()
+		d, _ := Mount(tDiskFilename)
+		// Test
+		if _, err := d.Open("left.txt"); err == nil {
+			t.Errorf("Expected leftover pending file hidden from Open")
+		}
+		if err := d.Remove("left.txt"); err != nil {
+			t.Errorf("Expected leftover pending file removable, Got %v", err)
+		}
+		// Teardown
+		d.Close()
+	})
+	// Teardown
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,187 @@
+package disk
+
+import (
+	"bytes"
+	"hash/crc32"
+)
+
+const (
+	SizeTableVersion     = 3
+	SizeRecordSizeOffset = 0x00
+	SizeRecordSizeSize   = 4
+	SizeRecordCrcOffset  = 0x04
+	SizeRecordCrcSize    = 4
+	SizeRecordSize       = 8
+)
+
+// Reports how many blocks after the journal hold the size table. The
+// table keeps a size record per root entry, so a size can be recovered
+// exactly if the root entry's is left stale, and past the size records
+// the IVs of encrypted files. It lives outside the data blocks, which keep
+// their whole BlockSize for data. Disks older than SizeTableVersion have
+// none.
+// Scope: internal
+func (d *Disk) sizeTableBlockCt() int {
+	if d.version < SizeTableVersion {
+		return 0
+	}
+	return 1
+}
+
+// Reports the absolute index of the size table block
+// Scope: internal
+func (d *Disk) sizeTableInd() int {
+	return d.dataStartInd + d.dataBlockCt + d.journalBlockCt
+}
+
+// Reports whether the file's size is kept in the size table. Compressed
+// files, whose size is the uncompressed length, are left out.
+// Scope: internal
+func (f *File) keepsSizeRecord() bool {
+	return f.disk.sizeTableBlockCt() > 0 && f.attr&AttrCompressed == 0
+}
+
+// Computes the chain length needed to hold size bytes
+// Scope: internal
+func blocksFor(size int) int {
+	return (size + BlockSize - 1) / BlockSize
+}
+
+// Writes the size record for the file's root entry. Writes store it ahead
+// of the root entry, so a crash in between leaves the new size recoverable.
+// Scope: internal
+func (f *File) storeSizeRecord(size int) error {
+	if !f.keepsSizeRecord() {
+		return nil
+	}
+	d := f.disk
+	record := d.encodeSizeRecord(f.desc, size)
+	_, err := d.fd.WriteAt(record, int64(d.sizeTableInd()*BlockSize+f.entry*SizeRecordSize))
+	return err
+}
+
+// Reads the whole size table block
+// Returns: (the block, or nil on disks without a size table, any error)
+// Scope: internal
+func (d *Disk) readSizeTable() ([]byte, error) {
+	if d.sizeTableBlockCt() == 0 {
+		return nil, nil
+	}
+	table := make([]byte, BlockSize)
+	if _, err := d.fd.ReadAt(table, int64(d.sizeTableInd()*BlockSize)); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// Copies a size table block with the size records and IV slots of its
+// first entryCt entries emptied
+// Scope: internal
+func clearSlots(table []byte, entryCt int) []byte {
+	cleared := append([]byte(nil), table...)
+	copy(cleared[:entryCt*SizeRecordSize], make([]byte, entryCt*SizeRecordSize))
+	copy(cleared[IvRecordOffset:IvRecordOffset+entryCt*IvRecordSize], make([]byte, entryCt*IvRecordSize))
+	return cleared
+}
+
+// Copies the size record and IV slot of the root entry at index from in
+// the size table block src to those of the entry at index to in dst. The
+// checksums don't cover the index, so both stay valid where they land.
+// Scope: internal
+func moveSlot(dst, src []byte, from, to int) {
+	copy(dst[to*SizeRecordSize:(to+1)*SizeRecordSize], src[from*SizeRecordSize:(from+1)*SizeRecordSize])
+	ivFrom, ivTo := IvRecordOffset+from*IvRecordSize, IvRecordOffset+to*IvRecordSize
+	copy(dst[ivTo:ivTo+IvRecordSize], src[ivFrom:ivFrom+IvRecordSize])
+}
+
+// Encodes a size record for the chain beginning at start. The checksum
+// covers the start block too, so a record left by a removed file whose
+// entry was reused isn't taken for its successor's.
+// Scope: internal
+func (d *Disk) encodeSizeRecord(start, size int) []byte {
+	record := make([]byte, SizeRecordSize)
+	d.byteOrder().PutUint32(record[SizeRecordSizeOffset:SizeRecordSizeOffset+SizeRecordSizeSize], uint32(size))
+	d.byteOrder().PutUint32(record[SizeRecordCrcOffset:SizeRecordCrcOffset+SizeRecordCrcSize], d.sizeRecordCrc(start, record))
+	return record
+}
+
+// Reads the size record of the root entry at index for the given chain
+// Returns: (recorded size, whether a valid record was found, any error)
+// Scope: internal
+func (d *Disk) readSizeRecord(index int, blocks []int) (int, bool, error) {
+	record := make([]byte, SizeRecordSize)
+	if _, err := d.fd.ReadAt(record, int64(d.sizeTableInd()*BlockSize+index*SizeRecordSize)); err != nil {
+		return 0, false, err
+	}
+	size, found := d.decodeSizeRecord(record, blocks)
+	return size, found, nil
+}
+
+// Checks a size record against the chain it should belong to
+// Returns: (recorded size, whether the record is valid for the chain)
+// Scope: internal
+func (d *Disk) decodeSizeRecord(record []byte, blocks []int) (int, bool) {
+	stored := d.byteOrder().Uint32(record[SizeRecordCrcOffset : SizeRecordCrcOffset+SizeRecordCrcSize])
+	if stored != d.sizeRecordCrc(blocks[0], record) {
+		return 0, false
+	}
+	size := int(d.byteOrder().Uint32(record[SizeRecordSizeOffset : SizeRecordSizeOffset+SizeRecordSizeSize]))
+	// a size the chain can't hold is from some other version of the file
+	if size > len(blocks)*BlockSize {
+		return 0, false
+	}
+	return size, true
+}
+
+// Checks the size record and IV slot of the root entry at index within a
+// size table block against the entry. A slot is stale if it is set while
+// the entry is empty or keeps nothing there, or if it doesn't hold what
+// the entry needs: a record that checks out against a good chain, and an
+// IV for an encrypted file. An unset size record is never stale, since
+// files may go without one.
+// Returns: (whether the size record is stale, whether the IV slot is)
+// Scope: internal
+func (d *Disk) staleSlots(table, fatBuff, rootBuff []byte, index int) (bool, bool) {
+	entry := rootBuff[index*RootEntrySize : (index+1)*RootEntrySize]
+	record := table[index*SizeRecordSize : (index+1)*SizeRecordSize]
+	ivRecord := table[IvRecordOffset+index*IvRecordSize : IvRecordOffset+(index+1)*IvRecordSize]
+	recordSet := !bytes.Equal(record, make([]byte, SizeRecordSize))
+	ivSet := !bytes.Equal(ivRecord, make([]byte, IvRecordSize))
+	if entry[0] == 0 {
+		return recordSet, ivSet
+	}
+	attr := entry[RootEntryAttrOffset]
+	staleRecord := recordSet && attr&AttrCompressed != 0
+	if recordSet && !staleRecord {
+		// a bad chain is reported by itself
+		if blocks, ok := d.walkChain(fatBuff, d.entryStartBlock(entry)); ok {
+			_, found := d.decodeSizeRecord(record, blocks)
+			staleRecord = !found
+		}
+	}
+	_, ivFound := d.decodeIv(ivRecord)
+	staleIv := ivSet && attr&AttrEncrypted == 0 || attr&AttrEncrypted != 0 && !ivFound
+	return staleRecord, staleIv
+}
+
+// Empties the size record and IV slot of the root entry at index
+// Scope: internal
+func (d *Disk) clearSlot(index int) error {
+	if d.sizeTableBlockCt() == 0 {
+		return nil
+	}
+	if _, err := d.fd.WriteAt(make([]byte, SizeRecordSize), int64(d.sizeTableInd()*BlockSize+index*SizeRecordSize)); err != nil {
+		return err
+	}
+	return d.storeIv(index, nil)
+}
+
+// Checksums a size record's size field together with the chain's start
+// block
+// Scope: internal
+func (d *Disk) sizeRecordCrc(start int, record []byte) uint32 {
+	buff := make([]byte, SizeRecordSizeSize+2)
+	copy(buff, record[SizeRecordSizeOffset:SizeRecordSizeOffset+SizeRecordSizeSize])
+	d.byteOrder().PutUint16(buff[SizeRecordSizeSize:], uint16(start))
+	return crc32.ChecksumIEEE(buff)
+}
@@ -0,0 +1,87 @@
+package disk
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFile_SizeRecord(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	d, _ := New(tDiskFilename, tBlockCt)
+	f, _ := d.Create(tFilename)
+	// Test
+	t.Run("readSizeRecord", func(t *testing.T) {
+		fatBuff, _ := d.readFat()
+		blocks, _ := d.chainBlocks(fatBuff, f.desc)
+		at := int64(d.sizeTableInd()*BlockSize + f.entry*SizeRecordSize)
+		d.fd.WriteAt(d.encodeSizeRecord(f.desc, 42), at)
+		if size, found, _ := d.readSizeRecord(f.entry, blocks); !found || size != 42 {
+			t.Errorf("Expected recorded size 42, Got %v (found %v)", size, found)
+		}
+		// a record written for another chain doesn't match
+		d.fd.WriteAt(d.encodeSizeRecord(f.desc+1, 42), at)
+		if _, found, _ := d.readSizeRecord(f.entry, blocks); found {
+			t.Errorf("Expected record from another chain to be rejected")
+		}
+		// nor does a size the chain can't hold
+		d.fd.WriteAt(d.encodeSizeRecord(f.desc, BlockSize+1), at)
+		if _, found, _ := d.readSizeRecord(f.entry, blocks); found {
+			t.Errorf("Expected record past the chain to be rejected")
+		}
+	})
+	f.Write(make([]byte, 3*BlockSize))
+	f.Truncate(BlockSize + 7)
+	fatBuff, _ := d.readFat()
+	blocks, _ := d.chainBlocks(fatBuff, f.desc)
+	if size, found, _ := d.readSizeRecord(f.entry, blocks); !found || size != BlockSize+7 {
+		t.Errorf("Expected recorded size %v after Truncate, Got %v (found %v)", BlockSize+7, size, found)
+	}
+	// the data blocks keep their whole size for data
+	g, _ := d.Create("full.txt")
+	g.Write(make([]byte, BlockSize))
+	if blocks, _ := g.BlockCount(); blocks != 1 {
+		t.Errorf("Expected 1 block for %v bytes, Got %v", BlockSize, blocks)
+	}
+	g.Close()
+	// disks from before the size table have no block for it
+	if d.sizeTableBlockCt() != 1 {
+		t.Errorf("Expected 1 size table block, Got %v", d.sizeTableBlockCt())
+	}
+	d.version = SizeTableVersion - 1
+	if d.sizeTableBlockCt() != 0 {
+		t.Errorf("Expected no size table for version %v", SizeTableVersion-1)
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_RestoreSizeTable(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", make([]byte, BlockSize+100))
+	id, _ := d.Snapshot()
+	// growing within the last block keeps the start block, and with it
+	// the record's checksum
+	f, _ := d.Open("test.txt")
+	f.WriteAt(make([]byte, 100), BlockSize+100)
+	f.Close()
+	// Test
+	if err := d.Restore(id); err != nil {
+		t.Fatal(err)
+	}
+	// a record left at the newer size would undo the restore
+	if err := d.RepairSizes(); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile("test.txt"); len(got) != BlockSize+100 {
+		t.Errorf("Expected the restored size %v, Got %v", BlockSize+100, len(got))
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,214 @@
+package disk
+
+// Identifies a snapshot taken with Snapshot
+type SnapshotID int
+
+// Saved FAT, root directory and size table, and with them every data
+// block they refer to, which writes leave untouched while the snapshot is
+// kept
+type snapshot struct {
+	id    SnapshotID // identifier handed to the caller
+	fat   []byte     // FAT as of the snapshot
+	root  []byte     // root directory as of the snapshot
+	table []byte     // size table block as of the snapshot, nil if none
+}
+
+// Takes a snapshot of the filesystem's current state, which Restore can
+// roll back to later. Only the FAT, root directory and size table are
+// copied: from then on, data blocks the snapshot refers to are never
+// overwritten or reused, so writes to them go to fresh copies instead, and
+// blocks files release stay held until the snapshot is dropped. Snapshots
+// live in memory and are lost when the disk is closed. Data still buffered
+// by open handles, e.g. compressed files not yet stored, isn't part of the
+// snapshot.
+// Returns: (identifier of the snapshot, any error encountered)
+// Scope: exported
+func (d *Disk) Snapshot() (SnapshotID, error) {
+	if d.closed {
+		return 0, DiskClosedError{}
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	rootBuff, err := d.readRootDir()
+	if err != nil {
+		return 0, err
+	}
+	table, err := d.readSizeTable()
+	if err != nil {
+		return 0, err
+	}
+	d.snapshotCt++
+	d.snapshots = append(d.snapshots, snapshot{SnapshotID(d.snapshotCt), fatBuff, rootBuff, table})
+	// held blocks no longer count as free
+	d.freeValid, d.mapValid = false, false
+	return SnapshotID(d.snapshotCt), nil
+}
+
+// Rolls the FAT, root directory and size table back to a snapshot,
+// discarding every change made since. The snapshot is kept, so it can be
+// restored again, but snapshots taken after it are dropped. No file may be
+// open.
+// Scope: exported
+func (d *Disk) Restore(id SnapshotID) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	for name, open := range d.open {
+		if open {
+			return FileAlreadyInUseError{name}
+		}
+	}
+	i := d.findSnapshot(id)
+	if i < 0 {
+		return SnapshotNotFoundError{int(id)}
+	}
+	s := d.snapshots[i]
+	// the snapshot's buffers must stay unchanged for later restores
+	fatBuff := append([]byte(nil), s.fat...)
+	rootBuff := append([]byte(nil), s.root...)
+	if err := d.writeMeta(metaWrite{1, fatBuff}, metaWrite{d.rootDirInd, rootBuff}); err != nil {
+		return err
+	}
+	// the journal has no room for the size table beside the FAT and root
+	// directory, so its records and IVs are put back after them
+	if s.table != nil {
+		if _, err := d.fd.WriteAt(s.table, int64(d.sizeTableInd()*BlockSize)); err != nil {
+			return err
+		}
+	}
+	d.snapshots = d.snapshots[:i+1]
+	d.freeValid, d.mapValid = false, false
+	return nil
+}
+
+// Drops a snapshot, releasing the blocks only it was holding
+// Scope: exported
+func (d *Disk) DropSnapshot(id SnapshotID) error {
+	if d.closed {
+		return DiskClosedError{}
+	}
+	i := d.findSnapshot(id)
+	if i < 0 {
+		return SnapshotNotFoundError{int(id)}
+	}
+	d.snapshots = append(d.snapshots[:i], d.snapshots[i+1:]...)
+	d.freeValid, d.mapValid = false, false
+	return nil
+}
+
+// Locates a kept snapshot
+// Returns: position of the snapshot, or -1 if it isn't kept
+// Scope: internal
+func (d *Disk) findSnapshot(id SnapshotID) int {
+	for i, s := range d.snapshots {
+		if s.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reports whether a kept snapshot refers to the data block
+// Scope: internal
+func (d *Disk) heldBlock(block int) bool {
+	for _, s := range d.snapshots {
+		if d.byteOrder().Uint16(s.fat[block*FatEntrySize:(block+1)*FatEntrySize]) != FatEntryUnused {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the data block can be allocated: a real block, unused
+// in the FAT and not held by a snapshot
+// Scope: internal
+func (d *Disk) blockFree(fatBuff []byte, block int) bool {
+	if !d.validBlock(block) {
+		return false
+	}
+	return d.byteOrder().Uint16(fatBuff[block*FatEntrySize:(block+1)*FatEntrySize]) == FatEntryUnused && !d.heldBlock(block)
+}
+
+// Moves the blocks at chain positions [from, to) that a snapshot holds to
+// fresh copies, relinking the chain to them and storing the FAT, so they
+// can be written without changing the snapshot. The check for free space
+// leaves room for reserve more blocks, so a write that also grows the
+// chain fails before copying anything.
+// Returns: (blocks of the relinked chain, any error encountered)
+// Scope: internal
+func (f *File) unshareBlocks(fatBuff []byte, blocks []int, from, to, reserve int) ([]int, error) {
+	d := f.disk
+	if len(d.snapshots) == 0 {
+		return blocks, nil
+	}
+	if to > len(blocks) {
+		to = len(blocks)
+	}
+	var held []int
+	for i := from; i < to; i++ {
+		if d.heldBlock(blocks[i]) {
+			held = append(held, i)
+		}
+	}
+	if len(held) == 0 {
+		return blocks, nil
+	}
+	free := 0
+	for block := 1; block < d.dataBlockCt; block++ {
+		if d.blockFree(fatBuff, block) {
+			free++
+		}
+	}
+	if free < len(held)+reserve {
+		return blocks, FullDiskError{}
+	}
+	blocks = append([]int(nil), blocks...)
+	data := make([]byte, BlockSize)
+	for _, i := range held {
+		old := blocks[i]
+		if _, err := d.fd.ReadAt(data, int64((d.dataStartInd+old)*BlockSize)); err != nil {
+			return blocks, err
+		}
+		dup, err := d.allocBlock(fatBuff)
+		if err != nil {
+			return blocks, err
+		}
+		if _, err = d.fd.WriteAt(data, int64((d.dataStartInd+dup)*BlockSize)); err != nil {
+			return blocks, err
+		}
+		// the copy takes over the old block's place in the chain
+		oldEntry := fatBuff[old*FatEntrySize : (old+1)*FatEntrySize]
+		copy(fatBuff[dup*FatEntrySize:(dup+1)*FatEntrySize], oldEntry)
+		d.byteOrder().PutUint16(oldEntry, FatEntryUnused)
+		if i > 0 {
+			prev := blocks[i-1]
+			d.byteOrder().PutUint16(fatBuff[prev*FatEntrySize:(prev+1)*FatEntrySize], uint16(dup))
+		}
+		blocks[i] = dup
+	}
+	writes := []metaWrite{{1, fatBuff}}
+	if held[0] == 0 {
+		rootBuff, err := d.readRootDir()
+		if err != nil {
+			return blocks, err
+		}
+		entry := rootBuff[f.entry*RootEntrySize : (f.entry+1)*RootEntrySize]
+		dtBlkOffset := RootEntryFilenameSize + RootEntrySizeFieldSize
+		d.byteOrder().PutUint16(entry[dtBlkOffset:dtBlkOffset+RootEntryStartBlockSize], uint16(blocks[0]))
+		writes = append(writes, metaWrite{d.rootDirInd, rootBuff})
+	}
+	if err := d.writeMeta(writes...); err != nil {
+		return blocks, err
+	}
+	f.cursor = nil
+	if held[0] == 0 {
+		f.desc = blocks[0]
+		// size records are checksummed with the start block
+		if err := f.storeSizeRecord(f.size); err != nil {
+			return blocks, err
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,99 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDisk_Snapshot(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("abcdefgh"), BlockSize/4)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", tData)
+	free, _ := d.FreeBlocks()
+	// Test
+	id, err := d.Snapshot()
+	if err != nil {
+		t.Error(err)
+	}
+	f, _ := d.Open("test.txt")
+	if _, err = f.WriteAt([]byte("XY"), 0); err != nil {
+		t.Error(err)
+	}
+	f.Close()
+	d.WriteFile("new.txt", []byte("new"))
+	// a copy of the first block, plus new.txt's block
+	if got, _ := d.FreeBlocks(); got != free-2 {
+		t.Errorf("Expected %v free blocks, Got %v", free-2, got)
+	}
+	// only the copies are released, the snapshot holds the rest
+	d.Remove("test.txt")
+	if got, _ := d.FreeBlocks(); got != free-1 {
+		t.Errorf("Expected %v free blocks, Got %v", free-1, got)
+	}
+	if err = d.Restore(id); err != nil {
+		t.Error(err)
+	}
+	if got, err := d.ReadFile("test.txt"); err != nil || !bytes.Equal(got, tData) {
+		t.Errorf("Expected the snapshot's contents, Got %q, %v", got, err)
+	}
+	if _, err = d.Open("new.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError, Got nil")
+	}
+	if got, _ := d.FreeBlocks(); got != free {
+		t.Errorf("Expected %v free blocks, Got %v", free, got)
+	}
+	// the snapshot is kept after restoring
+	d.WriteFile("test.txt", []byte("short"))
+	if err = d.Restore(id); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile("test.txt"); !bytes.Equal(got, tData) {
+		t.Errorf("Expected the snapshot's contents, Got %q", got)
+	}
+	// dropping it releases its blocks
+	d.Remove("test.txt")
+	if err = d.DropSnapshot(id); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.FreeBlocks(); got != free+2 {
+		t.Errorf("Expected %v free blocks, Got %v", free+2, got)
+	}
+	if err = d.Restore(id); err == nil {
+		t.Errorf("Expected SnapshotNotFoundError, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_Restore(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", []byte("first"))
+	first, _ := d.Snapshot()
+	d.WriteFile("test.txt", []byte("second"))
+	second, _ := d.Snapshot()
+	// Test
+	f, _ := d.Open("test.txt")
+	if err := d.Restore(first); err == nil {
+		t.Errorf("Expected FileAlreadyInUseError, Got nil")
+	}
+	f.Close()
+	if err := d.Restore(first); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile("test.txt"); string(got) != "first" {
+		t.Errorf("Expected first, Got %q", got)
+	}
+	// later snapshots are dropped
+	if err := d.Restore(second); err == nil {
+		t.Errorf("Expected SnapshotNotFoundError, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,252 @@
+package disk
+
+import (
+	"bufio"
+	"io"
+)
+
+// Opens the file with given filename for reading from its start. Closing
+// the reader releases the file, so it can be handed straight to io.Copy
+// or similar and closed afterwards.
+// Returns: (reader over the file contents, any error that occurred)
+// Scope: exported
+func (d *Disk) OpenReader(filename string) (io.ReadCloser, error) {
+	file, err := d.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Writes the file's contents from the current offset to w, advancing the
+// offset past what w accepted. This makes File an io.WriterTo, so io.Copy
+// from a file or an OpenReader reader streams through here. The FAT is
+// read once and data moves through a single block-sized buffer, so memory
+// use doesn't grow with the file; compressed files are the exception, as
+// they're decompressed whole on first read.
+// Returns: (number of bytes written, any error encountered)
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if err := f.checkDisk(); err != nil {
+		return 0, err
+	}
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return 0, err
+	}
+	buff := make([]byte, BlockSize)
+	var written int64
+	for f.offset < f.size {
+		n, err := f.readInBlock(fatBuff, f.offset, buff)
+		if err != nil {
+			return written, err
+		}
+		m, err := w.Write(buff[:n])
+		written += int64(m)
+		f.offset += m
+		if err != nil {
+			return written, err
+		}
+		if m < n {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Reads the file's contents from offset, which must be below the size, to
+// the end of the block holding it or the end of the file, whichever comes
+// first, into buff. buff must hold a block; fatBuff is read once by the
+// caller for a whole pass over the file.
+// Returns: (number of bytes read, any error encountered)
+// Scope: internal
+func (f *File) readInBlock(fatBuff []byte, offset int, buff []byte) (int, error) {
+	within := offset % BlockSize
+	n := BlockSize - within
+	if n > f.size-offset {
+		n = f.size - offset
+	}
+	if f.attr&AttrCompressed != 0 {
+		if _, err := f.readCompressed(buff[:n], offset); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	block, err := f.seekBlock(fatBuff, offset/BlockSize)
+	if err != nil {
+		return 0, err
+	}
+	d := f.disk
+	if _, err = d.fd.ReadAt(buff[:n], int64((d.dataStartInd+block)*BlockSize+within)); err != nil {
+		return 0, err
+	}
+	f.crypt(buff[:n], offset)
+	return n, nil
+}
+
+// Wraps the file in a block-sized bufio.Reader reading on from the current
+// offset, for line-oriented reads with ReadString, bufio.Scanner and the
+// like. The reader fetches ahead of what it returns, so the file's offset
+// runs up to a block past the data consumed from it; don't mix further
+// Read or Write calls on the file with use of the reader. ReadAt isn't
+// affected.
+// Returns: buffered reader over the file
+func (f *File) BufReader() *bufio.Reader {
+	return bufio.NewReaderSize(f, BlockSize)
+}
+
+// Copies n bytes from the file's current offset to dst's, advancing both,
+// like io.CopyN but a source block at a time through one block-sized
+// buffer, growing dst as needed. Reaching the end of the file first stops
+// the copy with io.EOF; as with io.CopyN, the count equals n only if the
+// error is nil. If writing dst fails, e.g. with a FullDiskError part way
+// through, the copy is undone as far as it can be: dst is truncated back
+// to its size before the call, releasing every block the copy added, both
+// offsets are put back and the count is 0. Bytes that overwrote existing
+// contents of dst stay overwritten. dst must be a different handle.
+// Returns: (number of bytes copied, any error encountered)
+func (f *File) CopyTo(dst *File, n int) (int, error) {
+	if dst == f {
+		return 0, CustomError{"Source and destination are the same handle"}
+	}
+	if n < 0 {
+		return 0, CustomError{"Negative count"}
+	}
+	srcOffset, dstOffset, dstSize := f.offset, dst.offset, dst.size
+	buff := make([]byte, BlockSize)
+	copied := 0
+	for copied < n {
+		// chunks end on source block boundaries, so each read stays in
+		// one block
+		chunk := buff[:BlockSize-f.offset%BlockSize]
+		if len(chunk) > n-copied {
+			chunk = chunk[:n-copied]
+		}
+		read, err := f.Read(chunk)
+		if read > 0 {
+			if _, werr := dst.Write(chunk[:read]); werr != nil {
+				f.offset = srcOffset
+				dst.rollback(dstSize, dstOffset)
+				return 0, werr
+			}
+			copied += read
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// Writes everything read from r at the current offset, a block at a time,
+// advancing the offset; io.Copy to a file goes through it. Reaching the
+// end of r isn't an error. If reading or writing fails, e.g. with a
+// FullDiskError part way through, the file is truncated back to its size
+// before the call, releasing every block the call added, the offset is put
+// back and the count is 0. Bytes that overwrote existing contents stay
+// overwritten.
+// Returns: (number of bytes written, any error encountered)
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	size, offset := f.size, f.offset
+	buff := make([]byte, BlockSize)
+	var written int64
+	for {
+		n, err := io.ReadFull(r, buff)
+		if n > 0 {
+			if _, werr := f.Write(buff[:n]); werr != nil {
+				f.rollback(size, offset)
+				return 0, werr
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if err != nil {
+			f.rollback(size, offset)
+			return 0, err
+		}
+	}
+}
+
+// Buffers writes to an open file, storing them a block at a time
+type fileWriter struct {
+	file  File   // file being written, open until Close
+	buff  []byte // written data not yet stored
+	stale bool   // set once Close has been called
+}
+
+// Opens the file with given filename for writing, creating it or
+// discarding its contents. Writes are buffered and stored in whole blocks;
+// Close stores the remainder and releases the file, reporting any error
+// from storing buffered data.
+// Returns: (writer replacing the file contents, any error that occurred)
+// Scope: exported
+func (d *Disk) OpenWriter(filename string) (io.WriteCloser, error) {
+	file, _, err := d.openTruncated(filename)
+	if err != nil {
+		return nil, err
+	}
+	return d.newWriter(file), nil
+}
+
+// Wraps an open file in a buffering writer
+// Scope: internal
+func (d *Disk) newWriter(file File) *fileWriter {
+	w := &fileWriter{file: file}
+	// CloseAll stores the buffered remainder through the writer
+	d.closers[file.name] = w.Close
+	return w
+}
+
+func (w *fileWriter) Write(data []byte) (int, error) {
+	if w.stale {
+		return 0, FileNotOpenError{w.file.name}
+	}
+	w.buff = append(w.buff, data...)
+	if len(w.buff) >= BlockSize {
+		if err := w.flush(len(w.buff) - len(w.buff)%BlockSize); err != nil {
+			// flush puts the file back, so none of data was stored
+			w.buff = w.buff[:len(w.buff)-len(data)]
+			return 0, err
+		}
+	}
+	if len(w.buff) > 0 {
+		w.file.disk.pool.touch(w)
+	} else {
+		w.file.disk.pool.release(w)
+	}
+	return len(data), nil
+}
+
+func (w *fileWriter) Close() error {
+	if w.stale {
+		return FileNotOpenError{w.file.name}
+	}
+	w.stale = true
+	w.file.disk.pool.release(w)
+	// the file is released even if storing the remainder fails
+	err := w.flush(len(w.buff))
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Stores the first n buffered bytes, keeping the rest buffered. A write
+// failing part way, e.g. on a device error, may have stored some of them,
+// so the file's size and offset are put back and all n stay buffered, to
+// be sent again from the same place.
+// Scope: internal
+func (w *fileWriter) flush(n int) error {
+	if n == 0 {
+		return nil
+	}
+	size, offset := w.file.size, w.file.offset
+	if _, err := w.file.Write(w.buff[:n]); err != nil {
+		w.file.rollback(size, offset)
+		return err
+	}
+	w.buff = append(w.buff[:0], w.buff[n:]...)
+	return nil
+}
@@ -0,0 +1,354 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDisk_OpenReader(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("0123456789"), BlockSize/4)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, tData)
+	// Test
+	r, err := d.OpenReader(tFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Open(tFilename); err == nil {
+		t.Errorf("Expected FileAlreadyInUseError while reader open, Got nil")
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes read back, Got %v", len(tData), len(got))
+	}
+	if err = r.Close(); err != nil {
+		t.Error(err)
+	}
+	if d.checkIsOpen(tFilename) {
+		t.Errorf("Expected file released after Close")
+	}
+	if _, err = d.OpenReader("missing.txt"); err == nil {
+		t.Errorf("Expected FileNotFoundError, Got nil")
+	}
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_BufReader(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tLines := strings.Repeat("a line of text\n", BlockSize/8)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, []byte(tLines))
+	f, _ := d.Open(tFilename)
+	// Test
+	scanner := bufio.NewScanner(f.BufReader())
+	lines := 0
+	for scanner.Scan() {
+		if scanner.Text() != "a line of text" {
+			t.Fatalf("Expected %q, Got %q", "a line of text", scanner.Text())
+		}
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Error(err)
+	}
+	if lines != BlockSize/8 {
+		t.Errorf("Expected %v lines, Got %v", BlockSize/8, lines)
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_CopyTo(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("abcdefgh"), 5*BlockSize/16)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("src.txt", tData)
+	d.WriteFile("dst.txt", []byte("head:"))
+	src, _ := d.Open("src.txt")
+	dst, _ := d.OpenAppend("dst.txt")
+	// Test
+	src.offset = 100
+	n, err := src.CopyTo(&dst, 2*BlockSize)
+	if n != 2*BlockSize || err != nil {
+		t.Errorf("Expected %v bytes and nil, Got %v bytes and %v", 2*BlockSize, n, err)
+	}
+	if src.offset != 100+2*BlockSize || dst.offset != 5+2*BlockSize {
+		t.Errorf("Expected both offsets advanced, Got %v and %v", src.offset, dst.offset)
+	}
+	// the rest of the source, then io.EOF
+	rest := len(tData) - src.offset
+	if n, err = src.CopyTo(&dst, BlockSize); n != rest || err != io.EOF {
+		t.Errorf("Expected %v bytes and io.EOF, Got %v bytes and %v", rest, n, err)
+	}
+	dst.Close()
+	expected := append([]byte("head:"), tData[100:]...)
+	if got, _ := d.ReadFile("dst.txt"); !bytes.Equal(got, expected) {
+		t.Errorf("Expected %v bytes copied after the head, Got %v bytes", len(expected), len(got))
+	}
+	if _, err = src.CopyTo(&src, 1); err == nil {
+		t.Errorf("Expected an error copying a handle onto itself, Got nil")
+	}
+	// Teardown
+	src.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+// Reader failing every read with err
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+func TestFile_ReadFrom(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("0123456789"), 3*BlockSize/8)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", []byte("head:"))
+	f, _ := d.OpenAppend("test.txt")
+	// Test
+	n, err := f.ReadFrom(bytes.NewReader(tData))
+	if n != int64(len(tData)) || err != nil {
+		t.Errorf("Expected %v bytes and nil, Got %v bytes and %v", len(tData), n, err)
+	}
+	if f.offset != 5+len(tData) {
+		t.Errorf("Expected offset %v, Got %v", 5+len(tData), f.offset)
+	}
+	f.Close()
+	expected := append([]byte("head:"), tData...)
+	if got, _ := d.ReadFile("test.txt"); !bytes.Equal(got, expected) {
+		t.Errorf("Expected %v bytes, Got %v bytes", len(expected), len(got))
+	}
+	// a failing reader leaves the file as it was
+	f, _ = d.OpenAppend("test.txt")
+	tErr := CustomError{"broken"}
+	r := io.MultiReader(bytes.NewReader(tData), errReader{tErr})
+	if n, err = f.ReadFrom(r); n != 0 || err != tErr {
+		t.Errorf("Expected 0 bytes and the reader's error, Got %v bytes and %v", n, err)
+	}
+	if f.size != len(expected) || f.offset != len(expected) {
+		t.Errorf("Expected size and offset %v, Got %v and %v", len(expected), f.size, f.offset)
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestFile_WriteTo(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("0123456789"), 3*BlockSize/8)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", tData)
+	f, _ := d.Open("test.txt")
+	// Test
+	f.offset = 100
+	var out bytes.Buffer
+	n, err := f.WriteTo(&out)
+	if n != int64(len(tData)-100) || err != nil {
+		t.Errorf("Expected %v bytes and nil, Got %v bytes and %v", len(tData)-100, n, err)
+	}
+	if !bytes.Equal(out.Bytes(), tData[100:]) || f.offset != len(tData) {
+		t.Errorf("Expected the rest of the file written and offset at the end, Got %v bytes and offset %v", out.Len(), f.offset)
+	}
+	// io.Copy goes through WriteTo
+	f.offset = 0
+	out.Reset()
+	if _, err = io.Copy(&out, &f); err != nil || !bytes.Equal(out.Bytes(), tData) {
+		t.Errorf("Expected io.Copy to write the whole file, Got %v bytes and %v", out.Len(), err)
+	}
+	// Teardown
+	f.Close()
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+// Writes a file filling most of a larger disk in small pieces, then reads
+// it back through each streaming API with a small fixed buffer, checking
+// the contents by hash and that none of them allocates anywhere near the
+// file size.
+func TestFile_streamLarge(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 2048
+	tSize := 1900 * BlockSize
+	d, err := New(tDiskFilename, tBlockCt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := d.OpenWriter("large.bin")
+	src := rand.New(rand.NewSource(1))
+	expected := sha256.New()
+	piece := make([]byte, 512)
+	for written := 0; written < tSize; written += len(piece) {
+		src.Read(piece)
+		expected.Write(piece)
+		if _, err = w.Write(piece); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := expected.Sum(nil)
+	// measures the bytes allocated by fn, which must stay well below the
+	// file size
+	bounded := func(name string, fn func() []byte) {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		got := fn()
+		runtime.ReadMemStats(&after)
+		if !bytes.Equal(got, sum) {
+			t.Errorf("%s: Expected the contents to hash to %x, Got %x", name, sum, got)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > uint64(tSize/16) {
+			t.Errorf("%s: Expected allocations well below %v bytes, Got %v", name, tSize, alloc)
+		}
+	}
+	f, _ := d.Open("large.bin")
+	// Test
+	bounded("WriteTo", func() []byte {
+		h := sha256.New()
+		if _, err := f.WriteTo(h); err != nil {
+			t.Error(err)
+		}
+		return h.Sum(nil)
+	})
+	bounded("Hash", func() []byte {
+		h := sha256.New()
+		if err := f.Hash(h); err != nil {
+			t.Error(err)
+		}
+		return h.Sum(nil)
+	})
+	bounded("ForEachChunk", func() []byte {
+		h := sha256.New()
+		if err := f.ForEachChunk(512, func(chunk []byte) error {
+			_, err := h.Write(chunk)
+			return err
+		}); err != nil {
+			t.Error(err)
+		}
+		return h.Sum(nil)
+	})
+	f.Close()
+	bounded("OpenReader", func() []byte {
+		h := sha256.New()
+		r, err := d.OpenReader("large.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if _, err = io.Copy(h, r); err != nil {
+			t.Error(err)
+		}
+		return h.Sum(nil)
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
+
+func TestDisk_OpenWriter(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tFilename := "test.txt"
+	tData := bytes.Repeat([]byte("0123456789"), BlockSize/4)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile(tFilename, []byte("previous contents, longer than nothing"))
+	// Test
+	w, err := d.OpenWriter(tFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(w, bytes.NewReader(tData)); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err == nil {
+		t.Errorf("Expected FileNotOpenError on second Close, Got nil")
+	}
+	got, err := d.ReadFile(tFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(got, tData) {
+		t.Errorf("Expected %v bytes read back, Got %v", len(tData), len(got))
+	}
+	t.Run("flushError", func(t *testing.T) {
+		// Setup
+		w, _ := d.OpenWriter("quota.txt")
+		d.SetQuota("quota.txt", 10)
+		// Test
+		if _, err := w.Write(make([]byte, 20)); err != nil {
+			t.Errorf("Expected buffered write to succeed, Got %v", err)
+		}
+		err := w.Close()
+		if _, ok := err.(QuotaExceededError); !ok {
+			t.Errorf("Expected QuotaExceededError from Close, Got %v", err)
+		}
+		if d.checkIsOpen("quota.txt") {
+			t.Errorf("Expected file released after failed Close")
+		}
+	})
+	t.Run("partialFlush", func(t *testing.T) {
+		// Setup
+		d, _ := New("failing.disk", tBlockCt)
+		d.Close()
+		fd, _ := os.OpenFile("failing.disk", os.O_RDWR, 0)
+		dev := &failingDevice{fd, -1}
+		d, _ = MountDevice(dev)
+		w, _ := d.OpenWriter(tFilename)
+		tData := bytes.Repeat([]byte("partial "), BlockSize/4+1)
+		// Test
+		// the first block and the FAT land, the second block doesn't
+		dev.writes = 2
+		if n, err := w.Write(tData); err == nil || n != 0 {
+			t.Fatalf("Expected 0 bytes and a simulated failure, Got %v and %v", n, err)
+		}
+		dev.writes = -1
+		if _, err := w.Write(tData); err != nil {
+			t.Error(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		if got, _ := d.ReadFile(tFilename); !bytes.Equal(got, tData) {
+			t.Errorf("Expected %v bytes read back intact, Got %v", len(tData), len(got))
+		}
+		// Teardown
+		d.Close()
+		os.Remove("failing.disk")
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,108 @@
+package disk
+
+import "os"
+
+// Implemented by devices that can discard a byte range, leaving it reading
+// as zeros while releasing the storage behind it
+type HolePuncher interface {
+	PunchHole(offset, length int64) error
+}
+
+// Discards the storage behind every free data block, so the host can
+// reclaim it. Runs of free blocks are discarded together. Devices that
+// can't discard storage, and platforms or host filesystems without hole
+// punching, are left as they are without error.
+// Scope: exported
+func (d *Disk) Trim() error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	for _, extent := range d.freeExtents(fatBuff, 0) {
+		if err = d.punchHole(int64((d.dataStartInd+extent[0])*BlockSize), int64(extent[1]*BlockSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discards a byte range of the device, if it supports doing so
+// Scope: internal
+func (d *Disk) punchHole(offset, length int64) error {
+	switch dev := d.fd.(type) {
+	case HolePuncher:
+		return dev.PunchHole(offset, length)
+	case *os.File:
+		return punchFile(dev, offset, length)
+	}
+	return nil
+}
+
+// Zeroes length bytes of the file from offset on, leaving its size and
+// current offset unchanged; the range is cut at the file size. The zeros
+// are written like any other data, so reads of the range return zeros on
+// every device. Blocks the range covers completely are then discarded as
+// Trim discards free ones, releasing the host storage behind them where
+// the device supports it. They stay allocated to the file: the FAT has no
+// way to mark a hole, so the file takes as much space on the disk as
+// before. Encrypted files are only zeroed, see CreateEncrypted.
+// Compressed files fail with a CompressedWriteError.
+func (f *File) Discard(offset, length int) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if offset < 0 || length < 0 {
+		return CustomError{"Negative offset or length"}
+	}
+	if f.attr&AttrCompressed != 0 {
+		return CompressedWriteError{f.name}
+	}
+	end := offset + length
+	if end > f.size {
+		end = f.size
+	}
+	zeros := make([]byte, BlockSize)
+	for pos := offset; pos < end; {
+		// chunks end on block boundaries, so each write stays in one block
+		n := BlockSize - pos%BlockSize
+		if n > end-pos {
+			n = end - pos
+		}
+		if _, err := f.WriteAt(zeros[:n], pos); err != nil {
+			return err
+		}
+		pos += n
+	}
+	// an encrypted file's zeros are stored as ciphertext, which a hole
+	// wouldn't read back as
+	first, last := (offset+BlockSize-1)/BlockSize, end/BlockSize
+	if first >= last || f.cipher != nil {
+		return nil
+	}
+	// the writes moved any blocks a snapshot held to copies of the file's
+	// own, so the chain is read afterwards
+	d := f.disk
+	fatBuff, err := d.readFat()
+	if err != nil {
+		return err
+	}
+	blocks, err := d.chainBlocks(fatBuff, f.desc)
+	if err != nil {
+		return err
+	}
+	for i := first; i < last; {
+		// runs of consecutive blocks are discarded together
+		j := i + 1
+		for j < last && blocks[j] == blocks[j-1]+1 {
+			j++
+		}
+		if err = d.punchHole(int64((d.dataStartInd+blocks[i])*BlockSize), int64((j-i)*BlockSize)); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build linux
+// +build linux
+
+package disk
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// Punches a hole in the file without changing its size. Filesystems and
+// kernels without hole punching are treated as having nothing to do.
+// Scope: internal
+func punchFile(file *os.File, offset, length int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize|fallocPunchHole, offset, length)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
+
+// Discards a range of the mapped file; the mapping reads it back as zeros
+func (m *mmapDevice) PunchHole(offset, length int64) error {
+	return punchFile(m.file, offset, length)
+}
@@ -0,0 +1,123 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDisk_Trim(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tKeep := bytes.Repeat([]byte("kept"), BlockSize)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("keep.txt", tKeep)
+	d.WriteFile("gone.txt", bytes.Repeat([]byte("gone"), BlockSize))
+	rootBuff, _ := d.readRootDir()
+	goneStart := d.entryStartBlock(rootBuff[d.findRootEntry(rootBuff, "gone.txt"):])
+	d.Remove("gone.txt")
+	fStat, _ := d.fd.Stat()
+	// Test
+	if err := d.Trim(); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile("keep.txt"); !bytes.Equal(got, tKeep) {
+		t.Errorf("Expected allocated data to survive Trim")
+	}
+	trimmedStat, _ := d.fd.Stat()
+	if trimmedStat.Size() != fStat.Size() {
+		t.Errorf("Expected disk size %v, Got %v", fStat.Size(), trimmedStat.Size())
+	}
+	// freed data reads back as zeros wherever holes can be punched
+	block, _ := d.ReadBlock(d.dataStartInd + goneStart)
+	if !bytes.Equal(block, make([]byte, BlockSize)) && !bytes.HasPrefix(block, []byte("gone")) {
+		t.Errorf("Expected trimmed block to hold zeros or its old data")
+	}
+	// reused blocks still work after the hole
+	if err := d.WriteFile("new.txt", []byte("fresh")); err != nil {
+		t.Error(err)
+	}
+	if got, _ := d.ReadFile("new.txt"); string(got) != "fresh" {
+		t.Errorf("Expected %q, Got %q", "fresh", got)
+	}
+	d.Close()
+	if _, ok := d.Trim().(DiskClosedError); !ok {
+		t.Errorf("Expected DiskClosedError on closed disk")
+	}
+	// Teardown
+	os.Remove(tDiskFilename)
+}
+
+// Device recording the ranges it's asked to discard, zeroing them as a
+// host filesystem punching holes would
+type holeRecorder struct {
+	BlockDevice
+	holes *[][2]int64
+}
+
+func (h holeRecorder) PunchHole(offset, length int64) error {
+	*h.holes = append(*h.holes, [2]int64{offset, length})
+	_, err := h.BlockDevice.WriteAt(make([]byte, length), offset)
+	return err
+}
+
+func TestFile_Discard(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	tData := bytes.Repeat([]byte("x"), 4*BlockSize)
+	d, _ := New(tDiskFilename, tBlockCt)
+	d.WriteFile("test.txt", tData)
+	d.Close()
+	fd, _ := os.OpenFile(tDiskFilename, os.O_RDWR, 0)
+	var holes [][2]int64
+	d, _ = MountDevice(holeRecorder{fd, &holes})
+	f, _ := d.Open("test.txt")
+	f.offset = 7
+	// Test
+	if err := f.Discard(100, 3*BlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if f.size != len(tData) || f.offset != 7 {
+		t.Errorf("Expected size and offset unchanged, Got %v and %v", f.size, f.offset)
+	}
+	expected := append([]byte(nil), tData...)
+	copy(expected[100:100+3*BlockSize], make([]byte, 3*BlockSize))
+	got := make([]byte, len(tData))
+	f.ReadAt(got, 0)
+	if !bytes.Equal(got, expected) {
+		t.Errorf("Expected the discarded range to read as zeros")
+	}
+	// only the two blocks covered completely are discarded, as one run
+	fatBuff, _ := d.readFat()
+	blocks, _ := d.chainBlocks(fatBuff, f.desc)
+	want := [][2]int64{{int64((d.dataStartInd + blocks[1]) * BlockSize), 2 * BlockSize}}
+	if len(holes) != 1 || holes[0] != want[0] {
+		t.Errorf("Expected holes %v, Got %v", want, holes)
+	}
+	// the range is cut at the file size
+	if err := f.Discard(len(tData)-10, BlockSize); err != nil {
+		t.Error(err)
+	}
+	f.Close()
+	copy(expected[len(tData)-10:], make([]byte, 10))
+	if ok, err := d.VerifyFile("test.txt", expected); !ok {
+		t.Errorf("Expected the file intact up to its size, Got %v", err)
+	}
+	if problems, _ := d.Check(); len(problems) != 0 {
+		t.Errorf("Expected no problems, Got %v", problems)
+	}
+	f, _ = d.Open("test.txt")
+	if err := f.Discard(-1, 1); err == nil {
+		t.Errorf("Expected an error for a negative offset, Got nil")
+	}
+	f.Close()
+	packed, _ := d.CreateCompressed("packed.txt")
+	packed.Write([]byte("compress me"))
+	if _, ok := packed.Discard(0, 4).(CompressedWriteError); !ok {
+		t.Errorf("Expected CompressedWriteError for a compressed file")
+	}
+	packed.Close()
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}
@@ -0,0 +1,237 @@
+package disk
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Groups several file operations so they take effect together or not at
+// all, see Transaction
+type Tx struct {
+	disk *Disk // disk the operations apply to
+	done bool  // set once Transaction has returned
+}
+
+// Holds writes to the superblock, FAT, root directory and size table in
+// memory while a transaction runs, serving reads of those blocks from the
+// held images. Other writes past the root directory go straight to the
+// device.
+type txDevice struct {
+	BlockDevice                // device holding the disk image
+	limit       int64          // byte offset of the first data block
+	table       int64          // byte offset of the size table block, -1 if none
+	blocks      map[int][]byte // held images by absolute block index
+}
+
+// Runs fn, holding every change it makes to the superblock, FAT, root
+// directory and size table in memory, and stores the changes only if fn
+// returns nil; an error from fn discards them and is returned. The FAT and
+// root directory changes are stored with a single metadata update, so on a
+// journaled disk they land atomically even across a crash. The size table,
+// with the size records and IVs of files, and superblock changes, such as
+// an offset saved by OpenResume moving with Rename, are written once that
+// update is stored, and can be lost to a crash in between. Data blocks are
+// outside the transaction: file contents are written in place as fn runs
+// and aren't rolled back, so a discarded WriteFile over an existing file
+// can leave its blocks holding the new data. No file may be open when the
+// transaction starts or when fn returns. Transactions don't nest.
+// Returns: fn's error, or any error encountered storing the changes
+// Scope: exported
+func (d *Disk) Transaction(fn func(tx *Tx) error) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.tx != nil {
+		return CustomError{"Transaction already in progress"}
+	}
+	if err := d.checkNoneOpen(); err != nil {
+		return err
+	}
+	tableOff := int64(-1)
+	if d.sizeTableBlockCt() > 0 {
+		tableOff = int64(d.sizeTableInd() * BlockSize)
+	}
+	dev := &txDevice{d.fd, int64(d.dataStartInd * BlockSize), tableOff, make(map[int][]byte)}
+	d.fd, d.tx = dev, dev
+	tx := &Tx{disk: d}
+	committed := false
+	// a panic in fn still puts the device back
+	defer func() {
+		tx.done = true
+		if !committed {
+			d.endTransaction(dev)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := d.checkNoneOpen(); err != nil {
+		return err
+	}
+	committed = true
+	writes, err := dev.changed()
+	d.fd, d.tx = dev.BlockDevice, nil
+	if err != nil {
+		d.freeValid, d.mapValid = false, false
+		return err
+	}
+	// the journal has room for the FAT and root directory only
+	var superblock, table []byte
+	if len(writes) > 0 && writes[0].block == 0 {
+		superblock, writes = writes[0].data, writes[1:]
+	}
+	if n := len(writes); n > 0 && int64(writes[n-1].block*BlockSize) == dev.table {
+		table, writes = writes[n-1].data, writes[:n-1]
+	}
+	if len(writes) > 0 {
+		if err = d.writeMeta(writes...); err != nil {
+			d.freeValid, d.mapValid = false, false
+			return err
+		}
+	}
+	if table != nil {
+		if _, err = d.fd.WriteAt(table, dev.table); err != nil {
+			return err
+		}
+	}
+	if superblock != nil {
+		if err = d.writeSuperblock(superblock); err != nil {
+			return err
+		}
+		return d.sync()
+	}
+	return nil
+}
+
+// Puts the device back after a discarded transaction, dropping cached
+// state that followed the held blocks
+// Scope: internal
+func (d *Disk) endTransaction(dev *txDevice) {
+	d.fd, d.tx = dev.BlockDevice, nil
+	d.freeValid, d.mapValid, d.fragValid = false, false, false
+	if _, ok := dev.blocks[0]; ok {
+		// a primary damaged before the transaction is as damaged after it
+		d.readSuperblock()
+		d.fallBackToBackup()
+	}
+}
+
+// Reports the first open file, if any
+// Scope: internal
+func (d *Disk) checkNoneOpen() error {
+	for name, open := range d.open {
+		if open {
+			return FileAlreadyInUseError{name}
+		}
+	}
+	return nil
+}
+
+// Creates a new, empty file, as Create does, without leaving it open
+func (tx *Tx) Create(filename string) error {
+	if tx.done {
+		return CustomError{"Transaction already finished"}
+	}
+	file, err := tx.disk.Create(filename)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Replaces the contents of a file, as Disk.WriteFile does. The data is
+// written at once; only the file's chain and entry are held.
+func (tx *Tx) WriteFile(filename string, data []byte) error {
+	if tx.done {
+		return CustomError{"Transaction already finished"}
+	}
+	return tx.disk.WriteFile(filename, data)
+}
+
+// Removes a file, as Disk.Remove does
+func (tx *Tx) Remove(filename string) error {
+	if tx.done {
+		return CustomError{"Transaction already finished"}
+	}
+	return tx.disk.Remove(filename)
+}
+
+// Renames a file, as Disk.Rename does
+func (tx *Tx) Rename(filename, newName string) error {
+	if tx.done {
+		return CustomError{"Transaction already finished"}
+	}
+	return tx.disk.Rename(filename, newName)
+}
+
+// Reads from the device, overlaid with any held block images
+func (t *txDevice) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.BlockDevice.ReadAt(p, off)
+	end := off + int64(len(p))
+	if off >= t.limit && (t.table < 0 || end <= t.table || off >= t.table+BlockSize) {
+		return n, err
+	}
+	for block, image := range t.blocks {
+		start := int64(block * BlockSize)
+		if start+BlockSize <= off || start >= end {
+			continue
+		}
+		if start >= off {
+			copy(p[start-off:], image)
+		} else {
+			copy(p, image[off-start:])
+		}
+	}
+	return n, err
+}
+
+// Holds writes below the data region or to the size table as block
+// images, passing the rest through to the device
+func (t *txDevice) WriteAt(p []byte, off int64) (int, error) {
+	held := 0
+	for held < len(p) && t.holds(off+int64(held)) {
+		pos := off + int64(held)
+		block, within := int(pos/BlockSize), int(pos%BlockSize)
+		image, ok := t.blocks[block]
+		if !ok {
+			image = make([]byte, BlockSize)
+			if _, err := t.BlockDevice.ReadAt(image, int64(block*BlockSize)); err != nil {
+				return held, err
+			}
+			t.blocks[block] = image
+		}
+		held += copy(image[within:], p[held:])
+	}
+	if held == len(p) {
+		return held, nil
+	}
+	n, err := t.BlockDevice.WriteAt(p[held:], off+int64(held))
+	return held + n, err
+}
+
+// Reports whether writes at byte offset pos are held
+// Scope: internal
+func (t *txDevice) holds(pos int64) bool {
+	return pos < t.limit || t.table >= 0 && pos >= t.table && pos < t.table+BlockSize
+}
+
+// Lists the held blocks that differ from the device, in block order
+// Scope: internal
+func (t *txDevice) changed() ([]metaWrite, error) {
+	indexes := make([]int, 0, len(t.blocks))
+	for block := range t.blocks {
+		indexes = append(indexes, block)
+	}
+	sort.Ints(indexes)
+	current := make([]byte, BlockSize)
+	var writes []metaWrite
+	for _, block := range indexes {
+		if _, err := t.BlockDevice.ReadAt(current, int64(block*BlockSize)); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(current, t.blocks[block]) {
+			writes = append(writes, metaWrite{block, t.blocks[block]})
+		}
+	}
+	return writes, nil
+}
@@ -0,0 +1,143 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDisk_Transaction(t *testing.T) {
+	// Setup
+	tDiskFilename, tBlockCt := "test.disk", 64
+	d, _ := New(tDiskFilename, tBlockCt, WithJournal())
+	d.WriteFile("b.txt", []byte("remove me"))
+	d.WriteFile("c.txt", []byte("rename me"))
+	f, _ := d.OpenResume("c.txt")
+	f.offset = 4
+	f.Close()
+	free, _ := d.FreeBlocks()
+	ops := func(tx *Tx) error {
+		if err := tx.WriteFile("a.txt", []byte("new file")); err != nil {
+			return err
+		}
+		if err := tx.Remove("b.txt"); err != nil {
+			return err
+		}
+		return tx.Rename("c.txt", "d.txt")
+	}
+	// Test
+	t.Run("discard", func(t *testing.T) {
+		tErr := CustomError{"stop"}
+		if err := d.Transaction(func(tx *Tx) error {
+			if err := ops(tx); err != nil {
+				return err
+			}
+			// changes are visible within the transaction
+			if got, _ := d.ReadFile("d.txt"); string(got) != "rename me" {
+				t.Errorf("Expected the renamed file inside the transaction, Got %q", got)
+			}
+			return tErr
+		}); err != tErr {
+			t.Errorf("Expected fn's error, Got %v", err)
+		}
+		if _, err := d.ReadFile("a.txt"); err == nil {
+			t.Errorf("Expected a.txt discarded")
+		}
+		if got, _ := d.ReadFile("b.txt"); string(got) != "remove me" {
+			t.Errorf("Expected b.txt kept, Got %q", got)
+		}
+		if got, _ := d.ReadFile("c.txt"); string(got) != "rename me" {
+			t.Errorf("Expected c.txt under its old name, Got %q", got)
+		}
+		if after, _ := d.FreeBlocks(); after != free {
+			t.Errorf("Expected %v free blocks, Got %v", free, after)
+		}
+		// the offset key moved in the superblock is restored too
+		f, _ := d.OpenResume("c.txt")
+		if f.offset != 4 {
+			t.Errorf("Expected saved offset 4, Got %v", f.offset)
+		}
+		f.Close()
+	})
+	t.Run("commit", func(t *testing.T) {
+		if err := d.Transaction(ops); err != nil {
+			t.Fatal(err)
+		}
+		d.Close()
+		d, _ = Mount(tDiskFilename)
+		if got, _ := d.ReadFile("a.txt"); !bytes.Equal(got, []byte("new file")) {
+			t.Errorf("Expected a.txt stored, Got %q", got)
+		}
+		if _, err := d.ReadFile("b.txt"); err == nil {
+			t.Errorf("Expected b.txt removed")
+		}
+		if got, _ := d.ReadFile("d.txt"); string(got) != "rename me" {
+			t.Errorf("Expected c.txt renamed to d.txt, Got %q", got)
+		}
+		if problems, _ := d.Check(); len(problems) != 0 {
+			t.Errorf("Expected no problems, Got %v", problems)
+		}
+	})
+	t.Run("failed operation", func(t *testing.T) {
+		err := d.Transaction(func(tx *Tx) error {
+			if err := tx.Create("e.txt"); err != nil {
+				return err
+			}
+			return tx.Remove("missing.txt")
+		})
+		if _, ok := err.(FileNotFoundError); !ok {
+			t.Errorf("Expected FileNotFoundError, Got %v", err)
+		}
+		if _, err = d.ReadFile("e.txt"); err == nil {
+			t.Errorf("Expected e.txt discarded")
+		}
+	})
+	t.Run("size records", func(t *testing.T) {
+		d.WriteFile("x.txt", make([]byte, 10))
+		tErr := CustomError{"stop"}
+		if err := d.Transaction(func(tx *Tx) error {
+			if err := tx.WriteFile("x.txt", []byte("abc")); err != nil {
+				return err
+			}
+			return tErr
+		}); err != tErr {
+			t.Errorf("Expected fn's error, Got %v", err)
+		}
+		// a record left at the discarded size would pass for the file's
+		if err := d.RepairSizes(); err != nil {
+			t.Error(err)
+		}
+		if info, _ := d.Stat("x.txt"); info.Size() != 10 {
+			t.Errorf("Expected size 10 kept, Got %v", info.Size())
+		}
+	})
+	t.Run("misuse", func(t *testing.T) {
+		var kept *Tx
+		err := d.Transaction(func(tx *Tx) error {
+			kept = tx
+			return d.Transaction(func(*Tx) error { return nil })
+		})
+		if err == nil {
+			t.Errorf("Expected an error nesting transactions, Got nil")
+		}
+		if err = kept.Create("late.txt"); err == nil {
+			t.Errorf("Expected an error using a finished transaction, Got nil")
+		}
+		f, _ := d.Open("a.txt")
+		if _, ok := d.Transaction(ops).(FileAlreadyInUseError); !ok {
+			t.Errorf("Expected FileAlreadyInUseError with a file open")
+		}
+		f.Close()
+		err = d.Transaction(func(tx *Tx) error {
+			_, err := d.Open("a.txt")
+			return err
+		})
+		if _, ok := err.(FileAlreadyInUseError); !ok {
+			t.Errorf("Expected FileAlreadyInUseError for a file left open, Got %v", err)
+		}
+		d.CloseAll()
+	})
+	// Teardown
+	d.Close()
+	os.Remove(tDiskFilename)
+}